@@ -2,33 +2,119 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"text/tabwriter"
 	"time"
 
+	"github.com/liteseed/aogo"
 	"github.com/liteseed/goar/wallet"
 	"github.com/liteseed/sdk-go/contract"
 	"github.com/liteseed/transit/internal/bundler"
 	"github.com/liteseed/transit/internal/cron"
 	"github.com/liteseed/transit/internal/database"
+	"github.com/liteseed/transit/internal/database/schema"
+	"github.com/liteseed/transit/internal/gateway"
+	"github.com/liteseed/transit/internal/pricing"
 	"github.com/liteseed/transit/internal/server"
+	"github.com/liteseed/transit/internal/signer"
 	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 var Version string
 
 type StartConfig struct {
-	Database string
-	Driver   string
-	Gateway  string
-	Log      string
-	Port     string
-	Process  string
-	Signer   string
+	AdminKey         string
+	AlertWebhook     string
+	BalanceThreshold string
+	Backends         map[string]bundler.ServiceConfig // upload services by name
+	Broadcast        int                              // gateways a transaction is posted to
+	Bundler          bundler.Config
+	Bundles          cron.BundlePolicy
+	Confirmations    int
+	Cron             map[string]cron.JobConfig
+	Database         string
+	Driver           string
+	Fallback         bool // bundle the uploads no staker takes with the payout key
+	Fees             pricing.Config
+	Finality         int
+	Gateway          string // used when Gateways is empty
+	Gateways         []gateway.Config
+	Keys             KeysConfig
+	Log              string
+	Payers           cron.PayerPolicy
+	PaymentDeadline  int // seconds, 0 only expires orders past the deadline height of the bundler
+	Port             string
+	Prices           pricing.CacheConfig
+	Process          string
+	Reconcile        cron.ReconcilePolicy
+	Refunds          cron.RefundPolicy
+	Replication      server.ReplicationPolicy
+	Reports          cron.ReportPolicy // retries of the reports to the AO process on the uploads to its stakers
+	Routes           []bundler.Route   // uploads sent to the backends, the others go to the stakers of the contract
+	Signer           string            // JWK file of the keys not set in Keys
+	UploadAttempts   int               // stakers an upload is sent to before it fails
+}
+
+// KeysConfig splits the transit wallet into a data key, which signs the data posted unsigned,
+// and a payout key, which receives payments and signs payouts and refunds. To rotate the payout
+// key, set the new one and add the address of the old one to Retired, so it still receives the
+// payments of orders quoted before. The data key also messages the AO process through the sdk,
+// which signs with the key itself, so it must be a JWK file. Only the payout key may be held by
+// an external signer.
+type KeysConfig struct {
+	Data    signer.Config
+	Payout  signer.Config
+	Retired []string
+}
+
+// validate checks every key can be held where it is configured
+func (k KeysConfig) validate() error {
+	if k.Data.URL != "" {
+		return errors.New("keys: the data key must be a JWK file, the AO process is messaged with the key itself")
+	}
+	return nil
+}
+
+// gateways builds the pool of gateways the wallet client sends its requests to
+func gateways(config StartConfig) *gateway.Pool {
+	gateways := config.Gateways
+	if len(gateways) == 0 {
+		gateways = []gateway.Config{{URL: config.Gateway}}
+	}
+	p, err := gateway.New(gateways, gateway.WithBroadcast(config.Broadcast))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return p
+}
+
+// keys loads the data and payout keys, falling back to the Signer file
+func keys(config StartConfig) (*signer.File, signer.Signer) {
+	path := config.Keys.Data.Path
+	if path == "" {
+		path = config.Signer
+	}
+	data, err := signer.FromPath(path)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	c := config.Keys.Payout
+	if c.Path == "" && c.URL == "" {
+		c.Path = config.Signer
+	}
+	payout, err := signer.New(c)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return data, payout
 }
 
 func main() {
@@ -46,6 +132,15 @@ func main() {
 	if err != nil {
 		log.Fatalln(err)
 	}
+	err = config.Keys.validate()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "reconcile" {
+		reconcile(config, os.Args[2:])
+		return
+	}
 
 	l := slog.New(
 		slog.NewJSONHandler(
@@ -65,24 +160,63 @@ func main() {
 		log.Fatalln(err)
 	}
 
-	w, err := wallet.FromPath(config.Signer, config.Gateway)
+	pool := gateways(config)
+	w := &wallet.Wallet{Client: pool.Client()}
+	data, payout := keys(config)
+
+	fee, err := pricing.New(config.Fees)
 	if err != nil {
 		log.Fatalln(err)
 	}
+	prices := pricing.NewCache(w.Client, config.Prices)
 
-	b := bundler.New()
-	c := contract.New(config.Process, w.Signer)
+	b := bundler.NewLiteseed(bundler.WithConfig(config.Bundler), bundler.WithSigner(payout))
+	backends := map[string]bundler.Bundler{}
+	for name, c := range config.Backends {
+		backends[name] = bundler.NewService(c)
+	}
+	// The AO process is messaged with data items signed by the sdk, which needs the key itself
+	c := contract.New(config.Process, data.Signer())
+	ao, err := aogo.New()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	reporter := cron.NewContractReporter(c, ao, config.Process, data.Signer())
 
-	crn, err := cron.New(cron.WithBundler(b), cron.WithContracts(c), cron.WithDatabase(db), cron.WithWallet(w), cron.WithLogger(l))
+	crn, err := cron.New(
+		cron.WithAlertWebhook(config.AlertWebhook),
+		cron.WithBalanceThreshold(config.BalanceThreshold),
+		cron.WithBackends(backends),
+		cron.WithBundler(b),
+		cron.WithBundlePolicy(config.Bundles),
+		cron.WithConfirmations(config.Confirmations),
+		cron.WithContracts(c),
+		cron.WithDatabase(db),
+		cron.WithFeePolicy(fee),
+		cron.WithFinality(config.Finality),
+		cron.WithGateways(pool),
+		cron.WithWallet(w),
+		cron.WithLogger(l),
+		cron.WithPayerPolicy(config.Payers),
+		cron.WithPaymentDeadline(time.Duration(config.PaymentDeadline)*time.Second),
+		cron.WithPriceCache(prices),
+		cron.WithReconcilePolicy(config.Reconcile),
+		cron.WithRefundPolicy(config.Refunds),
+		cron.WithReporter(reporter),
+		cron.WithReportPolicy(config.Reports),
+		cron.WithRetiredAddresses(config.Keys.Retired),
+		cron.WithSigner(payout),
+		cron.WithJobs(config.Cron),
+	)
 	if err != nil {
 		log.Fatal(err)
 	}
-	err = crn.Setup("* * * * *")
+	err = crn.Setup()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	srv, err := server.New(config.Port, Version, server.WithBundler(b), server.WithContracts(c), server.WithDatabase(db), server.WithWallet(w))
+	srv, err := server.New(config.Port, Version, server.WithAdminKey(config.AdminKey), server.WithBackends(backends, config.Routes), server.WithBundler(b), server.WithContracts(c), server.WithCron(crn), server.WithDatabase(db), server.WithFallback(config.Fallback), server.WithFeePolicy(fee), server.WithPaymentAddress(payout.Address()), server.WithPaymentDeadline(time.Duration(config.PaymentDeadline)*time.Second), server.WithPriceCache(prices), server.WithReplicationPolicy(config.Replication), server.WithSigner(data), server.WithUploadAttempts(config.UploadAttempts), server.WithWallet(w))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -104,3 +238,43 @@ func main() {
 	}
 	time.Sleep(2 * time.Second)
 }
+
+// reconcile prints the discrepancies found by the last reconciliation. With -run it
+// reconciles the wallet history with the database first.
+//
+//	transit reconcile [-run] [-kind missing-payout]
+func reconcile(config StartConfig, args []string) {
+	flags := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	run := flags.Bool("run", false, "reconcile the wallet history before printing the discrepancies")
+	kind := flags.String("kind", "", "only print the discrepancies of this kind")
+	err := flags.Parse(args)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	db, err := database.New(config.Driver, config.Database)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	if *run {
+		_, payout := keys(config)
+		w := &wallet.Wallet{Client: gateways(config).Client()}
+		crn, err := cron.New(cron.WithDatabase(db), cron.WithWallet(w), cron.WithReconcilePolicy(config.Reconcile), cron.WithRetiredAddresses(config.Keys.Retired), cron.WithSigner(payout), cron.WithJobs(config.Cron))
+		if err != nil {
+			log.Fatalln(err)
+		}
+		crn.Reconcile()
+	}
+
+	discrepancies, err := db.GetDiscrepancies(&schema.Discrepancy{Kind: schema.DiscrepancyKind(*kind)}, -1)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KIND\tORDER\tTRANSACTION\tEXPECTED\tACTUAL")
+	for _, d := range discrepancies {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", d.Kind, d.OrderId, d.TransactionId, d.Expected, d.Actual)
+	}
+	tw.Flush()
+}
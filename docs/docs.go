@@ -21,7 +21,7 @@ const docTemplate = `{
     "paths": {
         "/price/{bytes}": {
             "get": {
-                "description": "Get the current price of data upload using the Liteseed Network.\nIt returns the price of upload in wei and the address to pay.",
+                "description": "Get the current price of data upload using the Liteseed Network.\nIt returns the price of upload in winston, split into the network cost and the transit fee, and the address to pay.\nThe fee depends on the size of the upload and the api key sent in the X-API-Key header.\nEvery staker asked to hold a replica with the X-Replicas header is paid as an upload of its own.\nUploads routed to an upload service are priced by the service and are not replicated.",
                 "consumes": [
                     "application/json"
                 ],
@@ -34,13 +34,26 @@ const docTemplate = `{
                 "summary": "Get price of upload",
                 "parameters": [
                     {
-                        "maximum": 2147483647,
+                        "maximum": 4294967295,
                         "minimum": 1,
                         "type": "integer",
                         "description": "Size of Data",
                         "name": "bytes",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "api key",
+                        "name": "X-API-Key",
+                        "in": "header"
+                    },
+                    {
+                        "minimum": 1,
+                        "type": "integer",
+                        "description": "Stakers to hold the data item",
+                        "name": "X-Replicas",
+                        "in": "header"
                     }
                 ],
                 "responses": {
@@ -84,6 +97,15 @@ const docTemplate = `{
                     "Upload"
                 ],
                 "summary": "Post a data-item",
+                "parameters": [
+                    {
+                        "minimum": 1,
+                        "type": "integer",
+                        "description": "Stakers to hold the data item until it is final",
+                        "name": "X-Replicas",
+                        "in": "header"
+                    }
+                ],
                 "responses": {
                     "200": {
                         "description": "OK",
@@ -97,6 +119,24 @@ const docTemplate = `{
                             "$ref": "#/definitions/server.HTTPError"
                         }
                     },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/server.HTTPError"
+                        }
+                    },
+                    "413": {
+                        "description": "Request Entity Too Large",
+                        "schema": {
+                            "$ref": "#/definitions/server.HTTPError"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/server.HTTPError"
+                        }
+                    },
                     "424": {
                         "description": "Failed Dependency",
                         "schema": {
@@ -147,6 +187,12 @@ const docTemplate = `{
                             "$ref": "#/definitions/server.HTTPError"
                         }
                     },
+                    "410": {
+                        "description": "Gone",
+                        "schema": {
+                            "$ref": "#/definitions/server.HTTPError"
+                        }
+                    },
                     "424": {
                         "description": "Failed Dependency",
                         "schema": {
@@ -164,7 +210,7 @@ const docTemplate = `{
         },
         "/tx/{id}/{field}": {
             "get": {
-                "description": "If you choose to skip field  the whole data-item is returned.\nGet only the specified field of a posted data-item.\nIn case the specified field is data it tries to automatically detect mime-type.\nYou can specify the response mime-type by either sending a mime-type query parameter or an accept header in the request.\nSupported mime-type are listed here - https://github.com/gabriel-vasile/mimetype/blob/master/supported_mimes.md.\nIf all else fails defaults to ` + "`" + `application/octet-stream` + "`" + `",
+                "description": "Get only the specified field of a posted data-item.\nIn case the specified field is data it tries to automatically detect mime-type.\nYou can specify the response mime-type by either sending a mime-type query parameter or an accept header in the request.\nSupported mime-type are listed here - https://github.com/gabriel-vasile/mimetype/blob/master/supported_mimes.md.\nIf all else fails defaults to ` + "`" + `application/octet-stream` + "`" + `",
                 "consumes": [
                     "application/json"
                 ],
@@ -217,7 +263,7 @@ const docTemplate = `{
         },
         "/tx/{id}/{payment_id}": {
             "put": {
-                "description": "Once a payment is made send a transaction id for a data-item",
+                "description": "Once a payment is made send a transaction id for a data-item\nPayments sent after the payment deadline of the data-item are rejected\nPayments tagged with Data-Item-Id are discovered without this call\nA payment can pay for several data-items up to its quantity\nA partially paid data-item is topped up by sending another payment id",
                 "consumes": [
                     "application/json"
                 ],
@@ -262,6 +308,74 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/server.HTTPError"
                         }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/server.HTTPError"
+                        }
+                    },
+                    "410": {
+                        "description": "Gone",
+                        "schema": {
+                            "$ref": "#/definitions/server.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/v2/tx/{id}/status": {
+            "get": {
+                "description": "Get the current status of a posted data-item and of its payment.\nStatus \"created\", \"queued\", \"sent\", \"confirmed\", \"failed\", \"invalid\"\nPayment \"unpaid\", \"partial\", \"confirmed\", \"paid\", \"invalid\"\nA partially paid data-item reports the winston still missing in shortfall",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Fetch"
+                ],
+                "summary": "Get the status of a data-item and of its payment",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "id of the data-item",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/server.DataItemStatusGetResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/server.HTTPError"
+                        }
+                    },
+                    "410": {
+                        "description": "Gone",
+                        "schema": {
+                            "$ref": "#/definitions/server.HTTPError"
+                        }
+                    },
+                    "424": {
+                        "description": "Failed Dependency",
+                        "schema": {
+                            "$ref": "#/definitions/server.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/server.HTTPError"
+                        }
                     }
                 }
             }
@@ -279,6 +393,26 @@ const docTemplate = `{
                 }
             }
         },
+        "server.DataItemStatusGetResponse": {
+            "type": "object",
+            "properties": {
+                "payment": {
+                    "type": "string"
+                },
+                "price": {
+                    "type": "string"
+                },
+                "received": {
+                    "type": "string"
+                },
+                "shortfall": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string"
+                }
+            }
+        },
         "server.HTTPError": {
             "type": "object",
             "properties": {
@@ -329,10 +463,20 @@ const docTemplate = `{
                     "format": "string",
                     "example": "Cbj95zDZBBhmyht6iFlEf7xmSCSVZGw436V6HWmm9Ek"
                 },
-                "price": {
+                "base": {
                     "type": "string",
                     "format": "string",
                     "example": "1000000000000"
+                },
+                "fee": {
+                    "type": "string",
+                    "format": "string",
+                    "example": "1000000000"
+                },
+                "price": {
+                    "type": "string",
+                    "format": "string",
+                    "example": "1001000000000"
                 }
             }
         }
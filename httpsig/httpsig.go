@@ -0,0 +1,212 @@
+// Package httpsig signs the requests transit sends to bundler nodes and verifies them on the
+// node side. It follows HTTP Message Signatures (RFC 9421): the signature covers the method,
+// the authority, the path and the Content-Digest (RFC 9530) of the body, with the time of
+// signing, so a request signed for one node can not be replayed on another. Requests are
+// signed with an Arweave key, RSA-PSS over the SHA-256 digest of the signature base, and carry
+// the owner of the key in keyid so nodes only need to know the address of transit.
+package httpsig
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/liteseed/goar/crypto"
+)
+
+// Label names the signature of transit in the Signature-Input and Signature headers
+const Label = "transit"
+
+// components are the parts of a request covered by the signature, in this order
+const components = `("@method" "@authority" "@path" "content-digest")`
+
+var (
+	ErrMissing = errors.New("httpsig: request not signed")
+	ErrInvalid = errors.New("httpsig: invalid signature")
+)
+
+// Signer holds the Arweave key requests are signed with. Sign returns the RSA-PSS signature
+// of the SHA-256 digest of payload.
+type Signer interface {
+	Owner() string
+	Sign(payload []byte) ([]byte, error)
+}
+
+// Sign adds the Content-Digest, Signature-Input and Signature headers to r, whose body is body
+func Sign(r *http.Request, body []byte, s Signer, now time.Time) error {
+	digest := Digest(body)
+	params := fmt.Sprintf("%s;created=%d;keyid=%q", components, now.Unix(), s.Owner())
+	signature, err := s.Sign([]byte(base(r.Method, authority(r), path(r), digest, params)))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Digest", digest)
+	r.Header.Set("Signature-Input", Label+"="+params)
+	r.Header.Set("Signature", Label+"=:"+base64.StdEncoding.EncodeToString(signature)+":")
+	return nil
+}
+
+// Digest returns the Content-Digest of a body
+func Digest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+}
+
+// Verifier checks the requests of transit on a bundler node
+type Verifier struct {
+	addresses []string
+	maxAge    time.Duration
+	now       func() time.Time
+}
+
+// NewVerifier accepts the requests signed by one of the keys of addresses at most maxAge
+// before or after they are received. Give the addresses of the retired keys of transit too
+// while their requests may still come in.
+func NewVerifier(maxAge time.Duration, addresses ...string) *Verifier {
+	return &Verifier{addresses: addresses, maxAge: maxAge, now: time.Now}
+}
+
+// Verify checks the signature of r and the digest of its body, which it reads and restores.
+// It returns the address of the key that signed r.
+func (v *Verifier) Verify(r *http.Request) (string, error) {
+	input, ok := member(r.Header.Get("Signature-Input"))
+	if !ok {
+		return "", ErrMissing
+	}
+	value, ok := member(r.Header.Get("Signature"))
+	if !ok {
+		return "", ErrMissing
+	}
+
+	created, owner, err := parse(input)
+	if err != nil {
+		return "", err
+	}
+	address, err := crypto.GetAddressFromOwner(owner)
+	if err != nil || !slices.Contains(v.addresses, address) {
+		return "", fmt.Errorf("%w: unknown key", ErrInvalid)
+	}
+	age := v.now().Sub(time.Unix(created, 0))
+	if age > v.maxAge || age < -v.maxAge {
+		return "", fmt.Errorf("%w: created %s ago", ErrInvalid, age.Round(time.Second))
+	}
+
+	var body []byte
+	if r.Body != nil {
+		body, err = io.ReadAll(r.Body)
+		if err != nil {
+			return "", err
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	digest := Digest(body)
+	if r.Header.Get("Content-Digest") != digest {
+		return "", fmt.Errorf("%w: content digest", ErrInvalid)
+	}
+
+	if !strings.HasPrefix(value, ":") || !strings.HasSuffix(value, ":") || len(value) < 2 {
+		return "", fmt.Errorf("%w: signature encoding", ErrInvalid)
+	}
+	signature, err := base64.StdEncoding.DecodeString(value[1 : len(value)-1])
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+	publicKey, err := crypto.GetPublicKeyFromOwner(owner)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+	err = crypto.Verify([]byte(base(r.Method, authority(r), path(r), digest, input)), signature, publicKey)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+	return address, nil
+}
+
+// Handler lets through to next the requests that pass Verify and answers the others with 401
+func (v *Verifier) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := v.Verify(r); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// base is the signature base of RFC 9421 for the components transit signs
+func base(method string, authority string, path string, digest string, params string) string {
+	return `"@method": ` + method + "\n" +
+		`"@authority": ` + authority + "\n" +
+		`"@path": ` + path + "\n" +
+		`"content-digest": ` + digest + "\n" +
+		`"@signature-params": ` + params
+}
+
+// authority is the host the request is sent to, as in its Host header, which is set on
+// both sides of the request
+func authority(r *http.Request) string {
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+	return strings.ToLower(host)
+}
+
+func path(r *http.Request) string {
+	if p := r.URL.EscapedPath(); p != "" {
+		return p
+	}
+	return "/"
+}
+
+// member returns the value of the transit member of a dictionary header. The values transit
+// signs hold no commas, so the members are split on them.
+func member(header string) (string, bool) {
+	for _, m := range strings.Split(header, ",") {
+		label, value, ok := strings.Cut(strings.TrimSpace(m), "=")
+		if ok && label == Label {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// parse reads the created and keyid parameters of a Signature-Input covering the components
+func parse(input string) (int64, string, error) {
+	params, ok := strings.CutPrefix(input, components)
+	if !ok {
+		return 0, "", fmt.Errorf("%w: components", ErrInvalid)
+	}
+	var created int64
+	var owner string
+	for _, p := range strings.Split(params, ";")[1:] {
+		name, value, _ := strings.Cut(p, "=")
+		switch name {
+		case "created":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return 0, "", fmt.Errorf("%w: created", ErrInvalid)
+			}
+			created = n
+		case "keyid":
+			s, err := strconv.Unquote(value)
+			if err != nil {
+				return 0, "", fmt.Errorf("%w: keyid", ErrInvalid)
+			}
+			owner = s
+		}
+	}
+	if created == 0 || owner == "" {
+		return 0, "", fmt.Errorf("%w: missing parameters", ErrInvalid)
+	}
+	return created, owner, nil
+}
@@ -0,0 +1,83 @@
+package httpsig
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/liteseed/transit/internal/signer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignature(t *testing.T) {
+	s, err := signer.FromPath("../test/signer.json")
+	assert.NoError(t, err)
+	now := time.Unix(1700000000, 0)
+	v := NewVerifier(time.Minute, s.Address())
+	v.now = func() time.Time { return now }
+
+	request := func(method string, path string, body []byte) *http.Request {
+		r := httptest.NewRequest(method, "http://node.test"+path, bytes.NewReader(body))
+		assert.NoError(t, Sign(r, body, s, now))
+		return r
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		r := request(http.MethodPost, "/tx", []byte("data"))
+		address, err := v.Verify(r)
+		assert.NoError(t, err)
+		assert.Equal(t, s.Address(), address)
+
+		// The body is still there for the handler
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "data", string(body))
+
+		_, err = v.Verify(request(http.MethodPut, "/tx/id/payment", nil))
+		assert.NoError(t, err)
+	})
+
+	t.Run("Fail:Missing", func(t *testing.T) {
+		_, err := v.Verify(httptest.NewRequest(http.MethodPut, "/tx/id/payment", nil))
+		assert.ErrorIs(t, err, ErrMissing)
+	})
+
+	t.Run("Fail:Tampered", func(t *testing.T) {
+		r := request(http.MethodPut, "/tx/id/payment", nil)
+		r.URL.Path = "/tx/id/other"
+		_, err := v.Verify(r)
+		assert.ErrorIs(t, err, ErrInvalid)
+
+		r = request(http.MethodPost, "/tx", []byte("data"))
+		r.Body = io.NopCloser(strings.NewReader("other"))
+		_, err = v.Verify(r)
+		assert.ErrorIs(t, err, ErrInvalid)
+
+		r = request(http.MethodPost, "/tx", []byte("data"))
+		r.Method = http.MethodPut
+		_, err = v.Verify(r)
+		assert.ErrorIs(t, err, ErrInvalid)
+
+		r = request(http.MethodPut, "/tx/id/payment", nil)
+		r.Host = "other.test"
+		_, err = v.Verify(r)
+		assert.ErrorIs(t, err, ErrInvalid)
+	})
+
+	t.Run("Fail:Expired", func(t *testing.T) {
+		r := request(http.MethodPut, "/tx/id/payment", nil)
+		v := NewVerifier(time.Minute, s.Address())
+		v.now = func() time.Time { return now.Add(2 * time.Minute) }
+		_, err := v.Verify(r)
+		assert.ErrorIs(t, err, ErrInvalid)
+	})
+
+	t.Run("Fail:UnknownKey", func(t *testing.T) {
+		_, err := NewVerifier(time.Minute, "other").Verify(request(http.MethodPut, "/tx/id/payment", nil))
+		assert.ErrorIs(t, err, ErrInvalid)
+	})
+}
@@ -0,0 +1,71 @@
+package bundler
+
+import (
+	"sync"
+	"time"
+)
+
+// circuit stops requests to a staker after consecutive failures. Once the cooldown is
+// over a single request is let through, which closes the circuit if it succeeds.
+type circuit struct {
+	policy BreakerPolicy
+	now    func() time.Time
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func (c *circuit) allow() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.policy.Failures <= 0 || c.failures < c.policy.Failures {
+		return true
+	}
+	if c.probing || c.now().Sub(c.openedAt) < c.policy.Cooldown {
+		return false
+	}
+	c.probing = true
+	return true
+}
+
+func (c *circuit) record(success bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.probing = false
+	if success {
+		c.failures = 0
+		return
+	}
+	c.failures++
+	if c.policy.Failures > 0 && c.failures >= c.policy.Failures {
+		c.openedAt = c.now()
+	}
+}
+
+func (c *circuit) open() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.policy.Failures > 0 && c.failures >= c.policy.Failures
+}
+
+// circuit returns the circuit of the staker at url
+func (b *Liteseed) circuit(url string) *circuit {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.circuits == nil {
+		b.circuits = map[string]*circuit{}
+	}
+	c, ok := b.circuits[url]
+	if !ok {
+		c = &circuit{policy: b.breaker, now: b.now}
+		b.circuits[url] = c
+	}
+	return c
+}
+
+// Open tells whether requests to the staker at url are currently refused
+func (b *Liteseed) Open(url string) bool {
+	return b.circuit(url).open()
+}
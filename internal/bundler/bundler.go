@@ -1,12 +1,36 @@
 package bundler
 
 import (
-	"encoding/json"
-	"net/http"
+	"io"
+	"slices"
 )
 
-type Bundler struct {
-	client *http.Client
+// Bundler is a backend data items are uploaded to and read back from. The url of a data
+// item is where the backend placed it, the node of its staker for the Liteseed backend.
+// DataItemGet streams the data item, which the caller closes.
+type Bundler interface {
+	DataItemPost(url string, data []byte) (*DataItemPostResponse, error)
+	DataItemPut(url string, id string, paymentID string) (*DataItemPutResponse, error)
+	DataItemGet(url string, id string) (io.ReadCloser, error)
+	DataItemStatusGet(url string, id string) ([]byte, error)
+
+	// Payout reports whether the stakers holding the data items are paid by transit for
+	// every upload. Other backends are paid for out of band.
+	Payout() bool
+	// Healthy reports whether uploads can be sent to url
+	Healthy(url string) bool
+	// Track records the address of the staker at url
+	Track(address string, url string)
+	// Stakers returns the health of the stakers the backend sent requests to
+	Stakers() []Health
+	// Probe checks the backend answers and returns the URLs that did not
+	Probe() []string
+}
+
+// Pricer is a backend that charges its own price for uploads, in winston. The network cost
+// of uploads to other backends is the price of the gateway.
+type Pricer interface {
+	Price(size int) (string, error)
 }
 
 type DataItemPostResponse struct {
@@ -23,44 +47,29 @@ type DataItemPutResponse struct {
 	PaymentID string `json:"payment_id"`
 }
 
-func New() *Bundler {
-	return &Bundler{
-		client: http.DefaultClient,
-	}
-}
-
-func (b *Bundler) DataItemGet(url string, id string) ([]byte, error) {
-	return b.get(url + "/" + "tx" + "/" + id)
+// Route sends the uploads it matches to a backend by name. Uploads match when their size
+// is within [MinSize, MaxSize] and, if ApiKeys is set, their api key is one of them.
+// A MaxSize of 0 matches any size.
+type Route struct {
+	Backend string
+	MinSize int
+	MaxSize int
+	ApiKeys []string
 }
 
-func (b *Bundler) DataItemPost(url string, data []byte) (*DataItemPostResponse, error) {
-	data, err := b.post(url+"/"+"tx", data)
-	if err != nil {
-		return nil, err
-	}
-	var res DataItemPostResponse
-	if err = json.Unmarshal(data, &res); err != nil {
-		return nil, err
-	}
-	return &res, nil
-}
-
-func (b *Bundler) DataItemPut(url string, id string, paymentID string) (*DataItemPutResponse, error) {
-	data, err := b.put(url+ "/" + "tx" +"/"+id+"/"+paymentID, "", nil)
-	if err != nil {
-		return nil, err
-	}
-	var res DataItemPutResponse
-	if err = json.Unmarshal(data, &res); err != nil {
-		return nil, err
+func (r *Route) match(size int, apiKey string) bool {
+	if size < r.MinSize || (r.MaxSize > 0 && size > r.MaxSize) {
+		return false
 	}
-	return &res, nil
+	return len(r.ApiKeys) == 0 || slices.Contains(r.ApiKeys, apiKey)
 }
 
-func (b *Bundler) DataItemStatusGet(url string, id string) ([]byte, error) {
-	data, err := b.get(url + "/" + "tx" + "/" + id + "/" + "status")
-	if err != nil {
-		return nil, err
+// Match returns the backend of the first route matching an upload, empty if none does
+func Match(routes []Route, size int, apiKey string) string {
+	for _, r := range routes {
+		if r.match(size, apiKey) {
+			return r.Backend
+		}
 	}
-	return data, nil
+	return ""
 }
@@ -1,13 +1,20 @@
 package bundler
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/liteseed/goar/crypto"
+	"github.com/liteseed/transit/httpsig"
+	"github.com/liteseed/transit/internal/signer"
+	"github.com/liteseed/transit/internal/utils"
 	"github.com/liteseed/transit/test"
 	"github.com/stretchr/testify/assert"
 )
@@ -33,13 +40,19 @@ func TestDataPost(t *testing.T) {
 
 	t.Run("Success", func(t *testing.T) {
 
-		b := Bundler{
-			client: http.DefaultClient,
-		}
+		b := NewLiteseed(WithURLPolicy(utils.DevURLPolicy))
 		res, err := b.DataItemPost(bun.URL[7:], d.Raw)
 		assert.NoError(t, err)
 		assert.Equal(t, expectedRes, *res)
 	})
+
+	t.Run("Fail:URLPolicy", func(t *testing.T) {
+		// The staker runs on a loopback address, which stakers are not allowed by default
+		b := NewLiteseed()
+		_, err := b.DataItemPost(bun.URL[7:], d.Raw)
+		assert.ErrorIs(t, err, ErrUnavailable)
+		assert.ErrorIs(t, err, utils.ErrURLNotAllowed)
+	})
 }
 
 func TestDataPut(t *testing.T) {
@@ -62,11 +75,265 @@ func TestDataPut(t *testing.T) {
 
 	t.Run("Success", func(t *testing.T) {
 
-		b := Bundler{
-			client: http.DefaultClient,
-		}
+		b := NewLiteseed(WithURLPolicy(utils.DevURLPolicy))
 		res, err := b.DataItemPut(bun.URL[7:], dID, txID)
 		assert.NoError(t, err)
 		assert.Equal(t, expectedRes, *res)
 	})
+
+	t.Run("Success:Signed", func(t *testing.T) {
+		s, err := signer.FromPath("../../test/signer.json")
+		assert.NoError(t, err)
+		v := httpsig.NewVerifier(time.Minute, s.Address())
+		node := httptest.NewServer(v.Handler(bun.Config.Handler))
+		defer node.Close()
+
+		_, err = NewLiteseed(WithURLPolicy(utils.DevURLPolicy)).DataItemPut(node.URL[7:], dID, txID)
+		assert.ErrorIs(t, err, ErrClient)
+
+		b := NewLiteseed(WithURLPolicy(utils.DevURLPolicy), WithSigner(s))
+		res, err := b.DataItemPut(node.URL[7:], dID, txID)
+		assert.NoError(t, err)
+		assert.Equal(t, expectedRes, *res)
+	})
+}
+
+func TestRetry(t *testing.T) {
+	var calls atomic.Int32
+	bun := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("sent"))
+	}))
+	defer bun.Close()
+
+	b := NewLiteseed(WithURLPolicy(utils.DevURLPolicy), WithRetryPolicy(RetryPolicy{Attempts: 3, Backoff: time.Millisecond}))
+
+	t.Run("Success:GET", func(t *testing.T) {
+		res, err := b.DataItemStatusGet(bun.URL[7:], "id")
+		assert.NoError(t, err)
+		assert.Equal(t, "sent", string(res))
+		assert.Equal(t, int32(3), calls.Load())
+	})
+
+	t.Run("Fail:POST", func(t *testing.T) {
+		calls.Store(0)
+		_, err := b.DataItemPost(bun.URL[7:], []byte("data"))
+		assert.ErrorIs(t, err, ErrServer)
+		assert.Equal(t, int32(1), calls.Load())
+	})
+}
+
+func TestErrors(t *testing.T) {
+	var delay time.Duration
+	bun := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		switch r.URL.Path {
+		case "/tx/missing/status":
+			w.WriteHeader(http.StatusNotFound)
+		case "/tx":
+			w.Write([]byte("invalid"))
+		default:
+			w.Write([]byte("sent"))
+		}
+	}))
+	defer bun.Close()
+
+	b := NewLiteseed(WithURLPolicy(utils.DevURLPolicy), WithTimeouts(Timeouts{Status: 20 * time.Millisecond, Post: time.Second}), WithRetryPolicy(RetryPolicy{}))
+
+	_, err := b.DataItemStatusGet(bun.URL[7:], "missing")
+	assert.ErrorIs(t, err, ErrClient)
+	var e *Error
+	assert.ErrorAs(t, err, &e)
+	assert.Equal(t, http.StatusNotFound, e.Status)
+
+	_, err = b.DataItemPost(bun.URL[7:], []byte("data"))
+	assert.ErrorIs(t, err, ErrDecode)
+
+	delay = 50 * time.Millisecond
+	_, err = b.DataItemStatusGet(bun.URL[7:], "id")
+	assert.ErrorIs(t, err, ErrTimeout)
+}
+
+func TestBreaker(t *testing.T) {
+	var calls atomic.Int32
+	var healthy atomic.Bool
+	bun := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte("sent"))
+	}))
+	defer bun.Close()
+
+	now := time.Unix(0, 0)
+	b := NewLiteseed(WithURLPolicy(utils.DevURLPolicy), WithRetryPolicy(RetryPolicy{}), WithBreakerPolicy(BreakerPolicy{Failures: 2, Cooldown: time.Minute}))
+	b.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		_, err := b.DataItemStatusGet(bun.URL[7:], "id")
+		assert.ErrorIs(t, err, ErrServer)
+	}
+	assert.True(t, b.Open(bun.URL[7:]))
+
+	_, err := b.DataItemStatusGet(bun.URL[7:], "id")
+	assert.ErrorIs(t, err, ErrUnavailable)
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, int32(2), calls.Load())
+
+	// A single request is let through once the cooldown is over
+	now = now.Add(time.Minute)
+	healthy.Store(true)
+	res, err := b.DataItemStatusGet(bun.URL[7:], "id")
+	assert.NoError(t, err)
+	assert.Equal(t, "sent", string(res))
+	assert.False(t, b.Open(bun.URL[7:]))
+}
+
+func TestHealth(t *testing.T) {
+	var healthy atomic.Bool
+	bun := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("sent"))
+	}))
+	defer bun.Close()
+
+	now := time.Unix(0, 0)
+	b := NewLiteseed(WithURLPolicy(utils.DevURLPolicy),
+		WithRetryPolicy(RetryPolicy{}),
+		WithBreakerPolicy(BreakerPolicy{}),
+		WithHealthPolicy(HealthPolicy{Threshold: 0.5, MinSamples: 3, Latency: time.Second, Quarantine: time.Minute}),
+	)
+	b.now = func() time.Time { return now }
+	staker := bun.URL[7:]
+	b.Track("staker", staker)
+
+	// The score drops below the threshold after the fourth failure in a row
+	for i := 0; i < 4; i++ {
+		assert.True(t, b.Healthy(staker))
+		_, err := b.DataItemStatusGet(staker, "id")
+		assert.ErrorIs(t, err, ErrServer)
+	}
+	assert.False(t, b.Healthy(staker))
+	assert.Equal(t, []string{staker}, b.Probe())
+
+	s := b.Stakers()
+	assert.Len(t, s, 1)
+	assert.Equal(t, "staker", s[0].Address)
+	assert.Equal(t, int64(4), s[0].Requests)
+	assert.Equal(t, int64(4), s[0].Failures)
+	assert.False(t, s[0].ProbeOK)
+	assert.Equal(t, now.Add(time.Minute), s[0].Quarantined)
+
+	// A staker leaving quarantine starts over
+	healthy.Store(true)
+	now = now.Add(time.Minute)
+	assert.True(t, b.Healthy(staker))
+	assert.Empty(t, b.Probe())
+	assert.Equal(t, 1.0, b.Stakers()[0].Score)
+}
+
+func TestService(t *testing.T) {
+	d := test.DataItem()
+	var prices atomic.Int32
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/tx/arweave":
+			fmt.Fprintf(w, `{"id":"%s","owner":"owner","dataCaches":["arweave.net"],"deadlineHeight":200,"fastFinalityIndexes":[],"version":"1.0.0"}`, d.ID)
+		case r.URL.Path == "/price/arweave/1000":
+			prices.Add(1)
+			w.Write([]byte("12345"))
+		case r.URL.Path == "/price/arweave/0":
+			w.Write([]byte("free"))
+		case r.URL.Path == "/tx/"+d.ID+"/data":
+			w.Write(d.Raw)
+		case r.URL.Path == "/tx/"+d.ID+"/status":
+			w.Write([]byte(`{"status":"FINALIZED","bundleId":"bundle"}`))
+		case r.URL.Path == "/info":
+			w.Write([]byte(`{"version":"1.0.0"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer service.Close()
+
+	s := NewService(ServiceConfig{URL: service.URL + "/"})
+	assert.False(t, s.Payout())
+
+	t.Run("Post", func(t *testing.T) {
+		res, err := s.DataItemPost("", d.Raw)
+		assert.NoError(t, err)
+		assert.Equal(t, d.ID, res.ID)
+		assert.Equal(t, uint(200), res.DeadlineHeight)
+
+		item, err := s.DataItemGet("", d.ID)
+		assert.NoError(t, err)
+		raw, err := io.ReadAll(item)
+		assert.NoError(t, err)
+		assert.NoError(t, item.Close())
+		assert.Equal(t, d.Raw, raw)
+
+		status, err := s.DataItemStatusGet("", d.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "FINALIZED", string(status))
+	})
+
+	t.Run("Price", func(t *testing.T) {
+		for range 2 {
+			p, err := s.Price(1000)
+			assert.NoError(t, err)
+			assert.Equal(t, "12345", p)
+		}
+		assert.Equal(t, int32(1), prices.Load())
+
+		_, err := s.GetTransactionPrice(0, "")
+		assert.ErrorIs(t, err, ErrDecode)
+	})
+
+	t.Run("Probe", func(t *testing.T) {
+		assert.Empty(t, s.Probe())
+		assert.Equal(t, []string{"http://127.0.0.1:1"}, NewService(ServiceConfig{URL: "http://127.0.0.1:1", Timeout: 1}).Probe())
+	})
+}
+
+func TestReadHeader(t *testing.T) {
+	d := test.DataItem()
+
+	r := bytes.NewReader(d.Raw)
+	header, err := ReadHeader(r)
+	assert.NoError(t, err)
+	assert.Equal(t, d.ID, header.ID)
+	assert.Equal(t, d.Signature, header.Signature)
+	assert.Equal(t, d.Owner, header.Owner)
+	assert.Equal(t, d.Target, header.Target)
+	assert.Equal(t, d.Anchor, header.Anchor)
+	assert.Equal(t, *d.Tags, *header.Tags)
+
+	// The reader is left at the data
+	data, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, d.Data, crypto.Base64URLEncode(data))
+
+	_, err = ReadHeader(bytes.NewReader(d.Raw[:600]))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestMatch(t *testing.T) {
+	routes := []Route{
+		{Backend: "partner", ApiKeys: []string{"partner"}},
+		{Backend: "large", MinSize: 1000},
+		{Backend: "small", MaxSize: 100},
+	}
+	assert.Equal(t, "partner", Match(routes, 5000, "partner"))
+	assert.Equal(t, "large", Match(routes, 1000, ""))
+	assert.Equal(t, "small", Match(routes, 100, "other"))
+	assert.Equal(t, "", Match(routes, 500, ""))
+	assert.Equal(t, "", Match(nil, 500, "partner"))
 }
@@ -0,0 +1,106 @@
+package bundler
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/liteseed/goar/transaction/data_item"
+)
+
+// Fake is a backend holding data items in memory, for tests. Its uploads are paid for out
+// of band unless Pays is set.
+type Fake struct {
+	Pays bool  // reported by Payout
+	Err  error // returned by every request when set
+
+	mu       sync.Mutex
+	items    map[string][]byte
+	payments map[string]string
+}
+
+func NewFake() *Fake {
+	return &Fake{items: map[string][]byte{}, payments: map[string]string{}}
+}
+
+func (f *Fake) DataItemPost(url string, data []byte) (*DataItemPostResponse, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	d, err := data_item.Decode(data)
+	if err != nil {
+		return nil, &Error{Kind: ErrClient, Method: http.MethodPost, URL: url, Status: http.StatusBadRequest, Err: err}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.items[d.ID] = data
+	return &DataItemPostResponse{ID: d.ID, Owner: d.Owner}, nil
+}
+
+func (f *Fake) DataItemPut(url string, id string, paymentID string) (*DataItemPutResponse, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.items[id]; !ok {
+		return nil, &Error{Kind: ErrClient, Method: http.MethodPut, URL: url, Status: http.StatusNotFound}
+	}
+	f.payments[id] = paymentID
+	return &DataItemPutResponse{ID: id, PaymentID: paymentID}, nil
+}
+
+func (f *Fake) DataItemGet(url string, id string) (io.ReadCloser, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.items[id]
+	if !ok {
+		return nil, &Error{Kind: ErrClient, Method: http.MethodGet, URL: url, Status: http.StatusNotFound}
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// DataItemStatusGet reports "paid" once the payment of the data item is put, "created" until then
+func (f *Fake) DataItemStatusGet(url string, id string) ([]byte, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.items[id]; !ok {
+		return nil, &Error{Kind: ErrClient, Method: http.MethodGet, URL: url, Status: http.StatusNotFound}
+	}
+	if _, ok := f.payments[id]; ok {
+		return []byte("paid"), nil
+	}
+	return []byte("created"), nil
+}
+
+// Payment returns the payment put for a data item, empty if there is none
+func (f *Fake) Payment(id string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.payments[id]
+}
+
+func (f *Fake) Payout() bool {
+	return f.Pays
+}
+
+func (f *Fake) Healthy(url string) bool {
+	return f.Err == nil
+}
+
+func (f *Fake) Track(address string, url string) {}
+
+func (f *Fake) Stakers() []Health {
+	return nil
+}
+
+func (f *Fake) Probe() []string {
+	return []string{}
+}
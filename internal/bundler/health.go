@@ -0,0 +1,247 @@
+package bundler
+
+import (
+	"context"
+	"expvar"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var metrics = expvar.NewMap("stakers")
+
+// HealthPolicy controls how stakers are scored and when they are quarantined. The score of
+// a staker is the moving average of its successful requests, halved while it fails its
+// status check and scaled down by how much slower than Latency it answers.
+type HealthPolicy struct {
+	Threshold  float64       // score below which a staker is quarantined
+	MinSamples int           // requests observed before a staker can be quarantined
+	Latency    time.Duration // latency above which the score of a staker decreases
+	Quarantine time.Duration // time a quarantined staker is not sent uploads
+}
+
+var DefaultHealthPolicy = HealthPolicy{Threshold: 0.5, MinSamples: 10, Latency: 5 * time.Second, Quarantine: 10 * time.Minute}
+
+// Health is what transit observed of a staker from its own requests
+type Health struct {
+	Address     string    `json:"address"`
+	URL         string    `json:"url"`
+	Requests    int64     `json:"requests"`
+	Failures    int64     `json:"failures"`
+	Latency     int64     `json:"latency"` // milliseconds, moving average
+	Score       float64   `json:"score"`
+	Probed      time.Time `json:"probed"`
+	ProbeOK     bool      `json:"probeOk"`
+	Quarantined time.Time `json:"quarantined"` // end of the quarantine, zero if the staker is not quarantined
+}
+
+type health struct {
+	mu       sync.Mutex
+	address  string
+	requests int64
+	failures int64
+	samples  int     // requests observed since the last quarantine
+	rate     float64 // moving average of successful requests
+	latency  time.Duration
+	probed   time.Time
+	probeOK  bool
+	until    time.Time
+	metrics  *expvar.Map
+}
+
+func (h *health) score(p HealthPolicy) float64 {
+	s := h.rate
+	if !h.probed.IsZero() && !h.probeOK {
+		s /= 2
+	}
+	if p.Latency > 0 && h.latency > p.Latency {
+		s *= float64(p.Latency) / float64(h.latency)
+	}
+	return s
+}
+
+// observe records the outcome of a request and quarantines the staker if its score drops
+// below the threshold
+func (h *health) observe(p HealthPolicy, now time.Time, d time.Duration, ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.requests++
+	h.samples++
+	success := 0.0
+	if ok {
+		success = 1
+	} else {
+		h.failures++
+	}
+	h.rate = (4*h.rate + success) / 5
+	if h.latency > 0 {
+		d = (4*h.latency + d) / 5
+	}
+	h.latency = d
+	h.quarantine(p, now)
+
+	h.metrics.Add("requests", 1)
+	if !ok {
+		h.metrics.Add("failures", 1)
+	}
+	latency := new(expvar.Int)
+	latency.Set(h.latency.Milliseconds())
+	h.metrics.Set("latency", latency)
+	score := new(expvar.Float)
+	score.Set(h.score(p))
+	h.metrics.Set("score", score)
+}
+
+func (h *health) probe(p HealthPolicy, now time.Time, ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.probed = now
+	h.probeOK = ok
+	h.quarantine(p, now)
+}
+
+func (h *health) quarantine(p HealthPolicy, now time.Time) {
+	if p.Threshold <= 0 || h.samples < p.MinSamples || now.Before(h.until) {
+		return
+	}
+	if h.score(p) < p.Threshold {
+		h.until = now.Add(p.Quarantine)
+		h.metrics.Add("quarantines", 1)
+	}
+}
+
+// quarantined tells whether the staker is quarantined. A staker leaving quarantine starts
+// over with a clean score.
+func (h *health) quarantined(now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.until.IsZero() {
+		return false
+	}
+	if now.Before(h.until) {
+		return true
+	}
+	h.until = time.Time{}
+	h.samples = 0
+	h.rate = 1
+	return false
+}
+
+// health returns the health of the staker at url
+func (b *Liteseed) health(url string) *health {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.stakers == nil {
+		b.stakers = map[string]*health{}
+	}
+	h, ok := b.stakers[url]
+	if !ok {
+		h = &health{rate: 1, metrics: new(expvar.Map).Init()}
+		metrics.Set(url, h.metrics)
+		b.stakers[url] = h
+	}
+	return h
+}
+
+// Track records the address of the staker at url, so its health is reported with it
+func (b *Liteseed) Track(address string, url string) {
+	h := b.health(url)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.address = address
+}
+
+// Healthy tells whether uploads can be sent to the staker at url: its circuit is closed
+// and it is not quarantined
+func (b *Liteseed) Healthy(url string) bool {
+	return !b.Open(url) && !b.health(url).quarantined(b.now())
+}
+
+// Stakers returns the health of the stakers transit sent requests to, by URL
+func (b *Liteseed) Stakers() []Health {
+	b.mu.Lock()
+	urls := make([]string, 0, len(b.stakers))
+	for url := range b.stakers {
+		urls = append(urls, url)
+	}
+	b.mu.Unlock()
+	slices.Sort(urls)
+
+	stakers := []Health{}
+	for _, url := range urls {
+		h := b.health(url)
+		h.quarantined(b.now())
+		h.mu.Lock()
+		stakers = append(stakers, Health{
+			Address:     h.address,
+			URL:         url,
+			Requests:    h.requests,
+			Failures:    h.failures,
+			Latency:     h.latency.Milliseconds(),
+			Score:       h.score(b.scoring),
+			Probed:      h.probed,
+			ProbeOK:     h.probeOK,
+			Quarantined: h.until,
+		})
+		h.mu.Unlock()
+	}
+	return stakers
+}
+
+// Probe checks that every staker transit sent requests to answers, which counts towards
+// its score. It returns the URLs of the stakers that did not answer.
+func (b *Liteseed) Probe() []string {
+	b.mu.Lock()
+	urls := make([]string, 0, len(b.stakers))
+	for url := range b.stakers {
+		urls = append(urls, url)
+	}
+	b.mu.Unlock()
+
+	var mu sync.Mutex
+	down := []string{}
+	var wg sync.WaitGroup
+	for _, url := range urls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok := b.ping(url)
+			b.health(url).probe(b.scoring, b.now(), ok)
+			if !ok {
+				mu.Lock()
+				down = append(down, url)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	slices.Sort(down)
+	return down
+}
+
+func (b *Liteseed) ping(url string) bool {
+	u, err := b.urls.Parse(strings.TrimSuffix(url, "/") + "/")
+	if err != nil {
+		return false
+	}
+	ctx := context.Background()
+	if b.timeouts.Status > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.timeouts.Status)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, http.NoBody)
+	if err != nil {
+		return false
+	}
+	res, err := b.client.Do(req)
+	if err != nil {
+		return false
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, res.Body)
+	return res.StatusCode < 500
+}
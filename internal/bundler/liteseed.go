@@ -0,0 +1,211 @@
+package bundler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/liteseed/transit/httpsig"
+	"github.com/liteseed/transit/internal/utils"
+)
+
+// Liteseed uploads data items to the nodes of the stakers of the Liteseed contract. It
+// keeps a circuit breaker and a health score per staker.
+type Liteseed struct {
+	client   *http.Client
+	timeouts Timeouts
+	retry    RetryPolicy
+	breaker  BreakerPolicy
+	scoring  HealthPolicy
+	urls     utils.URLPolicy
+	signer   httpsig.Signer
+	now      func() time.Time
+	sleep    func(time.Duration)
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+	stakers  map[string]*health
+}
+
+// Timeouts bound each request to a staker, including reading the response
+type Timeouts struct {
+	Get    time.Duration
+	Post   time.Duration
+	Put    time.Duration
+	Status time.Duration
+}
+
+// RetryPolicy controls how idempotent requests are retried
+type RetryPolicy struct {
+	Attempts   int
+	Backoff    time.Duration // bound of the wait before the first retry, doubled on every retry
+	MaxBackoff time.Duration
+}
+
+// BreakerPolicy controls when requests to a failing staker are refused
+type BreakerPolicy struct {
+	Failures int           // consecutive failures after which the circuit opens
+	Cooldown time.Duration // time before a request is let through an open circuit
+}
+
+var (
+	DefaultTimeouts      = Timeouts{Get: 30 * time.Second, Post: 60 * time.Second, Put: 10 * time.Second, Status: 10 * time.Second}
+	DefaultRetryPolicy   = RetryPolicy{Attempts: 3, Backoff: 200 * time.Millisecond, MaxBackoff: 2 * time.Second}
+	DefaultBreakerPolicy = BreakerPolicy{Failures: 5, Cooldown: 30 * time.Second}
+)
+
+// Config is the bundler client section of the configuration file. Unset fields keep their default.
+type Config struct {
+	Timeouts struct {
+		Get    int // seconds
+		Post   int
+		Put    int
+		Status int
+	}
+	Retries  int // attempts of idempotent requests
+	Backoff  int // milliseconds
+	Failures int // consecutive failures after which a staker is no longer sent requests
+	Cooldown int // seconds before a failing staker is tried again
+	Health   struct {
+		Threshold  float64 // score below which a staker is quarantined
+		MinSamples int
+		Latency    int // milliseconds
+		Quarantine int // seconds
+	}
+	URLs utils.URLPolicy // staker URLs requests are sent to
+}
+
+type Option = func(*Liteseed)
+
+func NewLiteseed(options ...Option) *Liteseed {
+	b := &Liteseed{
+		timeouts: DefaultTimeouts,
+		retry:    DefaultRetryPolicy,
+		breaker:  DefaultBreakerPolicy,
+		scoring:  DefaultHealthPolicy,
+		urls:     utils.DefaultURLPolicy,
+		now:      time.Now,
+		sleep:    time.Sleep,
+	}
+	for _, o := range options {
+		o(b)
+	}
+	b.client = &http.Client{Transport: b.urls.Transport()}
+	return b
+}
+
+// WithConfig applies the bundler client section of the configuration file
+func WithConfig(c Config) Option {
+	return func(b *Liteseed) {
+		seconds := func(n int, d *time.Duration) {
+			if n > 0 {
+				*d = time.Duration(n) * time.Second
+			}
+		}
+		seconds(c.Timeouts.Get, &b.timeouts.Get)
+		seconds(c.Timeouts.Post, &b.timeouts.Post)
+		seconds(c.Timeouts.Put, &b.timeouts.Put)
+		seconds(c.Timeouts.Status, &b.timeouts.Status)
+		seconds(c.Cooldown, &b.breaker.Cooldown)
+		if c.Retries > 0 {
+			b.retry.Attempts = c.Retries
+		}
+		if c.Backoff > 0 {
+			b.retry.Backoff = time.Duration(c.Backoff) * time.Millisecond
+		}
+		if c.Failures > 0 {
+			b.breaker.Failures = c.Failures
+		}
+		if c.Health.Threshold > 0 {
+			b.scoring.Threshold = c.Health.Threshold
+		}
+		if c.Health.MinSamples > 0 {
+			b.scoring.MinSamples = c.Health.MinSamples
+		}
+		if c.Health.Latency > 0 {
+			b.scoring.Latency = time.Duration(c.Health.Latency) * time.Millisecond
+		}
+		seconds(c.Health.Quarantine, &b.scoring.Quarantine)
+		b.urls = c.URLs
+	}
+}
+
+func WithTimeouts(t Timeouts) Option {
+	return func(b *Liteseed) {
+		b.timeouts = t
+	}
+}
+
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(b *Liteseed) {
+		b.retry = p
+	}
+}
+
+// WithBreakerPolicy sets when a staker is no longer sent requests. A zero Failures disables the breaker.
+func WithBreakerPolicy(p BreakerPolicy) Option {
+	return func(b *Liteseed) {
+		b.breaker = p
+	}
+}
+
+// WithHealthPolicy sets how stakers are scored. A zero Threshold never quarantines a staker.
+func WithHealthPolicy(p HealthPolicy) Option {
+	return func(b *Liteseed) {
+		b.scoring = p
+	}
+}
+
+// WithURLPolicy sets the staker URLs requests are sent to
+func WithURLPolicy(p utils.URLPolicy) Option {
+	return func(b *Liteseed) {
+		b.urls = p
+	}
+}
+
+// WithSigner signs every request with the key of s, so nodes can check they come from transit
+func WithSigner(s httpsig.Signer) Option {
+	return func(b *Liteseed) {
+		b.signer = s
+	}
+}
+
+func (b *Liteseed) DataItemGet(url string, id string) (io.ReadCloser, error) {
+	return b.stream(url, "/tx/"+id, b.timeouts.Get)
+}
+
+func (b *Liteseed) DataItemPost(url string, data []byte) (*DataItemPostResponse, error) {
+	data, err := b.request(http.MethodPost, url, "/tx", b.timeouts.Post, data)
+	if err != nil {
+		return nil, err
+	}
+	var res DataItemPostResponse
+	if err = json.Unmarshal(data, &res); err != nil {
+		return nil, &Error{Kind: ErrDecode, Method: http.MethodPost, URL: url + "/tx", Err: err}
+	}
+	return &res, nil
+}
+
+func (b *Liteseed) DataItemPut(url string, id string, paymentID string) (*DataItemPutResponse, error) {
+	path := "/tx/" + id + "/" + paymentID
+	data, err := b.request(http.MethodPut, url, path, b.timeouts.Put, nil)
+	if err != nil {
+		return nil, err
+	}
+	var res DataItemPutResponse
+	if err = json.Unmarshal(data, &res); err != nil {
+		return nil, &Error{Kind: ErrDecode, Method: http.MethodPut, URL: url + path, Err: err}
+	}
+	return &res, nil
+}
+
+func (b *Liteseed) DataItemStatusGet(url string, id string) ([]byte, error) {
+	return b.request(http.MethodGet, url, "/tx/"+id+"/status", b.timeouts.Status, nil)
+}
+
+// Payout is true, stakers are paid for every data item they hold
+func (b *Liteseed) Payout() bool {
+	return true
+}
@@ -2,80 +2,216 @@ package bundler
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"math/rand/v2"
 	"net/http"
+	"os"
+	"time"
 
-	"github.com/liteseed/transit/internal/utils"
+	"github.com/liteseed/transit/httpsig"
 )
 
-func (b *Bundler) get(url string) ([]byte, error) {
-	u, err := utils.ParseUrl(url)
-	if err != nil {
-		return nil, err
-	}
+// Kinds of request errors, matched with errors.Is
+var (
+	ErrTimeout     = errors.New("bundler timed out")
+	ErrUnavailable = errors.New("bundler unavailable")
+	ErrClient      = errors.New("bundler rejected the request")
+	ErrServer      = errors.New("bundler failed")
+	ErrDecode      = errors.New("bundler sent an invalid response")
+	ErrCircuitOpen = errors.New("circuit open")
+)
 
-	res, err := b.client.Get(u)
-	if err != nil {
-		return nil, err
-	}
+// Error is a failed request to a staker. Kind is one of the errors above, Status and Body
+// are set when the staker answered.
+type Error struct {
+	Kind   error
+	Method string
+	URL    string
+	Status int
+	Body   string
+	Err    error
+}
 
-	body, err := io.ReadAll(res.Body)
-	if err != nil {
-		return nil, err
+func (e *Error) Error() string {
+	if e.Status != 0 {
+		return fmt.Sprintf("%s %s: %d: %s", e.Method, e.URL, e.Status, e.Body)
+	}
+	if e.Err != nil {
+		return fmt.Sprintf("%s %s: %s: %s", e.Method, e.URL, e.Kind, e.Err)
 	}
+	return fmt.Sprintf("%s %s: %s", e.Method, e.URL, e.Kind)
+}
 
-	if res.StatusCode >= 400 {
-		return nil, fmt.Errorf("%d: %s", res.StatusCode, string(body))
+func (e *Error) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Kind}
 	}
-	return body, nil
+	return []error{e.Kind, e.Err}
 }
 
-func (b *Bundler) post(url string, payload []byte) ([]byte, error) {
-	u, err := utils.ParseUrl(url)
-	if err != nil {
-		return nil, err
+// failure tells whether an error counts against the circuit of a staker
+func failure(err error) bool {
+	return errors.Is(err, ErrTimeout) || errors.Is(err, ErrUnavailable) || errors.Is(err, ErrServer)
+}
+
+// retryable tells whether a request that failed with err may succeed if sent again
+func retryable(err error) bool {
+	var e *Error
+	if errors.As(err, &e) && e.Status == http.StatusTooManyRequests {
+		return true
 	}
-	res, err := b.client.Post(u, "application/octet-stream", bytes.NewBuffer(payload))
+	return failure(err) && !errors.Is(err, ErrCircuitOpen)
+}
+
+// request sends a request to the staker at url and reads its response
+func (b *Liteseed) request(method string, url string, path string, timeout time.Duration, payload []byte) ([]byte, error) {
+	var res []byte
+	err := b.attempt(method, url, path, func(u string) error {
+		var err error
+		res, err = send(b.client, method, u, timeout, payload, b.sign)
+		return err
+	})
+	return res, err
+}
+
+// stream sends a GET request to the staker at url and returns the body of its response as
+// it comes. Only the response status is retried and counts for the health of the staker.
+func (b *Liteseed) stream(url string, path string, timeout time.Duration) (io.ReadCloser, error) {
+	var res io.ReadCloser
+	err := b.attempt(http.MethodGet, url, path, func(u string) error {
+		var err error
+		res, err = open(b.client, http.MethodGet, u, timeout, nil, b.sign)
+		return err
+	})
+	return res, err
+}
+
+// attempt calls do with the URL of a request to the staker at url. GET and PUT requests are
+// idempotent and are retried with jittered backoff. Requests to a staker whose circuit is
+// open fail right away.
+func (b *Liteseed) attempt(method string, url string, path string, do func(u string) error) error {
+	u, err := b.urls.Parse(url + path)
 	if err != nil {
-		return nil, err
+		// A staker whose URL is refused counts as failing, so it ends up quarantined
+		b.health(url).observe(b.scoring, b.now(), 0, false)
+		return &Error{Kind: ErrUnavailable, Method: method, URL: url + path, Err: err}
 	}
 
-	body, err := io.ReadAll(res.Body)
-	if err != nil {
-		return nil, err
+	attempts := 1
+	if method != http.MethodPost && b.retry.Attempts > 1 {
+		attempts = b.retry.Attempts
+	}
+	c := b.circuit(url)
+	for attempt := 1; ; attempt++ {
+		if !c.allow() {
+			return &Error{Kind: ErrUnavailable, Method: method, URL: u, Err: ErrCircuitOpen}
+		}
+		start := time.Now()
+		err := do(u)
+		c.record(!failure(err))
+		b.health(url).observe(b.scoring, b.now(), time.Since(start), !failure(err))
+		if err == nil {
+			return nil
+		}
+		if attempt >= attempts || !retryable(err) {
+			return err
+		}
+		b.sleep(b.retry.backoff(attempt))
 	}
+}
 
-	if res.StatusCode >= 400 {
-		return nil, fmt.Errorf("%d: %s", res.StatusCode, string(body))
+// sign signs a request with the key of transit, if there is one, so the node can tell it
+// comes from transit
+func (b *Liteseed) sign(req *http.Request, payload []byte) error {
+	if b.signer == nil {
+		return nil
 	}
-	return body, nil
+	return httpsig.Sign(req, payload, b.signer, b.now())
 }
 
-func (b *Bundler) put(url string, contentType string, payload []byte) ([]byte, error) {
-	u, err := utils.ParseUrl(url)
+// send sends a single request, reads its response and types its failure. sign, when set,
+// signs the request.
+func send(client *http.Client, method string, u string, timeout time.Duration, payload []byte, sign func(*http.Request, []byte) error) ([]byte, error) {
+	res, err := open(client, method, u, timeout, payload, sign)
 	if err != nil {
 		return nil, err
 	}
+	defer res.Close()
+	return io.ReadAll(res)
+}
+
+// open sends a single request and returns the body of its response, which the timeout
+// still bounds. Failed requests are typed with the body of the response read.
+func open(client *http.Client, method string, u string, timeout time.Duration, payload []byte, sign func(*http.Request, []byte) error) (io.ReadCloser, error) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
 
-	req, err := http.NewRequest("PUT", u, bytes.NewBuffer(payload))
+	var body io.Reader = http.NoBody
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
 	if err != nil {
+		cancel()
 		return nil, err
 	}
-	req.Header.Set("content-type", contentType)
+	if payload != nil {
+		req.Header.Set("content-type", "application/octet-stream")
+	}
+	if sign != nil {
+		// The node is not at fault, so the error is not typed and does not count against it
+		err = sign(req, payload)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("sign request: %w", err)
+		}
+	}
 
-	res, err := b.client.Do(req)
+	res, err := client.Do(req)
 	if err != nil {
-		return nil, err
+		cancel()
+		return nil, transportError(method, u, err)
+	}
+	if res.StatusCode < 400 {
+		return &responseBody{ReadCloser: res.Body, cancel: cancel, method: method, url: u}, nil
 	}
 
-	body, err := io.ReadAll(res.Body)
+	defer cancel()
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
 	if err != nil {
-		return nil, err
+		return nil, transportError(method, u, err)
+	}
+	if res.StatusCode >= 500 {
+		return nil, &Error{Kind: ErrServer, Method: method, URL: u, Status: res.StatusCode, Body: string(data)}
 	}
+	return nil, &Error{Kind: ErrClient, Method: method, URL: u, Status: res.StatusCode, Body: string(data)}
+}
 
-	if res.StatusCode >= 400 {
-		return nil, fmt.Errorf("%d: %s", res.StatusCode, string(body))
+func transportError(method string, u string, err error) error {
+	if errors.Is(err, context.DeadlineExceeded) || os.IsTimeout(err) {
+		return &Error{Kind: ErrTimeout, Method: method, URL: u, Err: err}
+	}
+	return &Error{Kind: ErrUnavailable, Method: method, URL: u, Err: err}
+}
+
+// backoff is the wait before the next attempt, drawn at random up to an exponentially
+// growing bound so stakers are not retried in lockstep
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.Backoff << (attempt - 1)
+	if p.MaxBackoff > 0 && (d > p.MaxBackoff || d <= 0) {
+		d = p.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
 	}
-	return body, nil
+	return rand.N(d) + 1
 }
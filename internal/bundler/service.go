@@ -0,0 +1,145 @@
+package bundler
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/liteseed/transit/internal/pricing"
+)
+
+// ServiceConfig is an upload service speaking the common upload API of Irys and Turbo
+type ServiceConfig struct {
+	URL     string
+	Timeout int // seconds
+}
+
+const DefaultServiceTimeout = 60 * time.Second
+
+// Service uploads data items to an upload service such as Irys or Turbo. Data items are
+// posted with POST /tx/arweave and paid for from the account transit holds with the
+// service, so there is no staker to pay and no payment to report.
+type Service struct {
+	client  *http.Client
+	url     string
+	timeout time.Duration
+	prices  *pricing.Cache
+}
+
+func NewService(c ServiceConfig) *Service {
+	s := &Service{client: http.DefaultClient, url: strings.TrimSuffix(c.URL, "/"), timeout: DefaultServiceTimeout}
+	if c.Timeout > 0 {
+		s.timeout = time.Duration(c.Timeout) * time.Second
+	}
+	s.prices = pricing.NewCache(s, pricing.DefaultCacheConfig)
+	return s
+}
+
+type serviceReceipt struct {
+	ID                  string   `json:"id"`
+	Owner               string   `json:"owner"`
+	DataCaches          []string `json:"dataCaches"`
+	DeadlineHeight      uint     `json:"deadlineHeight"`
+	FastFinalityIndexes []string `json:"fastFinalityIndexes"`
+	Version             string   `json:"version"`
+}
+
+// DataItemPost posts a data item to the service. The url of data items is the one of the
+// service, so url is ignored.
+func (s *Service) DataItemPost(url string, data []byte) (*DataItemPostResponse, error) {
+	body, err := send(s.client, http.MethodPost, s.url+"/tx/arweave", s.timeout, data, nil)
+	if err != nil {
+		return nil, err
+	}
+	var r serviceReceipt
+	if err = json.Unmarshal(body, &r); err != nil {
+		return nil, &Error{Kind: ErrDecode, Method: http.MethodPost, URL: s.url + "/tx/arweave", Err: err}
+	}
+	res := DataItemPostResponse(r)
+	return &res, nil
+}
+
+// DataItemPut has nothing to report to the service, which is paid for out of band
+func (s *Service) DataItemPut(url string, id string, paymentID string) (*DataItemPutResponse, error) {
+	return &DataItemPutResponse{ID: id, PaymentID: paymentID}, nil
+}
+
+func (s *Service) DataItemGet(url string, id string) (io.ReadCloser, error) {
+	return open(s.client, http.MethodGet, s.url+"/tx/"+id+"/data", s.timeout, nil, nil)
+}
+
+// DataItemStatusGet returns the status the service reports for a data item, such as
+// "CONFIRMED" or "FINALIZED"
+func (s *Service) DataItemStatusGet(url string, id string) ([]byte, error) {
+	u := s.url + "/tx/" + id + "/status"
+	body, err := send(s.client, http.MethodGet, u, s.timeout, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	var r struct {
+		Status string `json:"status"`
+	}
+	if err = json.Unmarshal(body, &r); err != nil {
+		return nil, &Error{Kind: ErrDecode, Method: http.MethodGet, URL: u, Err: err}
+	}
+	return []byte(r.Status), nil
+}
+
+// Price returns what the service charges for an upload of size bytes, in winston. Prices
+// are cached by size bucket like the prices of the gateway.
+func (s *Service) Price(size int) (string, error) {
+	return s.prices.Price(size)
+}
+
+// GetTransactionPrice asks the service for the price of an upload of size bytes
+func (s *Service) GetTransactionPrice(size int, target string) (string, error) {
+	u := s.url + "/price/arweave/" + strconv.Itoa(size)
+	body, err := send(s.client, http.MethodGet, u, s.timeout, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	price := strings.TrimSpace(string(body))
+	if p, ok := new(big.Int).SetString(price, 10); !ok || p.Sign() < 0 {
+		return "", &Error{Kind: ErrDecode, Method: http.MethodGet, URL: u, Body: price}
+	}
+	return price, nil
+}
+
+func (s *Service) Payout() bool {
+	return false
+}
+
+func (s *Service) Healthy(url string) bool {
+	return true
+}
+
+func (s *Service) Track(address string, url string) {}
+
+func (s *Service) Stakers() []Health {
+	return nil
+}
+
+// Probe asks the service for its info
+func (s *Service) Probe() []string {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url+"/info", http.NoBody)
+	if err != nil {
+		return []string{s.url}
+	}
+	res, err := s.client.Do(req)
+	if err != nil {
+		return []string{s.url}
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, res.Body)
+	if res.StatusCode != http.StatusOK {
+		return []string{s.url}
+	}
+	return []string{}
+}
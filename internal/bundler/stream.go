@@ -0,0 +1,114 @@
+package bundler
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/liteseed/goar/crypto"
+	"github.com/liteseed/goar/tag"
+	"github.com/liteseed/goar/transaction/data_item"
+)
+
+// maxTagBytes bounds the tags read from a data item header, ANS-104 allows 128 tags of at
+// most 1024 bytes of name and 3072 bytes of value
+const maxTagBytes = 128 * (1024 + 3072 + 16)
+
+// responseBody is the body of a response read as a stream. Its read errors are typed like
+// those of the request and closing it releases the request.
+type responseBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+	method string
+	url    string
+}
+
+func (b *responseBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		err = transportError(b.method, b.url, err)
+	}
+	return n, err
+}
+
+func (b *responseBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
+// ReadHeader reads the header of an ANS-104 data item from the start of r, everything but
+// its data, which r is left at. Data and Raw are not set.
+func ReadHeader(r io.Reader) (*data_item.DataItem, error) {
+	header := make([]byte, 2)
+	next := func(n int) ([]byte, error) {
+		start := len(header)
+		header = append(header, make([]byte, n)...)
+		_, err := io.ReadFull(r, header[start:])
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return header[start:], err
+	}
+
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	signatureType := int(binary.LittleEndian.Uint16(header))
+	meta, ok := data_item.SignatureConfig[signatureType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported signature type:%d", signatureType)
+	}
+	signature, err := next(meta.SignatureLength)
+	if err != nil {
+		return nil, err
+	}
+	owner, err := next(meta.PublicKeyLength)
+	if err != nil {
+		return nil, err
+	}
+	d := &data_item.DataItem{
+		ID:            crypto.Base64URLEncode(crypto.SHA256(signature)),
+		SignatureType: signatureType,
+		Signature:     crypto.Base64URLEncode(signature),
+		Owner:         crypto.Base64URLEncode(owner),
+	}
+
+	for _, field := range []*string{&d.Target, &d.Anchor} {
+		present, err := next(1)
+		if err != nil {
+			return nil, err
+		}
+		if present[0] != 1 {
+			continue
+		}
+		value, err := next(32)
+		if err != nil {
+			return nil, err
+		}
+		if field == &d.Target {
+			*field = crypto.Base64URLEncode(value)
+		} else {
+			*field = string(value)
+		}
+	}
+
+	start := len(header)
+	counts, err := next(16)
+	if err != nil {
+		return nil, err
+	}
+	n := binary.LittleEndian.Uint64(counts[8:])
+	if n > maxTagBytes {
+		return nil, fmt.Errorf("invalid data item - %d bytes of tags", n)
+	}
+	if _, err = next(int(n)); err != nil {
+		return nil, err
+	}
+	d.Tags, _, err = tag.Deserialize(header, start)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
@@ -0,0 +1,38 @@
+package cron
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var alertClient = &http.Client{Timeout: 10 * time.Second}
+
+// alert logs a warning and forwards it to the alert webhook if one is configured
+func (c *Cron) alert(message string, args ...any) {
+	c.logger.Warn("alert: "+message, args...)
+	if c.alertWebhook == "" {
+		return
+	}
+
+	text := message
+	for i := 0; i+1 < len(args); i += 2 {
+		text += fmt.Sprintf(" %v=%v", args[i], args[i+1])
+	}
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		c.logger.Error("fail: internal - marshal alert", "err", err)
+		return
+	}
+	res, err := alertClient.Post(c.alertWebhook, "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		c.logger.Error("fail: webhook - send alert", "err", err)
+		return
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 400 {
+		c.logger.Error("fail: webhook - send alert", "status", res.StatusCode)
+	}
+}
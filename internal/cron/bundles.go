@@ -0,0 +1,257 @@
+package cron
+
+import (
+	"math"
+	"time"
+
+	"github.com/liteseed/goar/tag"
+	"github.com/liteseed/goar/transaction"
+	"github.com/liteseed/goar/transaction/bundle"
+	"github.com/liteseed/goar/transaction/data_item"
+	"github.com/liteseed/transit/internal/database"
+	"github.com/liteseed/transit/internal/database/schema"
+	"github.com/liteseed/transit/internal/ledger"
+)
+
+// BundlePolicy controls the bundles transit posts itself for the data items no staker took
+type BundlePolicy struct {
+	MaxSize int // bytes of data items in a bundle, a single larger data item is bundled alone
+	Timeout int // seconds a bundle may stay off chain before its data items are bundled again, once its anchor expired
+}
+
+var DefaultBundlePolicy = BundlePolicy{MaxSize: 100 * 1024 * 1024, Timeout: 3600}
+
+// anchorDepth is the number of blocks a transaction anchor stays valid. A bundle whose anchor
+// is deeper can not be mined anymore, so its data items can be bundled again without paying
+// for them twice.
+const anchorDepth = 50
+
+// SendBundles bundles the data items of the paid orders transit holds into an ANS-104
+// bundle and posts it as an L1 transaction of the payout key, chunk by chunk. The bundle is
+// recorded as posting once its header is accepted, and a bundle whose chunks did not all
+// upload is resumed by the next run before a new one is built. The orders are sent once
+// ConfirmBundles finds the bundle final.
+func (crn *Cron) SendBundles() {
+	j := crn.jobs[JobSendBundles]
+	if crn.payoutsPaused() {
+		crn.logger.Warn("cron: " + JobSendBundles + " paused - insufficient wallet balance")
+		return
+	}
+	posting, err := crn.database.GetBundles(schema.Posting, 1)
+	if err != nil {
+		crn.logger.Error("fail: database - get bundles", "err", err)
+		return
+	}
+	if len(posting) > 0 {
+		crn.resumeBundle(&posting[0])
+		return
+	}
+
+	orders, err := crn.database.GetOrders(&schema.Order{Status: schema.Queued, Payment: schema.Paid, Backend: schema.SelfBundled}, j.config.BatchSize, database.OldestFirst, database.Unbundled)
+	if err != nil {
+		crn.logger.Error("fail: database - get orders", "err", err)
+		return
+	}
+	if len(*orders) == 0 {
+		j.report(0, 0)
+		return
+	}
+	ids := []string{}
+	for _, o := range *orders {
+		ids = append(ids, o.Id)
+	}
+	items, err := crn.database.GetDataItems(ids)
+	if err != nil {
+		crn.logger.Error("fail: database - get data items", "err", err)
+		return
+	}
+
+	b, ids := crn.newBundle(items, crn.bundles.MaxSize)
+	if b == nil {
+		return
+	}
+	tags := []tag.Tag{{Name: "Bundle-Format", Value: "binary"}, {Name: "Bundle-Version", Value: "2.0.0"}}
+	tx := crn.wallet.CreateTransaction(b.Raw, "", "0", &tags)
+	err = crn.signTransaction(tx)
+	if err != nil {
+		crn.logger.Error("fail: internal - sign transaction", "err", err)
+		return
+	}
+	// Read after signing, so the anchor of the bundle is no newer than the height recorded
+	info, err := crn.wallet.Client.GetNetworkInfo()
+	if err != nil {
+		crn.logger.Error("fail: gateway - get network info", "err", err)
+		return
+	}
+	header := *tx
+	header.Data = ""
+	_, err = crn.wallet.Client.SubmitTransaction(&header)
+	if err != nil {
+		crn.logger.Error("fail: gateway - post bundle", "err", err)
+		return
+	}
+	bun := &schema.Bundle{Id: tx.ID, Status: schema.Posting, Items: len(ids), Size: len(b.Raw), Reward: tx.Reward, AnchorHeight: info.Height}
+	err = crn.database.CreateBundle(bun, ids)
+	if err != nil {
+		crn.logger.Error("fail: database - create bundle", "err", err)
+		return
+	}
+	if !crn.uploadChunks(bun, tx, b.Raw) {
+		return
+	}
+
+	j.report(len(ids), int64(len(*orders)-len(ids)))
+	crn.logger.Info("cron: "+JobSendBundles, "bundle", tx.ID, "items", len(ids), "size", len(b.Raw))
+}
+
+// newBundle bundles the data items in order up to maxSize bytes and returns the bundle with
+// the ids of the data items in it, or nil if none could be bundled
+func (crn *Cron) newBundle(items []schema.DataItem, maxSize int) (*bundle.Bundle, []string) {
+	dataItems := []data_item.DataItem{}
+	ids := []string{}
+	size := 0
+	for _, item := range items {
+		if len(dataItems) > 0 && size+len(item.Raw) > maxSize {
+			break
+		}
+		d, err := data_item.Decode(item.Raw)
+		if err != nil {
+			crn.logger.Error("fail: internal - decode data item", "id", item.Id, "err", err)
+			continue
+		}
+		dataItems = append(dataItems, *d)
+		ids = append(ids, item.Id)
+		size += len(item.Raw)
+	}
+	if len(dataItems) == 0 {
+		return nil, nil
+	}
+	b, err := bundle.New(&dataItems)
+	if err != nil {
+		crn.logger.Error("fail: internal - create bundle", "err", err)
+		return nil, nil
+	}
+	return b, ids
+}
+
+// resumeBundle uploads the chunks left of a bundle whose header was accepted. The bundle is
+// built again from its data items, which gives the same data and so the same chunks. A bundle
+// that can not be built again or expired while posting is dropped.
+func (crn *Cron) resumeBundle(bun *schema.Bundle) {
+	items, err := crn.database.GetBundleDataItems(bun.Id)
+	if err != nil {
+		crn.logger.Error("fail: database - get bundle data items", "err", err)
+		return
+	}
+	b, ids := crn.newBundle(items, math.MaxInt)
+	if b == nil || len(ids) != bun.Items || len(b.Raw) != bun.Size || crn.bundleExpired(bun) {
+		crn.logger.Warn("cron: "+JobSendBundles+" - bundle dropped", "bundle", bun.Id)
+		err = crn.database.DropBundle(bun.Id)
+		if err != nil {
+			crn.logger.Error("fail: database - drop bundle", "err", err)
+		}
+		return
+	}
+	tx := &transaction.Transaction{}
+	err = tx.PrepareChunks(b.Raw)
+	if err != nil {
+		crn.logger.Error("fail: internal - prepare chunks", "err", err)
+		return
+	}
+	if !crn.uploadChunks(bun, tx, b.Raw) {
+		return
+	}
+	crn.jobs[JobSendBundles].report(len(ids), 0)
+	crn.logger.Info("cron: "+JobSendBundles+" - bundle resumed", "bundle", bun.Id, "chunks", len(tx.ChunkData.Chunks)-bun.Chunks)
+}
+
+// uploadChunks uploads the data of a posting bundle chunk by chunk from the first chunk not
+// uploaded yet. The bundle is sent once all chunks are uploaded, otherwise the chunks
+// uploaded so far are recorded for the next run. It reports whether the bundle is sent.
+func (crn *Cron) uploadChunks(bun *schema.Bundle, tx *transaction.Transaction, data []byte) bool {
+	for i := bun.Chunks; i < len(tx.ChunkData.Chunks); i++ {
+		chunk, err := tx.GetChunk(i, data)
+		if err == nil {
+			_, err = crn.wallet.Client.UploadChunk(chunk)
+		}
+		if err != nil {
+			crn.logger.Error("fail: gateway - upload chunk", "bundle", bun.Id, "chunk", i, "err", err)
+			err = crn.database.UpdateBundle(bun.Id, &schema.Bundle{Chunks: i})
+			if err != nil {
+				crn.logger.Error("fail: database - update bundle", "err", err)
+			}
+			return false
+		}
+	}
+	err := crn.database.UpdateBundle(bun.Id, &schema.Bundle{Status: schema.Sent})
+	if err != nil {
+		crn.logger.Error("fail: database - update bundle", "err", err)
+		return false
+	}
+	return true
+}
+
+// ConfirmBundles marks the bundles transit posted confirmed once their transaction is final,
+// which sends their orders and records them in the ledger. The data items of a bundle still
+// off chain once it expired are bundled again.
+func (crn *Cron) ConfirmBundles() {
+	j := crn.jobs[JobConfirmBundles]
+	bundles, err := crn.database.GetBundles(schema.Sent, j.config.BatchSize)
+	if err != nil {
+		crn.logger.Error("fail: database - get bundles", "err", err)
+		return
+	}
+	processed := 0
+	for _, b := range bundles {
+		status, err := crn.transactionStatus(b.Id)
+		if err != nil {
+			crn.logger.Error("fail: gateway - get transaction status", "err", err)
+			continue
+		}
+		if status == nil {
+			if crn.bundleExpired(&b) {
+				crn.logger.Warn("cron: "+JobConfirmBundles+" - bundle dropped", "bundle", b.Id)
+				err = crn.database.DropBundle(b.Id)
+				if err != nil {
+					crn.logger.Error("fail: database - drop bundle", "err", err)
+				}
+			}
+			continue
+		}
+		if status.NumberOfConfirmations < crn.finality {
+			continue
+		}
+
+		orders, err := crn.database.GetBundleOrders(b.Id)
+		if err != nil {
+			crn.logger.Error("fail: database - get bundle orders", "err", err)
+			continue
+		}
+		err = crn.database.RecordEntries(ledger.BundleEntries(&b, orders)...)
+		if err != nil {
+			crn.logger.Error("fail: database - record entries", "err", err)
+			continue
+		}
+		err = crn.database.ConfirmBundle(b.Id, uint(status.BlockHeight))
+		if err != nil {
+			crn.logger.Error("fail: database - confirm bundle", "err", err)
+			continue
+		}
+		processed++
+	}
+	j.report(processed, int64(len(bundles)-processed))
+}
+
+// bundleExpired reports whether a bundle off chain can not be mined anymore: it is older than
+// the timeout and its anchor is deeper than anchorDepth. Without the block height it is not.
+func (crn *Cron) bundleExpired(b *schema.Bundle) bool {
+	if time.Since(b.CreatedAt) <= time.Duration(crn.bundles.Timeout)*time.Second {
+		return false
+	}
+	info, err := crn.wallet.Client.GetNetworkInfo()
+	if err != nil {
+		crn.logger.Error("fail: gateway - get network info", "err", err)
+		return false
+	}
+	return info.Height > b.AnchorHeight+anchorDepth
+}
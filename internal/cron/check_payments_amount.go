@@ -1,58 +1,105 @@
 package cron
 
 import (
-	"strconv"
+	"errors"
+	"math/big"
+	"slices"
 
+	"github.com/liteseed/goar/crypto"
+	"github.com/liteseed/transit/internal/database"
 	"github.com/liteseed/transit/internal/database/schema"
-	"github.com/liteseed/transit/internal/utils"
 )
 
-func (crn *Cron) checkSinglePaymentAmount(o *schema.Order) *schema.Order {
+var (
+	errPaymentQuantity = errors.New("payment quantity is invalid")
+	errPaymentTarget   = errors.New("payment is not sent to the transit wallet")
+	errPaymentPayer    = errors.New("payer may not pay for the order")
+)
+
+// PayerPolicy restricts who may pay for an order. By default anyone may.
+type PayerPolicy struct {
+	Owner    bool     // payments must come from the owner of the data item or a sponsor
+	Sponsors []string // addresses that may pay for any data item
+}
+
+// allowed reports whether payer may pay for o. Orders without a known owner can be paid by anyone.
+func (p PayerPolicy) allowed(o *schema.Order, payer string) bool {
+	if !p.Owner || o.Owner == "" || o.Owner == payer {
+		return true
+	}
+	return slices.Contains(p.Sponsors, payer)
+}
+
+// allocatePayment checks the payment transaction of an order and allocates it to the order,
+// so the same transaction can not pay for more than its quantity. It returns whether the order
+// is fully paid, payments of an order add up until they cover the price quoted at the first one.
+// It fails with errPaymentQuantity, errPaymentTarget, errPaymentPayer or database.ErrPaymentExhausted
+// if the transaction can not pay for the order, any other error is worth a retry.
+func (crn *Cron) allocatePayment(o *schema.Order) (bool, error) {
 	tx, err := crn.wallet.Client.GetTransactionByID(o.TransactionId)
 	if err != nil {
 		crn.logger.Error("fail: gateway - get transaction by id", "err", err)
-		return nil
+		return false, err
 	}
 
-	payment, err := strconv.ParseUint(tx.Quantity, 10, 64)
+	payment, ok := new(big.Int).SetString(tx.Quantity, 10)
+	if !ok {
+		crn.logger.Error("fail: internal - conversion to big int", "quantity", tx.Quantity)
+		return false, errPaymentQuantity
+	}
+	if !crn.receives(tx.Target) {
+		return false, errPaymentTarget
+	}
+	payer, err := crypto.GetAddressFromOwner(tx.Owner)
 	if err != nil {
-		crn.logger.Error("fail: internal - conversion to uint", "err", err)
-		return nil
+		return false, errPaymentPayer
+	}
+	transfer := &schema.Transfer{Id: o.TransactionId, Payer: payer, Quantity: payment.String()}
+	if !crn.payers.allowed(o, payer) {
+		// The transfer is kept to be refunded like one that paid for no order
+		err = crn.database.RecordTransfer(transfer)
+		if err != nil {
+			crn.logger.Error("fail: database - record transfer", "err", err)
+			return false, err
+		}
+		return false, errPaymentPayer
 	}
 
-	r, err := crn.wallet.Client.GetTransactionPrice(o.Size, "")
-	if err != nil {
-		crn.logger.Error("fail: gateway - get transaction status", "err", err)
-		return nil
+	price, ok := new(big.Int).SetString(o.Price, 10)
+	if !ok {
+		q, err := crn.quote(o)
+		if err != nil {
+			crn.logger.Error("fail: gateway - get transaction price", "err", err)
+			return false, err
+		}
+		price = q.Total()
 	}
-	price, err := strconv.ParseUint(utils.CalculatePriceWithFee(r), 10, 64)
+
+	received, err := crn.database.AllocatePayment(transfer, o.Id, price)
 	if err != nil {
-		crn.logger.Error("fail: internal - conversion to uint", "err", err)
-		return nil
+		if !rejected(err) {
+			crn.logger.Error("fail: database - allocate payment", "err", err)
+		}
+		return false, err
 	}
+	return received.Cmp(price) >= 0, nil
+}
 
-	u := &schema.Order{}
-	if payment >= price && tx.Target == crn.wallet.Signer.Address {
-		u.Payment = schema.Paid
-	} else {
-		u.Payment = schema.Invalid
-		u.Status = schema.Failed
-	}
-	return u
+// rejected reports whether err means the payment can not pay for the order
+func rejected(err error) bool {
+	return errors.Is(err, errPaymentQuantity) || errors.Is(err, errPaymentTarget) || errors.Is(err, errPaymentPayer) || errors.Is(err, database.ErrPaymentExhausted)
 }
 
-func (crn *Cron) CheckPaymentsAmount() {
-	orders, err := crn.database.GetOrders(&schema.Order{Payment: schema.Queued})
-	if err != nil {
-		crn.logger.Error("fail: database - get orders", "error", err)
-		return
+// detachPayment takes a payment back from the order, which stays open for another payment.
+// It is done for the payments of payers not allowed and for the rejected payments transit
+// discovered itself, which the user did not choose.
+func (crn *Cron) detachPayment(o *schema.Order) {
+	payment := schema.Payment(schema.Unpaid)
+	if received, ok := new(big.Int).SetString(o.Received, 10); ok && received.Sign() > 0 {
+		payment = schema.Partial
 	}
-	for _, order := range *orders {
-		u := crn.checkSinglePaymentAmount(&order)
-		err = crn.database.UpdateOrder(order.Id, u)
-		if err != nil {
-			crn.logger.Error("fail: database - update order", "err", err)
-			return
-		}
+	err := crn.database.DetachPayment(o.Id, o.TransactionId, payment)
+	if err != nil {
+		crn.logger.Error("fail: database - detach payment", "err", err)
 	}
 }
@@ -1,26 +1,94 @@
 package cron
 
-import "github.com/liteseed/transit/internal/database/schema"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
 
-// Number of Confirmation > 10
+	"github.com/liteseed/goar/client"
+	"github.com/liteseed/transit/internal/database/schema"
+)
+
+// CheckPaymentsConfirmations allocates payments with enough confirmations to their orders,
+// records their block and marks them confirmed. They are paid out once VerifyPayments finds
+// them final. The payments of payers not allowed and the rejected payments transit discovered
+// are taken back from their order, which stays open for another payment.
 func (crn *Cron) CheckPaymentsConfirmations() {
-	orders, err := crn.database.GetOrders(&schema.Order{Payment: schema.Unpaid})
-	if err != nil {
-		crn.logger.Error("fail: database - get orders", "error", err)
-		return
-	}
-	for _, order := range *orders {
-		status, err := crn.wallet.Client.GetTransactionStatus(order.TransactionId)
+	crn.drain(JobCheckPaymentsConfirmations, &schema.Order{Payment: schema.Unpaid}, func(order *schema.Order) {
+		status, err := crn.transactionStatus(order.TransactionId)
 		if err != nil {
 			crn.logger.Error("fail: gateway - get transaction status", "err", err)
-			continue
+			return
+		}
+		if status == nil || status.NumberOfConfirmations < crn.confirmations {
+			return
+		}
+
+		var u *schema.Order
+		paid, err := crn.allocatePayment(order)
+		switch {
+		case err == nil && paid:
+			u = &schema.Order{Payment: schema.Confirmed, BlockHeight: uint(status.BlockHeight), BlockIndepHash: status.BlockIndepHash}
+		case err == nil:
+			// The shortfall is reported to the user who can top up the payment
+			u = &schema.Order{Payment: schema.Partial}
+		case errors.Is(err, errPaymentPayer):
+			crn.logger.Warn("cron: payer not allowed", "id", order.Id, "transaction", order.TransactionId)
+			crn.detachPayment(order)
+			return
+		case rejected(err) && order.Discovered:
+			// The user did not choose this payment and may still send another
+			crn.logger.Warn("cron: discovered payment rejected", "id", order.Id, "transaction", order.TransactionId, "err", err)
+			crn.detachPayment(order)
+			return
+		case rejected(err):
+			crn.logger.Warn("cron: payment rejected", "id", order.Id, "transaction", order.TransactionId, "err", err)
+			u = &schema.Order{Payment: schema.Invalid, Status: schema.Failed}
+		default:
+			return
 		}
-		if status.NumberOfConfirmations >= 10 {
-			err = crn.database.UpdateOrder(order.Id, &schema.Order{Payment: schema.Paid})
-			if err != nil {
-				crn.logger.Error("fail: database - update order", "err", err)
-			}
+		err = crn.database.UpdateOrder(order.Id, u)
+		if err != nil {
+			crn.logger.Error("fail: database - update order", "err", err)
 		}
-		continue
+	})
+}
+
+// transactionStatus returns the status of a transaction or nil if it is not in a block,
+// either because it is still pending or because its block was orphaned.
+func (crn *Cron) transactionStatus(id string) (*client.TransactionStatus, error) {
+	u, err := url.Parse(crn.wallet.Client.Gateway)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = path.Join(u.Path, "tx", id, "status")
+
+	resp, err := crn.wallet.Client.Client.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusAccepted, http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	status := &client.TransactionStatus{}
+	err = json.Unmarshal(body, status)
+	if err != nil {
+		return nil, err
 	}
+	return status, nil
 }
@@ -1,40 +1,140 @@
 package cron
 
 import (
+	"fmt"
 	"log/slog"
+	"sync"
+	"time"
 
 	"github.com/liteseed/goar/wallet"
 	"github.com/liteseed/sdk-go/contract"
 	"github.com/liteseed/transit/internal/bundler"
 	"github.com/liteseed/transit/internal/database"
+	"github.com/liteseed/transit/internal/gateway"
+	"github.com/liteseed/transit/internal/pricing"
+	"github.com/liteseed/transit/internal/signer"
 	"github.com/robfig/cron/v3"
 )
 
 type Cron struct {
-	bundler  *bundler.Bundler
-	c        *cron.Cron
-	contract *contract.Contract
-	database *database.Database
-	logger   *slog.Logger
-	wallet   *wallet.Wallet
+	alertWebhook     string
+	backends         map[string]bundler.Bundler
+	balanceThreshold string
+	bundler          bundler.Bundler
+	bundles          BundlePolicy
+	c                *cron.Cron
+	confirmations    int
+	contract         *contract.Contract
+	database         *database.Database
+	fee              pricing.FeePolicy
+	finality         int
+	gateways         *gateway.Pool
+	jobs             map[string]*job
+	logger           *slog.Logger
+	payers           PayerPolicy
+	paymentDeadline  time.Duration
+	prices           *pricing.Cache
+	reconcile        ReconcilePolicy
+	refunds          RefundPolicy
+	reporter         Reporter
+	reports          ReportPolicy
+	retired          []string
+	signer           signer.Signer
+	wallet           *wallet.Wallet
+
+	walletMu     sync.Mutex
+	walletStatus WalletStatus
 }
 
 type Option = func(*Cron)
 
+const (
+	DefaultConfirmations = 10
+	DefaultFinality      = 50
+)
+
 func New(options ...func(*Cron)) (*Cron, error) {
-	c := &Cron{c: cron.New()}
+	c := &Cron{c: cron.New(), confirmations: DefaultConfirmations, fee: pricing.Default, bundles: DefaultBundlePolicy, finality: DefaultFinality, logger: slog.Default(), reconcile: DefaultReconcilePolicy, refunds: DefaultRefundPolicy, reports: DefaultReportPolicy}
+	c.jobs = map[string]*job{
+		JobCheckPaymentsConfirmations: {config: DefaultJobConfig, run: c.CheckPaymentsConfirmations},
+		JobSendPayments:               {config: DefaultJobConfig, run: c.SendPayments},
+		JobMonitorBalance:             {config: DefaultJobConfig, run: c.MonitorBalance},
+		JobExpireOrders:               {config: DefaultJobConfig, run: c.ExpireOrders},
+		JobVerifyPayments:             {config: DefaultJobConfig, run: c.VerifyPayments},
+		JobDiscoverPayments:           {config: DefaultJobConfig, run: c.DiscoverPayments},
+		JobQueueRefunds:               {config: DefaultJobConfig, run: c.QueueRefunds},
+		JobSendRefunds:                {config: DefaultJobConfig, run: c.SendRefunds},
+		JobReconcile:                  {config: DefaultJobConfig, run: c.Reconcile},
+		JobProbeGateways:              {config: DefaultJobConfig, run: c.ProbeGateways},
+		JobRefreshPrices:              {config: DefaultJobConfig, run: c.RefreshPrices},
+		JobProbeStakers:               {config: DefaultJobConfig, run: c.ProbeStakers},
+		JobSendBundles:                {config: DefaultJobConfig, run: c.SendBundles},
+		JobConfirmBundles:             {config: DefaultJobConfig, run: c.ConfirmBundles},
+		JobSendReports:                {config: DefaultJobConfig, run: c.SendReports},
+	}
 	for _, o := range options {
 		o(c)
 	}
+	if c.signer == nil && c.wallet != nil && c.wallet.Signer != nil {
+		c.signer = signer.FromSigner(c.wallet.Signer)
+	}
+	if c.prices == nil && c.wallet != nil {
+		c.prices = pricing.NewCache(c.wallet.Client, pricing.DefaultCacheConfig)
+	}
 	return c, nil
 }
 
-func WithBundler(b *bundler.Bundler) Option {
+// WithAlertWebhook sets a URL that alerts are posted to as JSON, in addition to the log
+func WithAlertWebhook(url string) Option {
+	return func(c *Cron) {
+		c.alertWebhook = url
+	}
+}
+
+// WithBalanceThreshold sets the wallet balance in winston below which an alert is raised
+func WithBalanceThreshold(winston string) Option {
+	return func(c *Cron) {
+		c.balanceThreshold = winston
+	}
+}
+
+// WithBackends sets the upload services orders may be held by, by name
+func WithBackends(backends map[string]bundler.Bundler) Option {
+	return func(c *Cron) {
+		c.backends = backends
+	}
+}
+
+// WithBundlePolicy sets the size of the bundles transit posts itself and how long they may
+// stay off chain. Unset fields keep their default.
+func WithBundlePolicy(p BundlePolicy) Option {
+	return func(c *Cron) {
+		if p.MaxSize <= 0 {
+			p.MaxSize = DefaultBundlePolicy.MaxSize
+		}
+		if p.Timeout <= 0 {
+			p.Timeout = DefaultBundlePolicy.Timeout
+		}
+		c.bundles = p
+	}
+}
+
+// WithBundler sets the backend of the stakers of the contract
+func WithBundler(b bundler.Bundler) Option {
 	return func(c *Cron) {
 		c.bundler = b
 	}
 }
 
+// WithConfirmations sets the number of confirmations after which a payment is confirmed
+func WithConfirmations(n int) Option {
+	return func(c *Cron) {
+		if n > 0 {
+			c.confirmations = n
+		}
+	}
+}
+
 func WithContracts(contract *contract.Contract) Option {
 	return func(c *Cron) {
 		c.contract = contract
@@ -47,12 +147,134 @@ func WithDatabase(db *database.Database) Option {
 	}
 }
 
+func WithFeePolicy(p pricing.FeePolicy) Option {
+	return func(c *Cron) {
+		c.fee = p
+	}
+}
+
+// WithFinality sets the number of confirmations after which a confirmed payment is
+// considered final and paid out
+func WithFinality(n int) Option {
+	return func(c *Cron) {
+		if n > 0 {
+			c.finality = n
+		}
+	}
+}
+
+// WithGateways sets the gateways probed in the background, those the wallet client sends its requests to
+func WithGateways(p *gateway.Pool) Option {
+	return func(c *Cron) {
+		c.gateways = p
+	}
+}
+
+// WithJobs overrides the default configuration of the jobs by name.
+// Unset fields keep their default value.
+func WithJobs(config map[string]JobConfig) Option {
+	return func(c *Cron) {
+		for name, jc := range config {
+			if j, ok := c.jobs[name]; ok {
+				j.merge(jc)
+			}
+		}
+	}
+}
+
 func WithLogger(logger *slog.Logger) Option {
 	return func(c *Cron) {
 		c.logger = logger
 	}
 }
 
+// WithPayerPolicy restricts who may pay for an order
+func WithPayerPolicy(p PayerPolicy) Option {
+	return func(c *Cron) {
+		c.payers = p
+	}
+}
+
+// WithPaymentDeadline sets how long an order may stay unpaid before it expires
+func WithPaymentDeadline(d time.Duration) Option {
+	return func(c *Cron) {
+		c.paymentDeadline = d
+	}
+}
+
+// WithPriceCache sets the cache network prices are read from. It can be shared with the server.
+// It defaults to a cache of the gateway of the wallet.
+func WithPriceCache(p *pricing.Cache) Option {
+	return func(c *Cron) {
+		c.prices = p
+	}
+}
+
+// WithReconcilePolicy sets the wallet history compared with the database.
+// Unset fields keep their default.
+func WithReconcilePolicy(p ReconcilePolicy) Option {
+	return func(c *Cron) {
+		if p.Window <= 0 {
+			p.Window = DefaultReconcilePolicy.Window
+		}
+		if p.Delay <= 0 {
+			p.Delay = DefaultReconcilePolicy.Delay
+		}
+		c.reconcile = p
+	}
+}
+
+// WithRefundPolicy sets the fee kept from refunds and when they are sent.
+// An unset settle time keeps its default.
+func WithRefundPolicy(p RefundPolicy) Option {
+	return func(c *Cron) {
+		if p.Settle <= 0 {
+			p.Settle = DefaultRefundPolicy.Settle
+		}
+		c.refunds = p
+	}
+}
+
+// WithReporter sets where the reports on the uploads to the stakers are sent, the AO process
+func WithReporter(r Reporter) Option {
+	return func(c *Cron) {
+		c.reporter = r
+	}
+}
+
+// WithReportPolicy sets how reports are retried. Unset fields keep their default.
+func WithReportPolicy(p ReportPolicy) Option {
+	return func(c *Cron) {
+		if p.Attempts <= 0 {
+			p.Attempts = DefaultReportPolicy.Attempts
+		}
+		if p.Backoff <= 0 {
+			p.Backoff = DefaultReportPolicy.Backoff
+		}
+		if p.MaxBackoff <= 0 {
+			p.MaxBackoff = DefaultReportPolicy.MaxBackoff
+		}
+		c.reports = p
+	}
+}
+
+// WithRetiredAddresses sets the addresses of previous payout keys. They still receive the
+// payments of orders quoted before the key was rotated, but sign nothing.
+func WithRetiredAddresses(addresses []string) Option {
+	return func(c *Cron) {
+		c.retired = addresses
+	}
+}
+
+// WithSigner sets the payout key, which receives payments and signs payouts and refunds.
+// It defaults to the key of the wallet.
+func WithSigner(s signer.Signer) Option {
+	return func(c *Cron) {
+		c.signer = s
+	}
+}
+
+// WithWallet sets the wallet whose gateway client is used to read from and post to the network
 func WithWallet(s *wallet.Wallet) Option {
 	return func(c *Cron) {
 		c.wallet = s
@@ -67,18 +289,12 @@ func (c *Cron) Shutdown() {
 	c.c.Stop()
 }
 
-func (c *Cron) Setup(spec string) error {
-	_, err := c.c.AddFunc(spec, c.CheckPaymentsAmount)
-	if err != nil {
-		return err
-	}
-	_, err = c.c.AddFunc(spec, c.CheckPaymentsConfirmations)
-	if err != nil {
-		return err
-	}
-	_, err = c.c.AddFunc(spec, c.SendPayments)
-	if err != nil {
-		return err
+func (c *Cron) Setup() error {
+	for name, j := range c.jobs {
+		_, err := c.c.AddJob(j.config.Schedule, j)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
 	}
 	return nil
 }
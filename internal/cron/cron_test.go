@@ -1,23 +1,87 @@
 package cron
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+	"unicode/utf8"
 
 	"slices"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/liteseed/aogo"
+	"github.com/liteseed/goar/crypto"
+	"github.com/liteseed/goar/transaction/data_item"
 	"github.com/liteseed/goar/wallet"
+	"github.com/liteseed/sdk-go/contract"
 	"github.com/liteseed/transit/internal/bundler"
 	"github.com/liteseed/transit/internal/database"
+	"github.com/liteseed/transit/internal/database/schema"
+	"github.com/liteseed/transit/internal/utils"
+	"github.com/liteseed/transit/test"
 	"github.com/stretchr/testify/assert"
 	"gorm.io/driver/postgres"
 )
 
-func TestCheckPaymentsAmount(t *testing.T) {
+// expectAllocation expects the first allocation of a transfer to an order
+func expectAllocation(mock sqlmock.Sqlmock, order string, transfer string, quantity string, price string, amount string) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "allocations" WHERE "allocations"."order_id" = $1`)).WithArgs(order).WillReturnRows(sqlmock.NewRows([]string{"order_id"}))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "transfers" WHERE "transfers"."id" = $1`)).WithArgs(transfer, 1).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "transfers" ("id","payer","quantity","allocated","settled","created_at") VALUES ($1,$2,$3,$4,$5,$6)`)).WithArgs(transfer, sqlmock.AnyArg(), quantity, "0", false, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "entries" ("kind","debit","credit","amount","counterparty","order_id","transaction_id","created_at") VALUES ($1,$2,$3,$4,$5,$6,$7,$8) ON CONFLICT DO NOTHING RETURNING "id"`)).WithArgs("payment", "wallet", "payers", quantity, sqlmock.AnyArg(), "", transfer, sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "allocations" ("order_id","transfer_id","amount","created_at") VALUES ($1,$2,$3,$4)`)).WithArgs(order, transfer, amount, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "transfers" SET "allocated"=$1 WHERE id = $2 AND allocated = $3 AND settled = $4`)).WithArgs(amount, transfer, "0", false).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "orders" SET "price"=$1,"received"=$2 WHERE id = $3`)).WithArgs(price, amount, order).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+}
+
+// liteseed is a bundler client allowed to reach the stakers served by the tests
+func liteseed() *bundler.Liteseed {
+	return bundler.NewLiteseed(bundler.WithURLPolicy(utils.DevURLPolicy))
+}
+
+// expectEntries expects ledger entries to be recorded
+func expectEntries(mock sqlmock.Sqlmock) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "entries"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+}
+
+func expectPayout(mock sqlmock.Sqlmock, order string) {
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "orders" SET "payout_id"=$1 WHERE id = $2`)).WithArgs(sqlmock.AnyArg(), order).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+}
+
+func expectReport(mock sqlmock.Sqlmock, order string, kind string, staker string) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "reports"`)).WithArgs(order, kind, staker, sqlmock.AnyArg(), "", "created", 0, "", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+}
+
+func TestPayerPolicy(t *testing.T) {
+	o := &schema.Order{Owner: "owner"}
+	assert.True(t, PayerPolicy{}.allowed(o, "stranger"))
+
+	p := PayerPolicy{Owner: true, Sponsors: []string{"sponsor"}}
+	assert.True(t, p.allowed(o, "owner"))
+	assert.True(t, p.allowed(o, "sponsor"))
+	assert.False(t, p.allowed(o, "stranger"))
+	assert.True(t, p.allowed(&schema.Order{}, "stranger"))
+}
+
+func TestCheckPaymentsConfirmations(t *testing.T) {
+	const confirmed = `{"block_height":1000,"block_indep_hash":"block_indep_hash","number_of_confirmations":11}`
 	mockDb, mock, _ := sqlmock.New()
 	db, err := database.FromDialector(postgres.New(postgres.Config{
 		Conn:       mockDb,
@@ -27,18 +91,56 @@ func TestCheckPaymentsAmount(t *testing.T) {
 	assert.NoError(t, err)
 	t.Run("Success", func(t *testing.T) {
 		mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"Id", "TransactionId", "Payment", "Size"}).AddRow("dataitem", "transaction", "unpaid", 1000))
+		expectAllocation(mock, "dataitem", "transaction", "100100", "10010", "10010")
 		mock.ExpectBegin()
-		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "orders" SET "payment"=$1 WHERE id = $2`)).WithArgs("paid", "dataitem").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "orders" SET "payment"=$1,"block_height"=$2,"block_indep_hash"=$3 WHERE id = $4`)).WithArgs("confirmed", 1000, "block_indep_hash", "dataitem").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "orders"`)).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		arweave := httptest.NewServer(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+				var err error
+				switch r.URL.Path {
+				case "/tx/transaction/status":
+					_, err = w.Write([]byte(`{"block_height":1000,"block_indep_hash":"block_indep_hash","number_of_confirmations":11}`))
+				case "/price/1000":
+					_, err = w.Write([]byte("10000"))
+				default:
+					_, err = w.Write([]byte(`{"id":"transaction","quantity":"100100","target":"3XTR7MsJUD9LoaiFRdWswzX1X5BR7AQdl1x2v2zIVck"}`))
+				}
+				assert.NoError(t, err)
+			}))
+
+		defer arweave.Close()
+
+		w, err := wallet.FromPath("../../test/signer.json", arweave.URL)
+		assert.NoError(t, err)
+		crn, err := New(WithDatabase(db), WithWallet(w))
+		assert.NoError(t, err)
+
+		crn.CheckPaymentsConfirmations()
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Retired Address", func(t *testing.T) {
+		mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"Id", "TransactionId", "Payment", "Size"}).AddRow("dataitem", "transaction", "unpaid", 1000))
+		expectAllocation(mock, "dataitem", "transaction", "100100", "10010", "10010")
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "orders" SET "payment"=$1,"block_height"=$2,"block_indep_hash"=$3 WHERE id = $4`)).WithArgs("confirmed", 1000, "block_indep_hash", "dataitem").WillReturnResult(sqlmock.NewResult(1, 1))
 		mock.ExpectCommit()
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "orders"`)).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
 		arweave := httptest.NewServer(
 			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if strings.HasSuffix(r.URL.Path, "/status") {
+					_, err := w.Write([]byte(confirmed))
+					assert.NoError(t, err)
+					return
+				}
 				if r.URL.Path == "/price/1000" {
-					w.WriteHeader(http.StatusOK)
 					_, err := w.Write([]byte("10000"))
 					assert.NoError(t, err)
 				} else {
-					w.WriteHeader(http.StatusOK)
-					_, err := w.Write([]byte(`{"id":"transaction","quantity":"100100","target":"3XTR7MsJUD9LoaiFRdWswzX1X5BR7AQdl1x2v2zIVck"}`))
+					_, err := w.Write([]byte(`{"id":"transaction","quantity":"100100","target":"retired"}`))
 					assert.NoError(t, err)
 				}
 			}))
@@ -47,21 +149,27 @@ func TestCheckPaymentsAmount(t *testing.T) {
 
 		w, err := wallet.FromPath("../../test/signer.json", arweave.URL)
 		assert.NoError(t, err)
-		crn, err := New(WithDatabase(db), WithWallet(w))
+		crn, err := New(WithDatabase(db), WithWallet(w), WithRetiredAddresses([]string{"retired"}))
 		assert.NoError(t, err)
 
-		crn.CheckPaymentsAmount()
+		crn.CheckPaymentsConfirmations()
 		assert.NoError(t, mock.ExpectationsWereMet())
-
 	})
 
-	t.Run("Not Enough Fee", func(t *testing.T) {
+	t.Run("Partial Payment", func(t *testing.T) {
 		mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"Id", "TransactionId", "Payment", "Size"}).AddRow("dataitem", "transaction", "unpaid", 1000))
+		expectAllocation(mock, "dataitem", "transaction", "10000", "10010", "10000")
 		mock.ExpectBegin()
-		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "orders" SET "status"=$1,"payment"=$2 WHERE id = $3`)).WithArgs("failed", "invalid", "dataitem").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "orders" SET "payment"=$1 WHERE id = $2`)).WithArgs("partial", "dataitem").WillReturnResult(sqlmock.NewResult(1, 1))
 		mock.ExpectCommit()
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "orders"`)).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
 		arweave := httptest.NewServer(
 			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if strings.HasSuffix(r.URL.Path, "/status") {
+					_, err := w.Write([]byte(confirmed))
+					assert.NoError(t, err)
+					return
+				}
 				if r.URL.Path == "/price/1000" {
 					w.WriteHeader(http.StatusOK)
 					_, err := w.Write([]byte("10000"))
@@ -80,47 +188,146 @@ func TestCheckPaymentsAmount(t *testing.T) {
 		crn, err := New(WithDatabase(db), WithWallet(w))
 		assert.NoError(t, err)
 
-		crn.CheckPaymentsAmount()
+		crn.CheckPaymentsConfirmations()
 		assert.NoError(t, mock.ExpectationsWereMet())
 	})
 
-	t.Run("Not Found", func(t *testing.T) {
-		mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"Id", "TransactionId", "Payment", "Size"}).AddRow("dataitem", "transaction", "unpaid", 1000))
-		arweave := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNotFound) }))
+	t.Run("Top Up", func(t *testing.T) {
+		mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"Id", "TransactionId", "Payment", "Size", "Price"}).AddRow("dataitem", "topup", "unpaid", 1000, "10010"))
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "allocations"`)).WillReturnRows(sqlmock.NewRows([]string{"order_id", "transfer_id", "amount"}).AddRow("dataitem", "transaction", "6000"))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "transfers"`)).WithArgs("topup", 1).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "transfers"`)).WithArgs("topup", sqlmock.AnyArg(), "5000", "0", false, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "entries"`)).WithArgs("payment", "wallet", "payers", "5000", sqlmock.AnyArg(), "", "topup", sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "allocations"`)).WithArgs("dataitem", "topup", "4010", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "transfers" SET "allocated"=$1 WHERE id = $2 AND allocated = $3 AND settled = $4`)).WithArgs("4010", "topup", "0", false).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "orders" SET "price"=$1,"received"=$2 WHERE id = $3`)).WithArgs("10010", "10010", "dataitem").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "orders" SET "payment"=$1,"block_height"=$2,"block_indep_hash"=$3 WHERE id = $4`)).WithArgs("confirmed", 1000, "block_indep_hash", "dataitem").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "orders"`)).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		arweave := httptest.NewServer(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if strings.HasSuffix(r.URL.Path, "/status") {
+					_, err := w.Write([]byte(confirmed))
+					assert.NoError(t, err)
+					return
+				}
+				assert.Equal(t, "/tx/topup", r.URL.Path)
+				_, err := w.Write([]byte(`{"id":"topup","quantity":"5000","target":"3XTR7MsJUD9LoaiFRdWswzX1X5BR7AQdl1x2v2zIVck"}`))
+				assert.NoError(t, err)
+			}))
+
 		defer arweave.Close()
 
 		w, err := wallet.FromPath("../../test/signer.json", arweave.URL)
 		assert.NoError(t, err)
-		crn, err := New(WithDatabase(db), WithLogger(slog.Default()), WithWallet(w))
+		crn, err := New(WithDatabase(db), WithWallet(w))
 		assert.NoError(t, err)
 
-		crn.CheckPaymentsAmount()
+		crn.CheckPaymentsConfirmations()
 		assert.NoError(t, mock.ExpectationsWereMet())
 	})
-}
 
-func TestCheckPaymentsConfirmations(t *testing.T) {
-	mockDb, mock, _ := sqlmock.New()
-	db, err := database.FromDialector(postgres.New(postgres.Config{
-		Conn:       mockDb,
-		DriverName: "postgres",
-	}))
-
-	assert.NoError(t, err)
-	t.Run("Success", func(t *testing.T) {
-		mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"Id", "TransactionId", "Payment"}).AddRow("dataitem", "transaction", "confirmed"))
+	t.Run("Payer Not Allowed", func(t *testing.T) {
+		mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"Id", "TransactionId", "Payment", "Size", "Owner"}).AddRow("dataitem", "transaction", "unpaid", 1000, "owner"))
 		mock.ExpectBegin()
-		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "orders" SET "payment"=$1 WHERE id = $2`)).WithArgs("paid", "dataitem").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "transfers" WHERE "transfers"."id" = $1`)).WithArgs("transaction", 1).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "transfers"`)).WithArgs("transaction", sqlmock.AnyArg(), "100100", "0", false, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "entries"`)).WithArgs("payment", "wallet", "payers", "100100", sqlmock.AnyArg(), "", "transaction", sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
 		mock.ExpectCommit()
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "orders" SET "discovered"=$1,"payment"=$2,"status"=$3,"transaction_id"=$4 WHERE id = $5 AND transaction_id = $6`)).WithArgs(false, "unpaid", "created", "", "dataitem", "transaction").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "orders"`)).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
 		arweave := httptest.NewServer(
 			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-				w.WriteHeader(http.StatusOK)
-				_, err := w.Write([]byte(`{"block_height":1000,"block_indep_hash":"block_indep_hash","number_of_confirmations":11}`))
+				if strings.HasSuffix(r.URL.Path, "/status") {
+					_, err := w.Write([]byte(confirmed))
+					assert.NoError(t, err)
+					return
+				}
+				_, err := w.Write([]byte(`{"id":"transaction","owner":"c3RyYW5nZXI","quantity":"100100","target":"3XTR7MsJUD9LoaiFRdWswzX1X5BR7AQdl1x2v2zIVck"}`))
 				assert.NoError(t, err)
 			}))
 
 		defer arweave.Close()
 
+		w, err := wallet.FromPath("../../test/signer.json", arweave.URL)
+		assert.NoError(t, err)
+		crn, err := New(WithDatabase(db), WithWallet(w), WithPayerPolicy(PayerPolicy{Owner: true, Sponsors: []string{"sponsor"}}))
+		assert.NoError(t, err)
+
+		crn.CheckPaymentsConfirmations()
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Payment Reused", func(t *testing.T) {
+		mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"Id", "TransactionId", "Payment", "Size"}).AddRow("dataitem", "transaction", "unpaid", 1000))
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "allocations"`)).WillReturnRows(sqlmock.NewRows([]string{"order_id"}))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "transfers"`)).WillReturnRows(sqlmock.NewRows([]string{"id", "quantity", "allocated"}).AddRow("transaction", "15000", "15000"))
+		mock.ExpectRollback()
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "orders" SET "status"=$1,"payment"=$2 WHERE id = $3`)).WithArgs("failed", "invalid", "dataitem").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "orders"`)).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		arweave := httptest.NewServer(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if strings.HasSuffix(r.URL.Path, "/status") {
+					_, err := w.Write([]byte(confirmed))
+					assert.NoError(t, err)
+					return
+				}
+				if r.URL.Path == "/price/1000" {
+					_, err := w.Write([]byte("10000"))
+					assert.NoError(t, err)
+				} else {
+					_, err := w.Write([]byte(`{"id":"transaction","quantity":"15000","target":"3XTR7MsJUD9LoaiFRdWswzX1X5BR7AQdl1x2v2zIVck"}`))
+					assert.NoError(t, err)
+				}
+			}))
+
+		defer arweave.Close()
+
+		w, err := wallet.FromPath("../../test/signer.json", arweave.URL)
+		assert.NoError(t, err)
+		crn, err := New(WithDatabase(db), WithWallet(w))
+		assert.NoError(t, err)
+
+		crn.CheckPaymentsConfirmations()
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Discovered Payment Reused", func(t *testing.T) {
+		mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"Id", "TransactionId", "Payment", "Size", "Discovered"}).AddRow("dataitem", "transaction", "unpaid", 1000, true))
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "allocations"`)).WillReturnRows(sqlmock.NewRows([]string{"order_id"}))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "transfers"`)).WillReturnRows(sqlmock.NewRows([]string{"id", "quantity", "allocated"}).AddRow("transaction", "15000", "15000"))
+		mock.ExpectRollback()
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "orders" SET "discovered"=$1,"payment"=$2,"status"=$3,"transaction_id"=$4 WHERE id = $5 AND transaction_id = $6`)).WithArgs(false, "unpaid", "created", "", "dataitem", "transaction").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "orders"`)).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		arweave := httptest.NewServer(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if strings.HasSuffix(r.URL.Path, "/status") {
+					_, err := w.Write([]byte(confirmed))
+					assert.NoError(t, err)
+					return
+				}
+				if r.URL.Path == "/price/1000" {
+					_, err := w.Write([]byte("10000"))
+					assert.NoError(t, err)
+				} else {
+					_, err := w.Write([]byte(`{"id":"transaction","quantity":"15000","target":"3XTR7MsJUD9LoaiFRdWswzX1X5BR7AQdl1x2v2zIVck"}`))
+					assert.NoError(t, err)
+				}
+			}))
+
+		defer arweave.Close()
+
 		w, err := wallet.FromPath("../../test/signer.json", arweave.URL)
 		assert.NoError(t, err)
 		crn, err := New(WithDatabase(db), WithWallet(w))
@@ -132,6 +339,7 @@ func TestCheckPaymentsConfirmations(t *testing.T) {
 
 	t.Run("Not Enough Confirmation", func(t *testing.T) {
 		mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"Id", "TransactionId", "Payment"}).AddRow("dataitem", "transaction", "confirmed"))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "orders"`)).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
 		arweave := httptest.NewServer(
 			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				w.WriteHeader(http.StatusOK)
@@ -152,6 +360,79 @@ func TestCheckPaymentsConfirmations(t *testing.T) {
 	})
 }
 
+func TestVerifyPayments(t *testing.T) {
+	mockDb, mock, _ := sqlmock.New()
+	db, err := database.FromDialector(postgres.New(postgres.Config{
+		Conn:       mockDb,
+		DriverName: "postgres",
+	}))
+	assert.NoError(t, err)
+
+	code := http.StatusOK
+	status := `{"block_height":1000,"block_indep_hash":"block_indep_hash","number_of_confirmations":60}`
+	arweave := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/tx/transaction/status", r.URL.Path)
+			w.WriteHeader(code)
+			_, err := w.Write([]byte(status))
+			assert.NoError(t, err)
+		}))
+	defer arweave.Close()
+
+	w, err := wallet.FromPath("../../test/signer.json", arweave.URL)
+	assert.NoError(t, err)
+	crn, err := New(WithDatabase(db), WithWallet(w))
+	assert.NoError(t, err)
+
+	rows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "transaction_id", "payment", "block_height", "block_indep_hash"}).AddRow("dataitem", "transaction", "confirmed", 1000, "block_indep_hash")
+	}
+
+	t.Run("Final", func(t *testing.T) {
+		mock.ExpectQuery("SELECT").WillReturnRows(rows())
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "orders" SET "payment"=$1 WHERE id = $2`)).WithArgs("paid", "dataitem").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "orders"`)).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+
+		crn.VerifyPayments()
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Not Final", func(t *testing.T) {
+		status = `{"block_height":1000,"block_indep_hash":"block_indep_hash","number_of_confirmations":20}`
+		mock.ExpectQuery("SELECT").WillReturnRows(rows())
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "orders"`)).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+		crn.VerifyPayments()
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Orphaned", func(t *testing.T) {
+		status = `{"block_height":1001,"block_indep_hash":"other_block","number_of_confirmations":19}`
+		mock.ExpectQuery("SELECT").WillReturnRows(rows())
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "orders" SET "payment"=$1 WHERE id = $2`)).WithArgs("unpaid", "dataitem").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "orders"`)).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+
+		crn.VerifyPayments()
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Back To Pending", func(t *testing.T) {
+		code, status = http.StatusAccepted, "Pending"
+		mock.ExpectQuery("SELECT").WillReturnRows(rows())
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "orders" SET "payment"=$1 WHERE id = $2`)).WithArgs("unpaid", "dataitem").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "orders"`)).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+
+		crn.VerifyPayments()
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
 func TestSendPayments(t *testing.T) {
 	mockDb, mock, _ := sqlmock.New()
 	db, err := database.FromDialector(postgres.New(postgres.Config{
@@ -168,10 +449,14 @@ func TestSendPayments(t *testing.T) {
 	}))
 
 	t.Run("Success", func(t *testing.T) {
-		mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"Id", "TransactionId", "Payment", "URL"}).AddRow("dataitem", "transaction", "paid", bun.URL[7:]))
+		mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"Id", "TransactionId", "Payment", "URL", "Size"}).AddRow("dataitem", "transaction", "paid", bun.URL[7:], 1000))
+		expectPayout(mock, "dataitem")
+		expectEntries(mock)
+		expectReport(mock, "dataitem", "payout-sent", "")
 		mock.ExpectBegin()
 		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "orders" SET "status"=$1 WHERE id = $2`)).WithArgs("sent", "dataitem").WillReturnResult(sqlmock.NewResult(1, 1))
 		mock.ExpectCommit()
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "orders"`)).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
 		arweave := httptest.NewServer(
 			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				if r.URL.Path == "/price/1000" {
@@ -191,11 +476,164 @@ func TestSendPayments(t *testing.T) {
 
 		w, err := wallet.FromPath("../../test/signer.json", arweave.URL)
 		assert.NoError(t, err)
-		crn, err := New(WithBundler(bundler.New()), WithDatabase(db), WithWallet(w))
+		crn, err := New(WithBundler(liteseed()), WithDatabase(db), WithWallet(w))
+		assert.NoError(t, err)
+
+		crn.SendPayments()
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Success:Send Failed", func(t *testing.T) {
+		// The gateway may have taken the transaction, so it is kept and not sent again
+		mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"Id", "TransactionId", "Payment", "URL", "Size"}).AddRow("dataitem", "transaction", "paid", bun.URL[7:], 1000))
+		expectPayout(mock, "dataitem")
+		expectEntries(mock)
+		expectReport(mock, "dataitem", "payout-sent", "")
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "orders" SET "status"=$1 WHERE id = $2`)).WithArgs("sent", "dataitem").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "orders"`)).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		arweave := httptest.NewServer(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/price/1000":
+					_, err := w.Write([]byte("100000"))
+					assert.NoError(t, err)
+				case "/tx":
+					w.WriteHeader(http.StatusServiceUnavailable)
+				}
+			}))
+
+		defer arweave.Close()
+
+		w, err := wallet.FromPath("../../test/signer.json", arweave.URL)
+		assert.NoError(t, err)
+		crn, err := New(WithBundler(liteseed()), WithDatabase(db), WithWallet(w))
+		assert.NoError(t, err)
+
+		crn.SendPayments()
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Success:Replicas", func(t *testing.T) {
+		mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"Id", "TransactionId", "Payment", "URL", "Size", "Replicas"}).AddRow("dataitem", "transaction", "paid", bun.URL[7:], 1000, 2))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "replicas" WHERE order_id = $1 ORDER BY id`)).WithArgs("dataitem").WillReturnRows(sqlmock.NewRows([]string{"Id", "OrderId", "Staker", "URL", "Status"}).AddRow(1, "dataitem", "replica", bun.URL[7:], "created"))
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "replicas" SET "transaction_id"=$1 WHERE id = $2`)).WithArgs(sqlmock.AnyArg(), 1).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+		expectEntries(mock)
+		expectReport(mock, "dataitem", "payout-sent", "replica")
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "replicas" SET "status"=$1 WHERE id = $2`)).WithArgs("sent", 1).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+		expectPayout(mock, "dataitem")
+		expectEntries(mock)
+		expectReport(mock, "dataitem", "payout-sent", "")
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "orders" SET "status"=$1 WHERE id = $2`)).WithArgs("sent", "dataitem").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "orders"`)).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+
+		// Both stakers are paid the network cost of a single copy
+		var payouts []string
+		arweave := httptest.NewServer(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/price/1000":
+					_, err := w.Write([]byte("100000"))
+					assert.NoError(t, err)
+				case "/tx":
+					var tx struct {
+						Target   string `json:"target"`
+						Quantity string `json:"quantity"`
+					}
+					assert.NoError(t, json.NewDecoder(r.Body).Decode(&tx))
+					payouts = append(payouts, tx.Target+":"+tx.Quantity)
+				}
+			}))
+		defer arweave.Close()
+
+		w, err := wallet.FromPath("../../test/signer.json", arweave.URL)
+		assert.NoError(t, err)
+		crn, err := New(WithBundler(liteseed()), WithDatabase(db), WithWallet(w))
+		assert.NoError(t, err)
+
+		crn.SendPayments()
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.Equal(t, []string{"replica:100000", ":100000"}, payouts)
+	})
+
+	t.Run("Success:Paid Out", func(t *testing.T) {
+		// The payout was sent by a run whose PUT failed, only the PUT is retried
+		mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"Id", "TransactionId", "Payment", "URL", "Size", "PayoutId"}).AddRow("dataitem", "transaction", "paid", bun.URL[7:], 1000, "payout"))
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "orders" SET "status"=$1 WHERE id = $2`)).WithArgs("sent", "dataitem").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "orders"`)).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+
+		var sent bool
+		arweave := httptest.NewServer(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/price/1000":
+					_, err := w.Write([]byte("100000"))
+					assert.NoError(t, err)
+				case "/tx":
+					sent = true
+				}
+			}))
+		defer arweave.Close()
+
+		w, err := wallet.FromPath("../../test/signer.json", arweave.URL)
+		assert.NoError(t, err)
+		crn, err := New(WithBundler(liteseed()), WithDatabase(db), WithWallet(w))
+		assert.NoError(t, err)
+
+		crn.SendPayments()
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.False(t, sent)
+	})
+
+	t.Run("Success:Service", func(t *testing.T) {
+		d := test.DataItem()
+		fake := bundler.NewFake()
+		_, err := fake.DataItemPost("", d.Raw)
+		assert.NoError(t, err)
+
+		mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"Id", "TransactionId", "Payment", "Size", "Received", "Backend"}).AddRow(d.ID, "transaction", "paid", 1000, "110000", "fake"))
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "entries" ("kind","debit","credit","amount","counterparty","order_id","transaction_id","created_at") VALUES ($1,$2,$3,$4,$5,$6,$7,$8),($9,$10,$11,$12,$13,$14,$15,$16) ON CONFLICT DO NOTHING RETURNING "id"`)).
+			WithArgs("upload", "payers", "service", "100000", "fake", d.ID, "", sqlmock.AnyArg(), "fee-income", "payers", "income", "10000", "", d.ID, "", sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "orders" SET "status"=$1 WHERE id = $2`)).WithArgs("sent", d.ID).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "orders"`)).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+
+		// The service is paid for out of band, nothing is sent from the wallet
+		var sent bool
+		arweave := httptest.NewServer(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/price/1000":
+					_, err := w.Write([]byte("100000"))
+					assert.NoError(t, err)
+				case "/tx":
+					sent = true
+				}
+			}))
+		defer arweave.Close()
+
+		w, err := wallet.FromPath("../../test/signer.json", arweave.URL)
+		assert.NoError(t, err)
+		crn, err := New(WithBundler(liteseed()), WithBackends(map[string]bundler.Bundler{"fake": fake}), WithDatabase(db), WithWallet(w))
 		assert.NoError(t, err)
 
 		crn.SendPayments()
 		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.False(t, sent)
+		assert.Equal(t, "transaction", fake.Payment(d.ID))
 	})
 
 	t.Run("Success - 3, Fail 1", func(t *testing.T) {
@@ -205,15 +643,25 @@ func TestSendPayments(t *testing.T) {
 		rows.AddRow("dataitem-3", "transaction-3", "paid", bun.URL[7:], "1000")
 		rows.AddRow("dataitem-4", "transaction-4", "paid", bun.URL[7:], "1000")
 		mock.ExpectQuery("SELECT").WillReturnRows(rows)
+		expectPayout(mock, "dataitem-1")
+		expectEntries(mock)
+		expectReport(mock, "dataitem-1", "payout-sent", "")
 		mock.ExpectBegin()
 		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "orders" SET "status"=$1 WHERE id = $2`)).WithArgs("sent", "dataitem-1").WillReturnResult(sqlmock.NewResult(1, 1))
 		mock.ExpectCommit()
+		expectPayout(mock, "dataitem-2")
+		expectEntries(mock)
+		expectReport(mock, "dataitem-2", "payout-sent", "")
 		mock.ExpectBegin()
 		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "orders" SET "status"=$1 WHERE id = $2`)).WithArgs("sent", "dataitem-2").WillReturnResult(sqlmock.NewResult(1, 1))
 		mock.ExpectCommit()
+		expectPayout(mock, "dataitem-3")
+		expectEntries(mock)
+		expectReport(mock, "dataitem-3", "payout-sent", "")
 		mock.ExpectBegin()
 		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "orders" SET "status"=$1 WHERE id = $2`)).WithArgs("sent", "dataitem-3").WillReturnResult(sqlmock.NewResult(1, 1))
 		mock.ExpectCommit()
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "orders"`)).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
 		arweave := httptest.NewServer(
 			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				if r.URL.Path == "/price/0" {
@@ -241,7 +689,7 @@ func TestSendPayments(t *testing.T) {
 
 		w, err := wallet.FromPath("../../test/signer.json", arweave.URL)
 		assert.NoError(t, err)
-		crn, err := New(WithBundler(bundler.New()), WithLogger(slog.Default()), WithDatabase(db), WithWallet(w))
+		crn, err := New(WithBundler(liteseed()), WithLogger(slog.Default()), WithDatabase(db), WithWallet(w))
 		assert.NoError(t, err)
 
 		crn.SendPayments()
@@ -250,6 +698,7 @@ func TestSendPayments(t *testing.T) {
 	t.Run("Fail Gateway", func(t *testing.T) {
 		mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"Id", "TransactionId", "Payment", "URL"}).AddRow("dataitem", "transaction", "paid", bun.URL[7:]))
 
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "orders"`)).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
 		arweave := httptest.NewServer(
 			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				if r.URL.Path == "/price/1000" {
@@ -267,10 +716,620 @@ func TestSendPayments(t *testing.T) {
 
 		w, err := wallet.FromPath("../../test/signer.json", arweave.URL)
 		assert.NoError(t, err)
-		crn, err := New(WithBundler(bundler.New()), WithLogger(slog.Default()), WithDatabase(db), WithWallet(w))
+		crn, err := New(WithBundler(liteseed()), WithLogger(slog.Default()), WithDatabase(db), WithWallet(w))
 		assert.NoError(t, err)
 
 		crn.SendPayments()
 		assert.NoError(t, mock.ExpectationsWereMet())
 	})
 }
+
+func TestWithJobs(t *testing.T) {
+	crn, err := New(WithJobs(map[string]JobConfig{JobSendPayments: {Schedule: "*/5 * * * *", Workers: 4}, "unknown": {Workers: 2}}))
+	assert.NoError(t, err)
+
+	assert.Equal(t, JobConfig{Schedule: "*/5 * * * *", BatchSize: 25, Workers: 4, Budget: 50}, crn.jobs[JobSendPayments].config)
+	assert.Equal(t, DefaultJobConfig, crn.jobs[JobCheckPaymentsConfirmations].config)
+	assert.NoError(t, crn.Setup())
+
+	crn, err = New(WithJobs(map[string]JobConfig{JobSendPayments: {Schedule: "invalid"}}))
+	assert.NoError(t, err)
+	assert.Error(t, crn.Setup())
+}
+
+func TestTrigger(t *testing.T) {
+	crn, err := New()
+	assert.NoError(t, err)
+
+	done := make(chan struct{})
+	release := make(chan struct{})
+	crn.jobs[JobSendPayments].run = func() {
+		<-release
+		close(done)
+	}
+
+	assert.ErrorIs(t, crn.Trigger("unknown"), ErrJobNotFound)
+	assert.NoError(t, crn.Trigger(JobSendPayments))
+	assert.ErrorIs(t, crn.Trigger(JobSendPayments), ErrJobRunning)
+
+	// A scheduled run is skipped while the triggered one is in progress
+	crn.jobs[JobSendPayments].Run()
+
+	close(release)
+	<-done
+}
+
+func TestProcess(t *testing.T) {
+	crn, err := New(WithJobs(map[string]JobConfig{JobSendPayments: {Workers: 3}}))
+	assert.NoError(t, err)
+
+	orders := make([]schema.Order, 10)
+	var count atomic.Int32
+	crn.process(JobSendPayments, orders, func(o *schema.Order) { count.Add(1) })
+	assert.Equal(t, int32(10), count.Load())
+}
+
+func TestDrain(t *testing.T) {
+	mockDb, mock, _ := sqlmock.New()
+	db, err := database.FromDialector(postgres.New(postgres.Config{
+		Conn:       mockDb,
+		DriverName: "postgres",
+	}))
+	assert.NoError(t, err)
+
+	crn, err := New(WithDatabase(db), WithJobs(map[string]JobConfig{JobSendPayments: {BatchSize: 2}}))
+	assert.NoError(t, err)
+
+	created := time.Now()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "orders" WHERE "orders"."payment" = $1 ORDER BY created_at, id LIMIT $2`)).
+		WithArgs("paid", 2).
+		WillReturnRows(sqlmock.NewRows([]string{"Id", "CreatedAt"}).AddRow("dataitem-1", created).AddRow("dataitem-2", created))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "orders" WHERE "orders"."payment" = $1 AND (created_at, id) > ($2, $3) ORDER BY created_at, id LIMIT $4`)).
+		WithArgs("paid", created, "dataitem-2", 2).
+		WillReturnRows(sqlmock.NewRows([]string{"Id", "CreatedAt"}).AddRow("dataitem-3", created))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "orders"`)).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+
+	var visited []string
+	crn.drain(JobSendPayments, &schema.Order{Payment: schema.Paid}, func(o *schema.Order) { visited = append(visited, o.Id) })
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, []string{"dataitem-1", "dataitem-2", "dataitem-3"}, visited)
+
+	status := crn.Status()
+	i := slices.IndexFunc(status, func(s JobStatus) bool { return s.Name == JobSendPayments })
+	assert.Equal(t, 3, status[i].Processed)
+	assert.Equal(t, int64(3), status[i].Remaining)
+}
+
+func TestMonitorBalance(t *testing.T) {
+	mockDb, mock, _ := sqlmock.New()
+	db, err := database.FromDialector(postgres.New(postgres.Config{
+		Conn:       mockDb,
+		DriverName: "postgres",
+	}))
+	assert.NoError(t, err)
+
+	balance := "1000"
+	arweave := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/wallet/3XTR7MsJUD9LoaiFRdWswzX1X5BR7AQdl1x2v2zIVck/balance":
+				_, err := w.Write([]byte(balance))
+				assert.NoError(t, err)
+			case "/price/2000":
+				_, err := w.Write([]byte("5000"))
+				assert.NoError(t, err)
+			case "/price/0":
+				_, err := w.Write([]byte("100"))
+				assert.NoError(t, err)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+	defer arweave.Close()
+
+	var alerts []string
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		alerts = append(alerts, string(body))
+	}))
+	defer webhook.Close()
+
+	w, err := wallet.FromPath("../../test/signer.json", arweave.URL)
+	assert.NoError(t, err)
+	crn, err := New(WithDatabase(db), WithWallet(w), WithAlertWebhook(webhook.URL), WithBalanceThreshold("10000"))
+	assert.NoError(t, err)
+
+	t.Run("Insufficient", func(t *testing.T) {
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) AS count, coalesce(sum(size), 0) AS size FROM "orders" WHERE "orders"."status" = $1 AND "orders"."payment" = $2`)).
+			WithArgs("queued", "paid").
+			WillReturnRows(sqlmock.NewRows([]string{"count", "size"}).AddRow(2, 2000))
+
+		crn.MonitorBalance()
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		status := crn.WalletStatus()
+		assert.Equal(t, "1000", status.Balance)
+		assert.Equal(t, "5400", status.Obligations)
+		assert.True(t, status.Low)
+		assert.True(t, status.Paused)
+		assert.Len(t, alerts, 2)
+
+		// Payouts are skipped without touching the database
+		crn.SendPayments()
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Topped Up", func(t *testing.T) {
+		balance = "100000"
+		mock.ExpectQuery("SELECT count").WillReturnRows(sqlmock.NewRows([]string{"count", "size"}).AddRow(2, 2000))
+
+		crn.MonitorBalance()
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		status := crn.WalletStatus()
+		assert.False(t, status.Low)
+		assert.False(t, status.Paused)
+		assert.Len(t, alerts, 3)
+		assert.Contains(t, alerts[2], "payouts resumed")
+	})
+}
+
+func TestExpireOrders(t *testing.T) {
+	mockDb, mock, _ := sqlmock.New()
+	db, err := database.FromDialector(postgres.New(postgres.Config{
+		Conn:       mockDb,
+		DriverName: "postgres",
+	}))
+	assert.NoError(t, err)
+
+	arweave := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/info" {
+				_, err := w.Write([]byte(`{"height":200}`))
+				assert.NoError(t, err)
+			} else {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+	defer arweave.Close()
+
+	var released int32
+	mu := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&released, 1)
+		_, err := w.Write([]byte(`{"id":"message"}`))
+		assert.NoError(t, err)
+	}))
+	defer mu.Close()
+
+	w, err := wallet.FromPath("../../test/signer.json", arweave.URL)
+	assert.NoError(t, err)
+	ao, err := aogo.New(aogo.WthMU(mu.URL), aogo.WthCU(mu.URL))
+	assert.NoError(t, err)
+	crn, err := New(WithDatabase(db), WithWallet(w), WithContracts(contract.Custom(ao, "process", w.Signer)), WithPaymentDeadline(time.Hour))
+	assert.NoError(t, err)
+
+	t.Run("Success", func(t *testing.T) {
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "orders" WHERE (status = $1 AND payment = $2) OR (status IN ($3,$4) AND payment = $5)`)).
+			WithArgs("created", "unpaid", "created", "queued", "partial", 25).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "status", "payment", "deadline_height", "backend", "created_at"}).
+				AddRow("fresh", "created", "unpaid", 0, "", time.Now()).
+				AddRow("late", "created", "unpaid", 0, "", time.Now().Add(-2*time.Hour)).
+				AddRow("height", "created", "unpaid", 150, "", time.Now()).
+				AddRow("partial", "queued", "partial", 0, "", time.Now().Add(-2*time.Hour)).
+				AddRow("self", "created", "unpaid", 0, "self", time.Now().Add(-2*time.Hour)))
+		for _, id := range []string{"late", "height", "partial", "self"} {
+			mock.ExpectBegin()
+			mock.ExpectExec(regexp.QuoteMeta(`UPDATE "orders" SET "status"=$1 WHERE id = $2`)).WithArgs("expired", id).WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectCommit()
+		}
+		// Transit no longer keeps the data item it will not bundle
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "data_items" WHERE id = $1`)).WithArgs("self").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "orders"`)).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+		crn.ExpireOrders()
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.Equal(t, int32(4), atomic.LoadInt32(&released))
+	})
+}
+
+func TestDiscoverPayments(t *testing.T) {
+	mockDb, mock, _ := sqlmock.New()
+	db, err := database.FromDialector(postgres.New(postgres.Config{
+		Conn:       mockDb,
+		DriverName: "postgres",
+	}))
+	assert.NoError(t, err)
+
+	arweave := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/graphql", r.URL.Path)
+			body, err := io.ReadAll(r.Body)
+			assert.NoError(t, err)
+			assert.Contains(t, string(body), `"recipients":["3XTR7MsJUD9LoaiFRdWswzX1X5BR7AQdl1x2v2zIVck"]`)
+			assert.Contains(t, string(body), `"min":90`)
+			_, err = w.Write([]byte(`{"data":{"transactions":{"pageInfo":{"hasNextPage":false},"edges":[
+				{"cursor":"a","node":{"id":"transfer","recipient":"3XTR7MsJUD9LoaiFRdWswzX1X5BR7AQdl1x2v2zIVck","owner":{"address":"payer"},"quantity":{"winston":"1000"},"tags":[{"name":"Data-Item-Id","value":"dataitem"},{"name":"Data-Item-Id","value":"attached"}],"block":{"height":100}}},
+				{"cursor":"b","node":{"id":"elsewhere","recipient":"elsewhere","owner":{"address":"payer"},"quantity":{"winston":"1000"},"tags":[{"name":"Data-Item-Id","value":"unpaid"}],"block":{"height":100}}},
+				{"cursor":"c","node":{"id":"other","tags":[{"name":"App-Name","value":"test"}],"block":{"height":101}}}
+			]}}}`))
+			assert.NoError(t, err)
+		}))
+	defer arweave.Close()
+
+	w, err := wallet.FromPath("../../test/signer.json", arweave.URL)
+	assert.NoError(t, err)
+	crn, err := New(WithDatabase(db), WithWallet(w))
+	assert.NoError(t, err)
+
+	// The scan starts from the height saved by the last run
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "cursors" WHERE name = $1`)).WithArgs("discover-payments", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "height"}).AddRow("discover-payments", 90))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "orders" WHERE id = $1`)).WithArgs("dataitem", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status", "payment", "created_at"}).AddRow("dataitem", "created", "unpaid", time.Now()))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "orders" SET "transaction_id"=$1,"status"=$2,"discovered"=$3 WHERE id = $4`)).WithArgs("transfer", "queued", true, "dataitem").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "orders" WHERE id = $1`)).WithArgs("attached", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "transaction_id", "status", "payment", "created_at"}).AddRow("attached", "transaction", "queued", "unpaid", time.Now()))
+	// Not sent to the transit wallet
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "orders" WHERE id = $1`)).WithArgs("unpaid", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status", "payment", "created_at"}).AddRow("unpaid", "created", "unpaid", time.Now()))
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "cursors" ("name","height","updated_at") VALUES ($1,$2,$3) ON CONFLICT ("name") DO UPDATE SET "height"="excluded"."height","updated_at"="excluded"."updated_at"`)).
+		WithArgs("discover-payments", 101, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	crn.DiscoverPayments()
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestQueueRefunds(t *testing.T) {
+	mockDb, mock, _ := sqlmock.New()
+	db, err := database.FromDialector(postgres.New(postgres.Config{
+		Conn:       mockDb,
+		DriverName: "postgres",
+	}))
+	assert.NoError(t, err)
+
+	crn, err := New(WithDatabase(db), WithRefundPolicy(RefundPolicy{Fee: "100"}))
+	assert.NoError(t, err)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT allocations.order_id, allocations.transfer_id, allocations.amount, transfers.payer FROM "allocations"`)).
+		WillReturnRows(sqlmock.NewRows([]string{"order_id", "transfer_id", "amount", "payer"}).AddRow("failed", "transaction", "10010", "payer").AddRow("dust", "transaction", "50", "payer"))
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "refunds"`)).WithArgs("failed", "transaction", "failed", "payer", "10010", "100", "9910", "pending", "", 0, sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "refunds"`)).WithArgs("dust", "transaction", "failed", "payer", "50", "100", "0", "denied", "", 0, sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	mock.ExpectCommit()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "transfers" WHERE (settled = $1 AND created_at < $2) AND (NOT EXISTS`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "payer", "quantity", "allocated"}).AddRow("overpaid", "payer", "20000", "10010").AddRow("invalid", "payer", "5000", "0"))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "transfers" SET "settled"=$1 WHERE id = $2 AND settled = $3`)).WithArgs(true, "overpaid", false).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "refunds"`)).WithArgs("", "overpaid", "overpaid", "payer", "9990", "100", "9890", "pending", "", 0, sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "transfers" SET "settled"=$1 WHERE id = $2 AND settled = $3`)).WithArgs(true, "invalid", false).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "refunds"`)).WithArgs("", "invalid", "invalid", "payer", "5000", "100", "4900", "pending", "", 0, sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	mock.ExpectCommit()
+
+	crn.QueueRefunds()
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSendRefunds(t *testing.T) {
+	mockDb, mock, _ := sqlmock.New()
+	db, err := database.FromDialector(postgres.New(postgres.Config{
+		Conn:       mockDb,
+		DriverName: "postgres",
+	}))
+	assert.NoError(t, err)
+
+	var sent int32
+	arweave := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/tx":
+				atomic.AddInt32(&sent, 1)
+			case "/tx/refund/status":
+				_, err := w.Write([]byte(`{"block_height":1000,"block_indep_hash":"block_indep_hash","number_of_confirmations":12}`))
+				assert.NoError(t, err)
+			}
+		}))
+	defer arweave.Close()
+
+	w, err := wallet.FromPath("../../test/signer.json", arweave.URL)
+	assert.NoError(t, err)
+	crn, err := New(WithDatabase(db), WithWallet(w))
+	assert.NoError(t, err)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "refunds" WHERE "refunds"."status" = $1 ORDER BY id LIMIT $2`)).WithArgs("approved", 25).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "address", "amount", "status"}).AddRow(1, "3XTR7MsJUD9LoaiFRdWswzX1X5BR7AQdl1x2v2zIVck", "9910", "approved"))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "refunds" SET "status"=$1,"transaction_id"=$2,"updated_at"=$3 WHERE id = $4 AND status IN ($5)`)).WithArgs("sent", sqlmock.AnyArg(), sqlmock.AnyArg(), 1, "approved").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	expectEntries(mock)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "refunds" WHERE "refunds"."status" = $1 ORDER BY id LIMIT $2`)).WithArgs("sent", 25).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "transaction_id", "status"}).AddRow(2, "refund", "sent"))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "refunds" SET "status"=$1,"confirmations"=$2,"updated_at"=$3 WHERE id = $4`)).WithArgs("confirmed", 12, sqlmock.AnyArg(), 2).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	crn.SendRefunds()
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&sent))
+}
+
+func TestBundles(t *testing.T) {
+	mockDb, mock, _ := sqlmock.New()
+	db, err := database.FromDialector(postgres.New(postgres.Config{
+		Conn:       mockDb,
+		DriverName: "postgres",
+	}))
+	assert.NoError(t, err)
+
+	d := test.DataItem()
+	var header struct {
+		ID       string `json:"id"`
+		Data     string `json:"data"`
+		DataSize string `json:"data_size"`
+	}
+	var chunks int32
+	arweave := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case strings.HasPrefix(r.URL.Path, "/price/"):
+				_, err := w.Write([]byte("5000"))
+				assert.NoError(t, err)
+			case r.URL.Path == "/info":
+				_, err := w.Write([]byte(`{"height":1100}`))
+				assert.NoError(t, err)
+			case r.URL.Path == "/tx":
+				assert.NoError(t, json.NewDecoder(r.Body).Decode(&header))
+			case r.URL.Path == "/chunk":
+				atomic.AddInt32(&chunks, 1)
+			case r.URL.Path == "/tx/bundle/status":
+				_, err := w.Write([]byte(`{"block_height":1000,"block_indep_hash":"block_indep_hash","number_of_confirmations":60}`))
+				assert.NoError(t, err)
+			case strings.HasSuffix(r.URL.Path, "/status"):
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+	defer arweave.Close()
+
+	w, err := wallet.FromPath("../../test/signer.json", arweave.URL)
+	assert.NoError(t, err)
+	crn, err := New(WithDatabase(db), WithWallet(w))
+	assert.NoError(t, err)
+
+	t.Run("Send", func(t *testing.T) {
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "bundles" WHERE status = $1 ORDER BY created_at, id LIMIT $2`)).WithArgs("posting", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "orders" WHERE ("orders"."status" = $1 AND "orders"."payment" = $2 AND "orders"."backend" = $3) AND (EXISTS (SELECT 1 FROM data_items WHERE data_items.id = orders.id AND data_items.bundle_id = '')) ORDER BY created_at, id LIMIT $4`)).
+			WithArgs("queued", "paid", "self", 25).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "status", "payment", "backend"}).AddRow(d.ID, "queued", "paid", "self"))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "data_items" WHERE id IN ($1) ORDER BY created_at, id`)).WithArgs(d.ID).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "raw", "bundle_id"}).AddRow(d.ID, d.Raw, ""))
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "bundles" ("id","status","items","size","reward","chunks","anchor_height","block_height","created_at") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)`)).
+			WithArgs(sqlmock.AnyArg(), "posting", 1, 32+64+len(d.Raw), "5000", 0, 1100, 0, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "data_items" SET "bundle_id"=$1 WHERE id IN ($2)`)).WithArgs(sqlmock.AnyArg(), d.ID).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "bundles" SET "status"=$1 WHERE id = $2`)).WithArgs("sent", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		crn.SendBundles()
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		// The header is posted without its data, which is uploaded in chunks
+		assert.NotEmpty(t, header.ID)
+		assert.Empty(t, header.Data)
+		assert.Equal(t, strconv.Itoa(32+64+len(d.Raw)), header.DataSize)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&chunks))
+	})
+
+	t.Run("Resume", func(t *testing.T) {
+		// The header of the bundle was accepted and its chunk upload failed
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "bundles" WHERE status = $1 ORDER BY created_at, id LIMIT $2`)).WithArgs("posting", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "status", "items", "size", "chunks", "created_at"}).AddRow("bundle", "posting", 1, 32+64+len(d.Raw), 0, time.Now()))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "data_items" WHERE bundle_id = $1 ORDER BY created_at, id`)).WithArgs("bundle").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "raw", "bundle_id"}).AddRow(d.ID, d.Raw, "bundle"))
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "bundles" SET "status"=$1 WHERE id = $2`)).WithArgs("sent", "bundle").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		header.ID = ""
+		atomic.StoreInt32(&chunks, 0)
+		crn.SendBundles()
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		// Only the chunks are uploaded, no new transaction is posted
+		assert.Empty(t, header.ID)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&chunks))
+	})
+
+	t.Run("Confirm", func(t *testing.T) {
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "bundles" WHERE status = $1 ORDER BY created_at, id LIMIT $2`)).WithArgs("sent", 25).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "status", "reward", "anchor_height", "created_at"}).
+				AddRow("bundle", "sent", "5000", 1000, time.Now()).
+				AddRow("dropped", "sent", "5000", 1000, time.Now().Add(-2*time.Hour)).
+				AddRow("anchored", "sent", "5000", 1080, time.Now().Add(-2*time.Hour)))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "orders" WHERE id IN (SELECT "id" FROM "data_items" WHERE bundle_id = $1)`)).WithArgs("bundle").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "received"}).AddRow(d.ID, "5500"))
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "entries"`)).
+			WithArgs("network-fee", "network", "wallet", "5000", "", "", "bundle", sqlmock.AnyArg(), "bundled", "payers", "income", "5500", "", d.ID, "bundle", sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "bundles" SET "status"=$1,"block_height"=$2 WHERE id = $3`)).WithArgs("confirmed", 1000, "bundle").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "orders" SET "status"=$1 WHERE id IN (SELECT "id" FROM "data_items" WHERE bundle_id = $2)`)).WithArgs("sent", "bundle").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "data_items" SET "raw"=$1 WHERE bundle_id = $2`)).WithArgs(nil, "bundle").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+		// The bundle still off chain after the timeout is bundled again once its anchor expired,
+		// the one whose anchor is still valid may be mined and is kept
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "bundles" SET "status"=$1 WHERE id = $2`)).WithArgs("failed", "dropped").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "data_items" SET "bundle_id"=$1 WHERE bundle_id = $2`)).WithArgs("", "dropped").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		crn.ConfirmBundles()
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestReconcile(t *testing.T) {
+	mockDb, mock, _ := sqlmock.New()
+	db, err := database.FromDialector(postgres.New(postgres.Config{
+		Conn:       mockDb,
+		DriverName: "postgres",
+	}))
+	assert.NoError(t, err)
+
+	mined := time.Now().Add(-2 * time.Hour).Unix()
+	arweave := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/graphql", r.URL.Path)
+			body, err := io.ReadAll(r.Body)
+			assert.NoError(t, err)
+			if strings.Contains(string(body), `"recipients"`) {
+				_, err = w.Write([]byte(fmt.Sprintf(`{"data":{"transactions":{"pageInfo":{"hasNextPage":false},"edges":[
+					{"cursor":"a","node":{"id":"pending","quantity":{"winston":"100"}}},
+					{"cursor":"b","node":{"id":"known","quantity":{"winston":"100"},"block":{"height":103,"timestamp":%d}}},
+					{"cursor":"c","node":{"id":"mismatch","quantity":{"winston":"200"},"block":{"height":102,"timestamp":%d}}},
+					{"cursor":"d","node":{"id":"attached","quantity":{"winston":"70"},"block":{"height":101,"timestamp":%d}}},
+					{"cursor":"e","node":{"id":"stranger","quantity":{"winston":"50"},"block":{"height":100,"timestamp":%d}}},
+					{"cursor":"f","node":{"id":"old","quantity":{"winston":"50"},"block":{"height":1,"timestamp":1}}}
+				]}}}`, mined, mined, mined, mined)))
+			} else {
+				_, err = w.Write([]byte(fmt.Sprintf(`{"data":{"transactions":{"pageInfo":{"hasNextPage":false},"edges":[
+					{"cursor":"a","node":{"id":"payout","quantity":{"winston":"1000"},"block":{"height":103,"timestamp":%d}}},
+					{"cursor":"b","node":{"id":"data","quantity":{"winston":"0"},"block":{"height":102,"timestamp":%d}}},
+					{"cursor":"c","node":{"id":"rogue","quantity":{"winston":"10"},"block":{"height":101,"timestamp":%d}}}
+				]}}}`, mined, mined, mined)))
+			}
+			assert.NoError(t, err)
+		}))
+	defer arweave.Close()
+
+	w, err := wallet.FromPath("../../test/signer.json", arweave.URL)
+	assert.NoError(t, err)
+	crn, err := New(WithDatabase(db), WithWallet(w))
+	assert.NoError(t, err)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "transfers" WHERE id IN ($1,$2,$3,$4)`)).WithArgs("known", "mismatch", "attached", "stranger").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "quantity"}).AddRow("known", "100").AddRow("mismatch", "150"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "orders" WHERE transaction_id IN ($1,$2,$3,$4)`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "transaction_id"}).AddRow("dataitem", "attached"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "entries" WHERE transaction_id IN ($1,$2)`)).WithArgs("payout", "rogue").
+		WillReturnRows(sqlmock.NewRows([]string{"kind", "amount", "order_id", "transaction_id"}).AddRow("payout", "1000", "paid", "payout").AddRow("network-fee", "10", "paid", "payout"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "entries" WHERE created_at >= $1 AND created_at < $2 ORDER BY created_at, id`)).
+		WillReturnRows(sqlmock.NewRows([]string{"kind", "amount", "order_id", "transaction_id"}).AddRow("payout", "1000", "paid", "payout").AddRow("refund", "500", "refunded", "lost"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "orders" WHERE (status = $1 AND created_at >= $2 AND created_at < $3) AND (NOT EXISTS (SELECT 1 FROM entries WHERE entries.order_id = orders.id AND entries.kind IN ($4,$5,$6)))`)).
+		WithArgs("sent", sqlmock.AnyArg(), sqlmock.AnyArg(), "payout", "upload", "bundled").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("orphan"))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "discrepancies" WHERE 1 = 1`)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "discrepancies" ("kind","order_id","transaction_id","expected","actual","created_at") VALUES ($1,$2,$3,$4,$5,$6),($7,$8,$9,$10,$11,$12),($13,$14,$15,$16,$17,$18),($19,$20,$21,$22,$23,$24),($25,$26,$27,$28,$29,$30) RETURNING "id"`)).
+		WithArgs(
+			"amount-mismatch", "", "mismatch", "150", "200", sqlmock.AnyArg(),
+			"unknown-inbound", "", "stranger", "", "50", sqlmock.AnyArg(),
+			"unknown-outbound", "", "rogue", "", "10", sqlmock.AnyArg(),
+			"missing-payout", "refunded", "lost", "500", "", sqlmock.AnyArg(),
+			"missing-payout", "orphan", "", "", "", sqlmock.AnyArg(),
+		).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2).AddRow(3).AddRow(4).AddRow(5))
+	mock.ExpectCommit()
+
+	crn.Reconcile()
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, 6, crn.jobs[JobReconcile].processed)
+}
+
+func TestSendReports(t *testing.T) {
+	mock, db := test.Database()
+	g := test.Gateway()
+	defer g.Close()
+	w := test.Wallet(g.URL)
+
+	process := test.NewProcess()
+	defer process.Close()
+	ao, err := aogo.New(aogo.WthMU(process.URL))
+	assert.NoError(t, err)
+	pid := "PWSr59Cf6jxY7aA_cfz69rs0IiJWWbmQA8bAKknHeMo"
+	r := NewContractReporter(contract.Custom(ao, pid, w.Signer), ao, pid, w.Signer)
+
+	crn, err := New(WithDatabase(db), WithReporter(r), WithReportPolicy(ReportPolicy{Attempts: 3}))
+	assert.NoError(t, err)
+
+	columns := []string{"Id", "OrderId", "Kind", "Staker", "PaymentId", "Reason", "Attempts"}
+	tags := func(d data_item.DataItem) map[string]string {
+		m := map[string]string{}
+		for _, t := range *d.Tags {
+			m[t.Name] = t.Value
+		}
+		return m
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "reports" WHERE status = $1 AND next_attempt <= $2 ORDER BY id LIMIT $3`)).WithArgs("created", sqlmock.AnyArg(), 25).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(1, "dataitem", "delivered", "staker", "", "", 0).
+				AddRow(2, "dataitem", "payout-sent", "staker", "payout", "", 0).
+				AddRow(3, "other", "undelivered", "down", "", "POST /tx: 502", 1).
+				AddRow(4, "other", "undelivered", "verbose", "", "POST /tx: 500: "+strings.Repeat("é", 3000), 0))
+		for id, attempts := range []int{1, 1, 2, 1} {
+			mock.ExpectBegin()
+			mock.ExpectExec(regexp.QuoteMeta(`UPDATE "reports" SET "status"=$1,"attempts"=$2,"updated_at"=$3 WHERE id = $4`)).WithArgs("sent", attempts, sqlmock.AnyArg(), id+1).WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectCommit()
+		}
+
+		crn.SendReports()
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		messages := process.Messages()
+		assert.Len(t, messages, 4)
+		for _, m := range messages {
+			assert.Equal(t, pid, m.Target)
+		}
+		assert.Equal(t, "Posted", tags(messages[0])["Action"])
+		assert.Equal(t, crypto.Base64URLEncode([]byte("dataitem")), messages[0].Data)
+		assert.Equal(t, "Pay", tags(messages[1])["Action"])
+		assert.Equal(t, "payout", tags(messages[1])["Payment"])
+		assert.Equal(t, "Failed", tags(messages[2])["Action"])
+		assert.Equal(t, "down", tags(messages[2])["Staker"])
+		assert.Equal(t, "POST /tx: 502", tags(messages[2])["Reason"])
+		assert.Equal(t, crypto.Base64URLEncode([]byte("other")), messages[2].Data)
+		// The response of the staker is cut to fit a tag
+		assert.Len(t, tags(messages[3])["Reason"], maxReason-1)
+		assert.True(t, utf8.ValidString(tags(messages[3])["Reason"]))
+	})
+
+	t.Run("Fail:Retry", func(t *testing.T) {
+		process.Down.Store(true)
+		defer process.Down.Store(false)
+
+		// A report is retried later, and given up after the attempts of the policy
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "reports"`)).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(1, "dataitem", "delivered", "staker", "", "", 0).
+				AddRow(2, "dataitem", "payout-sent", "staker", "payout", "", 2))
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "reports" SET "status"=$1,"attempts"=$2,"error"=$3,"next_attempt"=$4,"updated_at"=$5 WHERE id = $6`)).WithArgs("created", 1, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), 1).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "reports" SET "status"=$1,"attempts"=$2,"error"=$3,"next_attempt"=$4,"updated_at"=$5 WHERE id = $6`)).WithArgs("failed", 3, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), 2).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		crn.SendReports()
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.Len(t, process.Messages(), 4)
+	})
+}
@@ -0,0 +1,205 @@
+package cron
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/liteseed/transit/internal/database/schema"
+)
+
+// PaymentTag is the tag a transfer to the transit wallet carries for every data item it pays for
+const PaymentTag = "Data-Item-Id"
+
+const transfersQuery = `query($recipients: [String!], $min: Int, $first: Int, $after: String) {
+  transactions(recipients: $recipients, block: {min: $min}, sort: HEIGHT_ASC, first: $first, after: $after) {
+    pageInfo { hasNextPage }
+    edges { cursor node { id recipient owner { address } quantity { winston } tags { name value } block { height } } }
+  }
+}`
+
+type transfer struct {
+	Id        string `json:"id"`
+	Recipient string `json:"recipient"`
+	Owner     struct {
+		Address string `json:"address"`
+	} `json:"owner"`
+	Quantity struct {
+		Winston string `json:"winston"`
+	} `json:"quantity"`
+	Tags []struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	} `json:"tags"`
+	Block *struct {
+		Height    int64 `json:"height"`
+		Timestamp int64 `json:"timestamp"`
+	} `json:"block"`
+}
+
+type transfersPage struct {
+	PageInfo struct {
+		HasNextPage bool `json:"hasNextPage"`
+	} `json:"pageInfo"`
+	Edges []struct {
+		Cursor string   `json:"cursor"`
+		Node   transfer `json:"node"`
+	} `json:"edges"`
+}
+
+type transfersResponse struct {
+	Data struct {
+		Transactions transfersPage `json:"transactions"`
+	} `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// DiscoverPayments scans the transfers to the transit wallet for PaymentTag tags and attaches
+// them to their orders, so users do not have to send the payment id themselves. Every run
+// starts again from the highest block seen, which is saved in the database, attaching a
+// payment twice is a no-op. A transfer
+// that can not pay for an order is not attached, and a discovered payment rejected later is
+// detached so the user can still send one.
+func (crn *Cron) DiscoverPayments() {
+	j := crn.jobs[JobDiscoverPayments]
+	deadline := time.Now().Add(time.Duration(j.config.Budget) * time.Second)
+
+	min, err := crn.database.GetCursor(JobDiscoverPayments)
+	if err != nil {
+		crn.logger.Error("fail: database - get cursor", "err", err)
+		return
+	}
+	height := min
+	processed := 0
+	after := ""
+	for time.Now().Before(deadline) {
+		res, err := crn.transfers(min, j.config.BatchSize, after)
+		if err != nil {
+			crn.logger.Error("fail: gateway - get transfers", "err", err)
+			break
+		}
+		for _, edge := range res.Edges {
+			crn.attachPayment(&edge.Node)
+			if edge.Node.Block != nil && edge.Node.Block.Height > height {
+				height = edge.Node.Block.Height
+			}
+			after = edge.Cursor
+		}
+		processed += len(res.Edges)
+		if !res.PageInfo.HasNextPage || len(res.Edges) == 0 {
+			break
+		}
+	}
+
+	if height > min {
+		err = crn.database.SaveCursor(JobDiscoverPayments, height)
+		if err != nil {
+			crn.logger.Error("fail: database - save cursor", "err", err)
+		}
+	}
+
+	j.report(processed, 0)
+	crn.logger.Info("cron: "+JobDiscoverPayments, "processed", processed, "height", height)
+}
+
+// payable reports whether t may pay for o, the amount is checked against the price when the
+// payment is allocated
+func (crn *Cron) payable(t *transfer, o *schema.Order) bool {
+	quantity, ok := new(big.Int).SetString(t.Quantity.Winston, 10)
+	return ok && quantity.Sign() > 0 && crn.receives(t.Recipient) && crn.payers.allowed(o, t.Owner.Address)
+}
+
+func (crn *Cron) attachPayment(t *transfer) {
+	for _, tag := range t.Tags {
+		if tag.Name != PaymentTag {
+			continue
+		}
+		order, err := crn.database.GetOrder(tag.Value)
+		if err != nil {
+			crn.logger.Error("fail: database - get order", "id", tag.Value, "err", err)
+			continue
+		}
+		if !crn.payable(t, order) {
+			crn.logger.Warn("cron: discovered payment not attached", "id", order.Id, "transaction", t.Id)
+			continue
+		}
+		var u *schema.Order
+		switch {
+		case order.Status == schema.Created && order.TransactionId == "":
+			var height int64
+			if t.Block != nil {
+				height = t.Block.Height
+			}
+			if order.PastDeadline(crn.paymentDeadline, height) {
+				continue
+			}
+			u = &schema.Order{TransactionId: t.Id, Status: schema.Queued, Discovered: true}
+		case order.Payment == schema.Partial && order.Status != schema.Expired && order.TransactionId != t.Id:
+			// A top-up of a partially paid order
+			u = &schema.Order{TransactionId: t.Id, Payment: schema.Unpaid, Discovered: true}
+		default:
+			continue
+		}
+		err = crn.database.UpdateOrder(order.Id, u)
+		if err != nil {
+			crn.logger.Error("fail: database - update order", "err", err)
+			continue
+		}
+		crn.logger.Info("cron: payment discovered", "id", order.Id, "transaction", t.Id)
+	}
+}
+
+// transfers queries the gateway GraphQL endpoint for mined transfers to the addresses receiving payments
+func (crn *Cron) transfers(min int64, first int, after string) (*transfersPage, error) {
+	variables := map[string]any{"recipients": crn.addresses(), "min": min, "first": first}
+	if after != "" {
+		variables["after"] = after
+	}
+	return crn.graphql(transfersQuery, variables)
+}
+
+// graphql runs a query returning a page of transactions against the gateway GraphQL endpoint
+func (crn *Cron) graphql(query string, variables map[string]any) (*transfersPage, error) {
+	payload, err := json.Marshal(map[string]any{"query": query, "variables": variables})
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := url.Parse(crn.wallet.Client.Gateway)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = path.Join(u.Path, "graphql")
+
+	resp, err := crn.wallet.Client.Client.Post(u.String(), "application/json", bytes.NewBuffer(payload))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%d: %s", resp.StatusCode, string(body))
+	}
+
+	var res transfersResponse
+	err = json.Unmarshal(body, &res)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Errors) > 0 {
+		return nil, fmt.Errorf("graphql: %s", res.Errors[0].Message)
+	}
+	return &res.Data.Transactions, nil
+}
@@ -0,0 +1,43 @@
+package cron
+
+import (
+	"github.com/liteseed/transit/internal/database"
+	"github.com/liteseed/transit/internal/database/schema"
+)
+
+// ExpireOrders moves orders that were not paid in full before their payment deadline to
+// expired and releases the reservation of their staker in the contract. What a partially
+// paid order received is refunded by QueueRefunds once it is expired.
+func (crn *Cron) ExpireOrders() {
+	var height int64
+	info, err := crn.wallet.Client.GetNetworkInfo()
+	if err != nil {
+		// Without the block height only the wall-clock deadline is checked
+		crn.logger.Error("fail: gateway - get network info", "err", err)
+	} else {
+		height = info.Height
+	}
+
+	crn.drain(JobExpireOrders, &schema.Order{}, func(order *schema.Order) {
+		if !order.PastDeadline(crn.paymentDeadline, height) {
+			return
+		}
+		// The order is expired once the reservation is released so a failed release is retried next run
+		err := crn.contract.Release(order.Id)
+		if err != nil {
+			crn.logger.Error("fail: contract - release", "id", order.Id, "err", err)
+			return
+		}
+		err = crn.database.UpdateOrder(order.Id, &schema.Order{Status: schema.Expired})
+		if err != nil {
+			crn.logger.Error("fail: database - update order", "err", err)
+			return
+		}
+		if order.Backend == schema.SelfBundled {
+			err = crn.database.DeleteDataItem(order.Id)
+			if err != nil {
+				crn.logger.Error("fail: database - delete data item", "err", err)
+			}
+		}
+	}, database.Expirable)
+}
@@ -0,0 +1,215 @@
+package cron
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/liteseed/transit/internal/database"
+	"github.com/liteseed/transit/internal/database/schema"
+)
+
+const (
+	JobCheckPaymentsConfirmations = "check-payments-confirmations"
+	JobSendPayments               = "send-payments"
+	JobMonitorBalance             = "monitor-balance"
+	JobExpireOrders               = "expire-orders"
+	JobVerifyPayments             = "verify-payments"
+	JobDiscoverPayments           = "discover-payments"
+	JobQueueRefunds               = "queue-refunds"
+	JobSendRefunds                = "send-refunds"
+	JobReconcile                  = "reconcile"
+	JobProbeGateways              = "probe-gateways"
+	JobRefreshPrices              = "refresh-prices"
+	JobProbeStakers               = "probe-stakers"
+	JobSendBundles                = "send-bundles"
+	JobConfirmBundles             = "confirm-bundles"
+	JobSendReports                = "send-reports"
+)
+
+var (
+	ErrJobNotFound = errors.New("job not found")
+	ErrJobRunning  = errors.New("job is already running")
+)
+
+// JobConfig controls when a job runs and how much work it does per run.
+type JobConfig struct {
+	Schedule  string // cron spec, e.g. "* * * * *"
+	BatchSize int    // orders fetched per query
+	Workers   int    // orders processed concurrently
+	Budget    int    // seconds a run may spend walking the backlog
+}
+
+var DefaultJobConfig = JobConfig{
+	Schedule:  "* * * * *",
+	BatchSize: 25,
+	Workers:   1,
+	Budget:    50,
+}
+
+// JobStatus is the outcome of the last run of a job
+type JobStatus struct {
+	Name      string    `json:"name"`
+	Schedule  string    `json:"schedule"`
+	Running   bool      `json:"running"`
+	LastRun   time.Time `json:"lastRun"`
+	Processed int       `json:"processed"`
+	Remaining int64     `json:"remaining"`
+}
+
+type job struct {
+	config  JobConfig
+	mu      sync.Mutex
+	run     func()
+	running atomic.Bool
+
+	statusMu  sync.Mutex
+	lastRun   time.Time
+	processed int
+	remaining int64
+}
+
+// Run implements cron.Job. A run is skipped if the previous one has not finished yet.
+func (j *job) Run() {
+	if !j.mu.TryLock() {
+		return
+	}
+	defer j.mu.Unlock()
+	j.exec()
+}
+
+func (j *job) exec() {
+	j.running.Store(true)
+	defer j.running.Store(false)
+	j.run()
+}
+
+func (j *job) report(processed int, remaining int64) {
+	j.statusMu.Lock()
+	defer j.statusMu.Unlock()
+	j.lastRun = time.Now()
+	j.processed = processed
+	j.remaining = remaining
+}
+
+func (j *job) merge(config JobConfig) {
+	if config.Schedule != "" {
+		j.config.Schedule = config.Schedule
+	}
+	if config.BatchSize > 0 {
+		j.config.BatchSize = config.BatchSize
+	}
+	if config.Workers > 0 {
+		j.config.Workers = config.Workers
+	}
+	if config.Budget > 0 {
+		j.config.Budget = config.Budget
+	}
+}
+
+// Trigger runs a job immediately in the background.
+// It fails with ErrJobRunning if the job is already running.
+func (c *Cron) Trigger(name string) error {
+	j, ok := c.jobs[name]
+	if !ok {
+		return ErrJobNotFound
+	}
+	if !j.mu.TryLock() {
+		return ErrJobRunning
+	}
+	go func() {
+		defer j.mu.Unlock()
+		j.exec()
+	}()
+	return nil
+}
+
+// Jobs returns the names of the registered jobs
+func (c *Cron) Jobs() []string {
+	names := make([]string, 0, len(c.jobs))
+	for name := range c.jobs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Status returns the outcome of the last run of every job
+func (c *Cron) Status() []JobStatus {
+	status := []JobStatus{}
+	for _, name := range c.Jobs() {
+		j := c.jobs[name]
+		j.statusMu.Lock()
+		status = append(status, JobStatus{
+			Name:      name,
+			Schedule:  j.config.Schedule,
+			Running:   j.running.Load(),
+			LastRun:   j.lastRun,
+			Processed: j.processed,
+			Remaining: j.remaining,
+		})
+		j.statusMu.Unlock()
+	}
+	return status
+}
+
+// drain walks the orders matching filter and scopes page by page, oldest first, until the
+// backlog is empty or the time budget of the job is spent. Orders left unchanged by fn are
+// not visited twice in the same run.
+func (c *Cron) drain(name string, filter *schema.Order, fn func(*schema.Order), scopes ...database.Scope) {
+	j := c.jobs[name]
+	deadline := time.Now().Add(time.Duration(j.config.Budget) * time.Second)
+
+	var last *schema.Order
+	processed := 0
+	for time.Now().Before(deadline) {
+		orders, err := c.database.GetOrders(filter, j.config.BatchSize, append([]database.Scope{database.OldestFirst, database.After(last)}, scopes...)...)
+		if err != nil {
+			c.logger.Error("fail: database - get orders", "error", err)
+			break
+		}
+		c.process(name, *orders, fn)
+		processed += len(*orders)
+		if len(*orders) < j.config.BatchSize {
+			break
+		}
+		last = &(*orders)[len(*orders)-1]
+	}
+
+	remaining, err := c.database.CountOrders(filter, scopes...)
+	if err != nil {
+		c.logger.Error("fail: database - count orders", "error", err)
+	}
+	j.report(processed, remaining)
+	c.logger.Info("cron: "+name, "processed", processed, "remaining", remaining)
+}
+
+// process calls fn for every order using the worker pool size of the job
+func (c *Cron) process(name string, orders []schema.Order, fn func(*schema.Order)) {
+	workers := c.jobs[name].config.Workers
+	if workers <= 1 {
+		for i := range orders {
+			fn(&orders[i])
+		}
+		return
+	}
+
+	queue := make(chan *schema.Order)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for o := range queue {
+				fn(o)
+			}
+		}()
+	}
+	for i := range orders {
+		queue <- &orders[i]
+	}
+	close(queue)
+	wg.Wait()
+}
@@ -0,0 +1,129 @@
+package cron
+
+import (
+	"expvar"
+	"math/big"
+	"time"
+
+	"github.com/liteseed/transit/internal/database/schema"
+)
+
+var walletMetrics = expvar.NewMap("wallet")
+
+// WalletStatus is the outcome of the last balance check of the transit wallet
+type WalletStatus struct {
+	Address     string    `json:"address"`
+	Balance     string    `json:"balance"`
+	Obligations string    `json:"obligations"`
+	Threshold   string    `json:"threshold"`
+	Low         bool      `json:"low"`
+	Paused      bool      `json:"paused"`
+	CheckedAt   time.Time `json:"checkedAt"`
+}
+
+// WalletStatus returns the outcome of the last balance check
+func (crn *Cron) WalletStatus() WalletStatus {
+	crn.walletMu.Lock()
+	defer crn.walletMu.Unlock()
+	return crn.walletStatus
+}
+
+func (crn *Cron) payoutsPaused() bool {
+	crn.walletMu.Lock()
+	defer crn.walletMu.Unlock()
+	return crn.walletStatus.Paused
+}
+
+// obligations estimates what the pending payouts cost: the price of their data
+// plus, for every payout, the base price and the transaction reward.
+func (crn *Cron) obligations() (*big.Int, error) {
+	count, size, err := crn.database.SumOrderSizes(&schema.Order{Status: schema.Queued, Payment: schema.Paid})
+	if err != nil {
+		return nil, err
+	}
+	total := big.NewInt(0)
+	if count == 0 {
+		return total, nil
+	}
+	p, err := crn.wallet.Client.GetTransactionPrice(int(size), "")
+	if err != nil {
+		return nil, err
+	}
+	total.SetString(p, 10)
+
+	base, err := crn.prices.Price(0)
+	if err != nil {
+		return nil, err
+	}
+	fee, _ := new(big.Int).SetString(base, 10)
+	if fee == nil {
+		fee = big.NewInt(0)
+	}
+	fee.Mul(fee, big.NewInt(2*count))
+	return total.Add(total, fee), nil
+}
+
+// MonitorBalance compares the balance of the transit wallet with the pending payouts.
+// Payouts are paused while the balance cannot cover them and resume once it is topped up.
+func (crn *Cron) MonitorBalance() {
+	address := crn.signer.Address()
+	b, err := crn.wallet.Client.GetWalletBalance(address)
+	if err != nil {
+		crn.logger.Error("fail: gateway - get wallet balance", "err", err)
+		return
+	}
+	balance, ok := new(big.Int).SetString(b, 10)
+	if !ok {
+		crn.logger.Error("fail: internal - invalid wallet balance", "balance", b)
+		return
+	}
+	obligations, err := crn.obligations()
+	if err != nil {
+		crn.logger.Error("fail: internal - estimate obligations", "err", err)
+		return
+	}
+	threshold, ok := new(big.Int).SetString(crn.balanceThreshold, 10)
+	if !ok {
+		threshold = big.NewInt(0)
+	}
+
+	paused := balance.Cmp(obligations) < 0
+	low := balance.Cmp(threshold) < 0
+
+	crn.walletMu.Lock()
+	previous := crn.walletStatus
+	crn.walletStatus = WalletStatus{
+		Address:     address,
+		Balance:     balance.String(),
+		Obligations: obligations.String(),
+		Threshold:   threshold.String(),
+		Low:         low,
+		Paused:      paused,
+		CheckedAt:   time.Now(),
+	}
+	crn.walletMu.Unlock()
+
+	walletMetrics.Set("balance", stringVar(balance.String()))
+	walletMetrics.Set("obligations", stringVar(obligations.String()))
+	p := new(expvar.Int)
+	if paused {
+		p.Set(1)
+	}
+	walletMetrics.Set("paused", p)
+
+	// Only alert on changes so a drained wallet does not flood the channel
+	if paused && !previous.Paused {
+		crn.alert("payouts paused - wallet balance does not cover pending payouts", "address", address, "balance", balance.String(), "obligations", obligations.String())
+	} else if !paused && previous.Paused {
+		crn.alert("payouts resumed - wallet balance covers pending payouts", "address", address, "balance", balance.String(), "obligations", obligations.String())
+	}
+	if low && !previous.Low {
+		crn.alert("wallet balance below threshold", "address", address, "balance", balance.String(), "threshold", threshold.String())
+	}
+}
+
+func stringVar(s string) *expvar.String {
+	v := new(expvar.String)
+	v.Set(s)
+	return v
+}
@@ -0,0 +1,35 @@
+package cron
+
+import (
+	"github.com/liteseed/transit/internal/bundler"
+	"github.com/liteseed/transit/internal/database/schema"
+	"github.com/liteseed/transit/internal/pricing"
+)
+
+// backend returns the backend holding the data item of an order, nil if the upload service
+// of the order is no longer configured
+func (crn *Cron) backend(o *schema.Order) bundler.Bundler {
+	if o.Backend == "" {
+		return crn.bundler
+	}
+	return crn.backends[o.Backend]
+}
+
+// quote prices an order with the fee policy, the way it was quoted to the user. Every
+// replica of the data item is priced as an upload of its own, uploads to a service at the
+// price of the service.
+func (crn *Cron) quote(o *schema.Order) (*pricing.Quote, error) {
+	price := crn.prices.Price
+	if p, ok := crn.backend(o).(bundler.Pricer); ok && o.Backend != "" {
+		price = p.Price
+	}
+	p, err := price(o.Size)
+	if err != nil {
+		return nil, err
+	}
+	q, err := pricing.NewQuote(crn.fee, p, o.Size, o.ApiKey)
+	if err != nil {
+		return nil, err
+	}
+	return q.Replicate(o.Copies()), nil
+}
@@ -0,0 +1,33 @@
+package cron
+
+import "github.com/liteseed/transit/internal/gateway"
+
+// ProbeGateways checks the health of the gateways, so requests go to those that answer.
+// A gateway marked down by a failed request is tried first again once it answers a probe.
+func (crn *Cron) ProbeGateways() {
+	if crn.gateways == nil {
+		return
+	}
+	crn.gateways.Probe()
+
+	status := crn.gateways.Status()
+	down := 0
+	for _, g := range status {
+		if !g.Healthy {
+			down++
+			crn.logger.Warn("cron: "+JobProbeGateways+" - gateway down", "url", g.URL)
+		}
+	}
+	if down == len(status) {
+		crn.alert("all gateways are down")
+	}
+	crn.jobs[JobProbeGateways].report(len(status), 0)
+}
+
+// Gateways returns the health of the gateways, nil if none are configured
+func (crn *Cron) Gateways() []gateway.Status {
+	if crn.gateways == nil {
+		return nil
+	}
+	return crn.gateways.Status()
+}
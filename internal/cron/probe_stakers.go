@@ -0,0 +1,27 @@
+package cron
+
+import "slices"
+
+// ProbeStakers checks that the stakers transit sent uploads to and the upload services
+// still answer. Stakers that do not lose score and are quarantined once their score is too low.
+func (crn *Cron) ProbeStakers() {
+	down := []string{}
+	stakers := 0
+	if crn.bundler != nil {
+		down = append(down, crn.bundler.Probe()...)
+		stakers = len(crn.bundler.Stakers())
+	}
+	names := make([]string, 0, len(crn.backends))
+	for name := range crn.backends {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		down = append(down, crn.backends[name].Probe()...)
+		stakers++
+	}
+	for _, url := range down {
+		crn.logger.Warn("cron: "+JobProbeStakers+" - staker down", "url", url)
+	}
+	crn.jobs[JobProbeStakers].report(stakers, int64(len(down)))
+}
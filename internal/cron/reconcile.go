@@ -0,0 +1,212 @@
+package cron
+
+import (
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/liteseed/transit/internal/database/schema"
+	"github.com/liteseed/transit/internal/ledger"
+)
+
+// ReconcilePolicy controls the wallet history compared with the database
+type ReconcilePolicy struct {
+	Window int // seconds of wallet history compared on every run
+	Delay  int // seconds left for transfers to be mined and recorded before they are compared
+}
+
+var DefaultReconcilePolicy = ReconcilePolicy{Window: 604800, Delay: 3600}
+
+var errReconcileBudget = errors.New("time budget spent before the wallet history was listed")
+
+const historyQuery = `query($recipients: [String!], $owners: [String!], $first: Int, $after: String) {
+  transactions(recipients: $recipients, owners: $owners, sort: HEIGHT_DESC, first: $first, after: $after) {
+    pageInfo { hasNextPage }
+    edges { cursor node { id recipient owner { address } quantity { winston } block { height timestamp } } }
+  }
+}`
+
+// Reconcile compares the transfers of the transit wallet on chain over the reconciliation
+// window with the orders, payouts and refunds in the database. The discrepancies found
+// replace those of the previous run.
+func (crn *Cron) Reconcile() {
+	j := crn.jobs[JobReconcile]
+	now := time.Now()
+	from := now.Add(-time.Duration(crn.reconcile.Window) * time.Second)
+	to := now.Add(-time.Duration(crn.reconcile.Delay) * time.Second)
+
+	discrepancies, processed, err := crn.FindDiscrepancies(from, to)
+	if err != nil {
+		crn.logger.Error("fail: gateway - reconcile wallet history", "err", err)
+		return
+	}
+	err = crn.database.ReplaceDiscrepancies(discrepancies)
+	if err != nil {
+		crn.logger.Error("fail: database - replace discrepancies", "err", err)
+		return
+	}
+	if len(discrepancies) > 0 {
+		crn.alert("wallet history does not match the database", "discrepancies", len(discrepancies))
+	}
+
+	j.report(processed, 0)
+	crn.logger.Info("cron: "+JobReconcile, "processed", processed, "discrepancies", len(discrepancies))
+}
+
+// FindDiscrepancies compares the transfers of the transit wallet mined since from with what
+// is recorded until to, and returns the differences with the number of transfers compared.
+// Transfers and payouts after to are left to a later run, as they may not be mined or
+// recorded yet.
+func (crn *Cron) FindDiscrepancies(from time.Time, to time.Time) ([]schema.Discrepancy, int, error) {
+	deadline := time.Now().Add(time.Duration(crn.jobs[JobReconcile].config.Budget) * time.Second)
+	inbound, err := crn.history(true, from, deadline)
+	if err != nil {
+		return nil, 0, err
+	}
+	outbound, err := crn.history(false, from, deadline)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	discrepancies := []schema.Discrepancy{}
+
+	// Every payment is recorded as a transfer or attached to an order
+	ids := []string{}
+	for _, t := range inbound {
+		if t.Block.Timestamp < to.Unix() {
+			ids = append(ids, t.Id)
+		}
+	}
+	if len(ids) > 0 {
+		transfers, err := crn.database.GetTransfers(ids)
+		if err != nil {
+			return nil, 0, err
+		}
+		orders, err := crn.database.GetOrdersByTransaction(ids)
+		if err != nil {
+			return nil, 0, err
+		}
+		recorded := map[string]string{}
+		for _, t := range transfers {
+			recorded[t.Id] = t.Quantity
+		}
+		for _, o := range orders {
+			if _, ok := recorded[o.TransactionId]; !ok {
+				recorded[o.TransactionId] = ""
+			}
+		}
+		for _, t := range inbound {
+			if t.Block.Timestamp >= to.Unix() {
+				continue
+			}
+			quantity, ok := recorded[t.Id]
+			switch {
+			case !ok:
+				discrepancies = append(discrepancies, schema.Discrepancy{Kind: schema.UnknownInbound, TransactionId: t.Id, Actual: t.Quantity.Winston})
+			case quantity != "" && !sameAmount(quantity, t.Quantity.Winston):
+				discrepancies = append(discrepancies, schema.Discrepancy{Kind: schema.AmountMismatch, TransactionId: t.Id, Expected: quantity, Actual: t.Quantity.Winston})
+			}
+		}
+	}
+
+	// Every transfer out is a payout or a refund in the ledger
+	sent := map[string]bool{}
+	ids = []string{}
+	for _, t := range outbound {
+		sent[t.Id] = true
+		ids = append(ids, t.Id)
+	}
+	if len(ids) > 0 {
+		entries, err := crn.database.GetEntriesByTransaction(ids)
+		if err != nil {
+			return nil, 0, err
+		}
+		recorded := map[string]schema.Entry{}
+		for _, e := range entries {
+			if paidOut(&e) {
+				recorded[e.TransactionId] = e
+			}
+		}
+		for _, t := range outbound {
+			e, ok := recorded[t.Id]
+			switch {
+			case !ok:
+				discrepancies = append(discrepancies, schema.Discrepancy{Kind: schema.UnknownOutbound, TransactionId: t.Id, Actual: t.Quantity.Winston})
+			case !sameAmount(e.Amount, t.Quantity.Winston):
+				discrepancies = append(discrepancies, schema.Discrepancy{Kind: schema.AmountMismatch, OrderId: e.OrderId, TransactionId: t.Id, Expected: e.Amount, Actual: t.Quantity.Winston})
+			}
+		}
+	}
+
+	// Every payout and refund recorded is on chain, and every order sent was paid out
+	entries, err := crn.database.GetEntries(from, to)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, e := range *entries {
+		if paidOut(&e) && !sent[e.TransactionId] {
+			discrepancies = append(discrepancies, schema.Discrepancy{Kind: schema.MissingPayout, OrderId: e.OrderId, TransactionId: e.TransactionId, Expected: e.Amount})
+		}
+	}
+	orders, err := crn.database.GetSentOrdersWithoutPayout(from, to)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, o := range orders {
+		discrepancies = append(discrepancies, schema.Discrepancy{Kind: schema.MissingPayout, OrderId: o.Id})
+	}
+
+	return discrepancies, len(inbound) + len(outbound), nil
+}
+
+// history lists the mined transfers of winston to or from the transit wallet since from,
+// newest first. It fails rather than return part of the history once deadline is passed.
+func (crn *Cron) history(inbound bool, from time.Time, deadline time.Time) ([]transfer, error) {
+	variables := map[string]any{"first": crn.jobs[JobReconcile].config.BatchSize}
+	if inbound {
+		variables["recipients"] = crn.addresses()
+	} else {
+		variables["owners"] = []string{crn.signer.Address()}
+	}
+
+	transfers := []transfer{}
+	for {
+		if time.Now().After(deadline) {
+			return nil, errReconcileBudget
+		}
+		res, err := crn.graphql(historyQuery, variables)
+		if err != nil {
+			return nil, err
+		}
+		for _, edge := range res.Edges {
+			variables["after"] = edge.Cursor
+			t := edge.Node
+			if t.Block == nil {
+				continue
+			}
+			if t.Block.Timestamp < from.Unix() {
+				return transfers, nil
+			}
+			if q, ok := new(big.Int).SetString(t.Quantity.Winston, 10); ok && q.Sign() > 0 {
+				transfers = append(transfers, t)
+			}
+		}
+		if !res.PageInfo.HasNextPage || len(res.Edges) == 0 {
+			return transfers, nil
+		}
+	}
+}
+
+// paidOut reports whether an entry records winston sent to a staker or back to a payer
+func paidOut(e *schema.Entry) bool {
+	return e.Kind == ledger.Payout || e.Kind == ledger.Refund
+}
+
+func sameAmount(a string, b string) bool {
+	x, ok := new(big.Int).SetString(a, 10)
+	if !ok {
+		return false
+	}
+	y, ok := new(big.Int).SetString(b, 10)
+	return ok && x.Cmp(y) == 0
+}
@@ -0,0 +1,14 @@
+package cron
+
+// RefreshPrices fetches again the network prices recently quoted, so price lookups are
+// served from the cache instead of waiting on the gateway.
+func (crn *Cron) RefreshPrices() {
+	if crn.prices == nil {
+		return
+	}
+	refreshed, err := crn.prices.Refresh()
+	if err != nil {
+		crn.logger.Error("fail: gateway - refresh prices", "error", err)
+	}
+	crn.jobs[JobRefreshPrices].report(refreshed, int64(crn.prices.Len()-refreshed))
+}
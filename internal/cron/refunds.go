@@ -0,0 +1,194 @@
+package cron
+
+import (
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/liteseed/transit/internal/database"
+	"github.com/liteseed/transit/internal/database/schema"
+	"github.com/liteseed/transit/internal/ledger"
+)
+
+// RefundPolicy controls how refunds are worked out and sent
+type RefundPolicy struct {
+	Fee         string // winston kept from every refund, the network price of a transfer if empty
+	Settle      int    // seconds after which what is left of a transfer is refunded
+	AutoApprove bool   // send refunds without waiting for an admin to approve them
+}
+
+var DefaultRefundPolicy = RefundPolicy{Settle: 86400}
+
+// refundFee is the network fee kept from every refund
+func (crn *Cron) refundFee() (*big.Int, error) {
+	fee := crn.refunds.Fee
+	if fee == "" {
+		p, err := crn.prices.Price(0)
+		if err != nil {
+			return nil, err
+		}
+		fee = p
+	}
+	f, ok := new(big.Int).SetString(fee, 10)
+	if !ok {
+		return big.NewInt(0), nil
+	}
+	return f, nil
+}
+
+// newRefund works out a refund of owed winston to address. Refunds that do not cover the fee are denied.
+func (crn *Cron) newRefund(orderId string, transferId string, reason schema.RefundReason, address string, owed *big.Int, fee *big.Int) *schema.Refund {
+	r := &schema.Refund{
+		OrderId:    orderId,
+		TransferId: transferId,
+		Reason:     reason,
+		Address:    address,
+		Owed:       owed.String(),
+		Fee:        fee.String(),
+		Amount:     "0",
+		Status:     schema.RefundPending,
+	}
+	amount := new(big.Int).Sub(owed, fee)
+	switch {
+	case amount.Sign() <= 0:
+		r.Status = schema.RefundDenied
+	case crn.refunds.AutoApprove:
+		r.Status = schema.RefundApproved
+		r.Amount = amount.String()
+	default:
+		r.Amount = amount.String()
+	}
+	return r
+}
+
+// QueueRefunds queues refunds for what failed and expired orders received and for what is
+// left of transfers once all their orders are done.
+func (crn *Cron) QueueRefunds() {
+	j := crn.jobs[JobQueueRefunds]
+	fee, err := crn.refundFee()
+	if err != nil {
+		crn.logger.Error("fail: gateway - get transaction price", "err", err)
+		return
+	}
+
+	processed := 0
+	allocations, err := crn.database.GetRefundableAllocations(j.config.BatchSize)
+	if err != nil {
+		crn.logger.Error("fail: database - get refundable allocations", "err", err)
+	}
+	for _, a := range allocations {
+		owed, ok := new(big.Int).SetString(a.Amount, 10)
+		if !ok {
+			continue
+		}
+		err = crn.database.CreateRefund(crn.newRefund(a.OrderId, a.TransferId, schema.RefundFailed, a.Payer, owed, fee))
+		if err != nil {
+			crn.logger.Error("fail: database - create refund", "err", err)
+			continue
+		}
+		processed++
+	}
+
+	before := time.Now().Add(-time.Duration(crn.refunds.Settle) * time.Second)
+	transfers, err := crn.database.GetSettleableTransfers(before, j.config.BatchSize)
+	if err != nil {
+		crn.logger.Error("fail: database - get settleable transfers", "err", err)
+	}
+	for _, t := range transfers {
+		quantity, _ := new(big.Int).SetString(t.Quantity, 10)
+		allocated, _ := new(big.Int).SetString(t.Allocated, 10)
+		if quantity == nil || allocated == nil {
+			continue
+		}
+		var r *schema.Refund
+		if left := new(big.Int).Sub(quantity, allocated); left.Sign() > 0 {
+			reason := schema.RefundReason(schema.RefundOverpaid)
+			if allocated.Sign() == 0 {
+				reason = schema.RefundInvalid
+			}
+			r = crn.newRefund("", t.Id, reason, t.Payer, left, fee)
+		}
+		err = crn.database.SettleTransfer(t.Id, r)
+		if err != nil {
+			crn.logger.Error("fail: database - settle transfer", "err", err)
+			continue
+		}
+		processed++
+	}
+
+	j.report(processed, 0)
+	crn.logger.Info("cron: "+JobQueueRefunds, "processed", processed)
+}
+
+// SendRefunds sends approved refunds from the transit wallet and follows the confirmations
+// of the sent ones.
+func (crn *Cron) SendRefunds() {
+	j := crn.jobs[JobSendRefunds]
+	processed := 0
+
+	if crn.payoutsPaused() {
+		crn.logger.Warn("cron: " + JobSendRefunds + " paused - insufficient wallet balance")
+	} else {
+		refunds, err := crn.database.GetRefunds(&schema.Refund{Status: schema.RefundApproved}, j.config.BatchSize)
+		if err != nil {
+			crn.logger.Error("fail: database - get refunds", "err", err)
+		} else {
+			for i := range *refunds {
+				crn.sendRefund(&(*refunds)[i])
+				processed++
+			}
+		}
+	}
+
+	refunds, err := crn.database.GetRefunds(&schema.Refund{Status: schema.RefundSent}, j.config.BatchSize)
+	if err != nil {
+		crn.logger.Error("fail: database - get refunds", "err", err)
+	} else {
+		for _, r := range *refunds {
+			status, err := crn.transactionStatus(r.TransactionId)
+			if err != nil || status == nil {
+				continue
+			}
+			u := &schema.Refund{Confirmations: status.NumberOfConfirmations}
+			if status.NumberOfConfirmations >= crn.confirmations {
+				u.Status = schema.RefundConfirmed
+			}
+			err = crn.database.UpdateRefund(r.Id, u)
+			if err != nil {
+				crn.logger.Error("fail: database - update refund", "err", err)
+			}
+		}
+	}
+
+	j.report(processed, 0)
+	crn.logger.Info("cron: "+JobSendRefunds, "processed", processed)
+}
+
+// sendRefund records the refund transaction before it is sent, so a refund is never sent twice.
+// A refund whose transaction may not have reached the gateway stays sent: the confirmations
+// are followed by SendRefunds and Reconcile reports it as a missing payout if it never lands.
+func (crn *Cron) sendRefund(r *schema.Refund) {
+	tx := crn.wallet.CreateTransaction(nil, r.Address, r.Amount, nil)
+	err := crn.signTransaction(tx)
+	if err != nil {
+		crn.logger.Error("fail: internal - sign transaction", "err", err)
+		return
+	}
+	err = crn.database.TransitionRefund(r.Id, &schema.Refund{Status: schema.RefundSent, TransactionId: tx.ID}, schema.RefundApproved)
+	if err != nil {
+		// Unless it was held or denied in the meantime
+		if !errors.Is(err, database.ErrRefundStatus) {
+			crn.logger.Error("fail: database - update refund", "err", err)
+		}
+		return
+	}
+	err = crn.wallet.SendTransaction(tx)
+	if err != nil {
+		crn.logger.Error("fail: gateway - send winston to address", "refund", r.Id, "transaction", tx.ID, "err", err)
+	}
+	r.TransactionId = tx.ID
+	err = crn.database.RecordEntries(ledger.RefundEntries(r, tx.Reward)...)
+	if err != nil {
+		crn.logger.Error("fail: database - record entries", "err", err)
+	}
+}
@@ -0,0 +1,138 @@
+package cron
+
+import (
+	"time"
+	"unicode/utf8"
+
+	"github.com/liteseed/aogo"
+	goar "github.com/liteseed/goar/signer"
+	"github.com/liteseed/goar/tag"
+	"github.com/liteseed/sdk-go/contract"
+	"github.com/liteseed/transit/internal/database/schema"
+)
+
+// Reporter tells the AO process how the uploads to its stakers went, so it can weigh the
+// reputation of the stakers on what transit saw
+type Reporter interface {
+	// Delivered reports that the staker reserved for a data item took it
+	Delivered(id string) error
+	// Paid reports the payout of a staker for a data item
+	Paid(id string, paymentID string) error
+	// Failed reports that a staker failed or rejected a data item
+	Failed(id string, staker string, reason string) error
+}
+
+// maxReason bounds the bytes of the reason of a Failed report, which holds the response of
+// the staker. Tag values are capped at 3072 bytes.
+const maxReason = 512
+
+// ContractReporter reports through the contract client, with Posted and Pay. The client has
+// no message for a failed delivery, which is sent to the process as a Failed action, signed
+// with the key of the client.
+type ContractReporter struct {
+	contract *contract.Contract
+	ao       *aogo.AO
+	process  string
+	signer   *goar.Signer
+}
+
+func NewContractReporter(c *contract.Contract, ao *aogo.AO, process string, s *goar.Signer) *ContractReporter {
+	return &ContractReporter{contract: c, ao: ao, process: process, signer: s}
+}
+
+func (r *ContractReporter) Delivered(id string) error {
+	return r.contract.Posted(id)
+}
+
+func (r *ContractReporter) Paid(id string, paymentID string) error {
+	return r.contract.Pay(id, paymentID)
+}
+
+func (r *ContractReporter) Failed(id string, staker string, reason string) error {
+	tags := &[]tag.Tag{{Name: "Action", Value: "Failed"}, {Name: "Staker", Value: staker}, {Name: "Reason", Value: truncate(reason, maxReason)}}
+	_, err := r.ao.SendMessage(r.process, id, tags, "", r.signer)
+	return err
+}
+
+// truncate cuts s to at most n bytes without splitting a character
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// ReportPolicy controls how a report the process could not be sent is retried
+type ReportPolicy struct {
+	Attempts   int // attempts before a report is given up
+	Backoff    int // seconds before the first retry, doubled on every retry
+	MaxBackoff int // seconds
+}
+
+var DefaultReportPolicy = ReportPolicy{Attempts: 10, Backoff: 60, MaxBackoff: 3600}
+
+// backoff is the wait before the next attempt of a report that failed attempts times
+func (p ReportPolicy) backoff(attempts int) time.Duration {
+	d := time.Duration(p.Backoff) * time.Second << (attempts - 1)
+	if limit := time.Duration(p.MaxBackoff) * time.Second; d > limit || d <= 0 {
+		d = limit
+	}
+	return d
+}
+
+// queueReport queues a report to the process, sent by SendReports
+func (crn *Cron) queueReport(r schema.Report) {
+	err := crn.database.CreateReports([]schema.Report{r})
+	if err != nil {
+		crn.logger.Error("fail: database - create reports", "err", err)
+	}
+}
+
+// SendReports sends the reports due to the AO process. A report that fails is retried with
+// backoff and given up after the attempts of the policy.
+func (crn *Cron) SendReports() {
+	j := crn.jobs[JobSendReports]
+	if crn.reporter == nil {
+		return
+	}
+	reports, err := crn.database.GetDueReports(time.Now(), j.config.BatchSize)
+	if err != nil {
+		crn.logger.Error("fail: database - get due reports", "err", err)
+		return
+	}
+	processed := 0
+	for _, r := range reports {
+		err = crn.sendReport(&r)
+		u := &schema.Report{Status: schema.Sent, Attempts: r.Attempts + 1}
+		if err != nil {
+			crn.logger.Error("fail: contract - report "+string(r.Kind), "order", r.OrderId, "err", err)
+			u.Status = schema.Created
+			u.Error = err.Error()
+			u.NextAttempt = time.Now().Add(crn.reports.backoff(u.Attempts))
+			if u.Attempts >= crn.reports.Attempts {
+				u.Status = schema.Failed
+			}
+		} else {
+			processed++
+		}
+		err = crn.database.UpdateReport(r.Id, u)
+		if err != nil {
+			crn.logger.Error("fail: database - update report", "err", err)
+		}
+	}
+	j.report(processed, int64(len(reports)-processed))
+}
+
+func (crn *Cron) sendReport(r *schema.Report) error {
+	switch r.Kind {
+	case schema.Delivered:
+		return crn.reporter.Delivered(r.OrderId)
+	case schema.PayoutSent:
+		return crn.reporter.Paid(r.OrderId, r.PaymentId)
+	default:
+		return crn.reporter.Failed(r.OrderId, r.Staker, r.Reason)
+	}
+}
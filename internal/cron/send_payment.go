@@ -1,47 +1,166 @@
 package cron
 
-import "github.com/liteseed/transit/internal/database/schema"
+import (
+	"math/big"
+
+	"github.com/liteseed/transit/internal/bundler"
+	"github.com/liteseed/transit/internal/database/schema"
+	"github.com/liteseed/transit/internal/ledger"
+	"github.com/liteseed/transit/internal/pricing"
+)
 
 func (crn *Cron) sendPayment(o *schema.Order) *schema.Order {
-	quantity, err := crn.wallet.Client.GetTransactionPrice(o.Size, "")
+	// Transit pays the network for the data items it bundles, see SendBundles
+	if o.Backend == schema.SelfBundled {
+		return nil
+	}
+	b := crn.backend(o)
+	if b == nil {
+		crn.logger.Error("fail: internal - unknown backend", "backend", o.Backend, "order", o.Id)
+		return nil
+	}
+	// Every staker holding the data item is paid the network cost, transit keeps the fee
+	q, err := crn.quote(o)
 	if err != nil {
 		crn.logger.Error("fail: gateway - get transaction price", "err", err)
 		return nil
 	}
-	tx := crn.wallet.CreateTransaction(nil, o.Address, quantity, nil)
-	_, err = crn.wallet.SignTransaction(tx)
-	if err != nil {
-		crn.logger.Error("fail: internal - sign transaction", "err", err)
+	if !b.Payout() {
+		return crn.sendUpload(o, b, q)
+	}
+	base := new(big.Int).Quo(q.Base, big.NewInt(int64(o.Copies())))
+	// Replicas are paid first, the order is paid out again if one fails and a replica
+	// paid already is not
+	if o.Copies() > 1 && !crn.sendReplicaPayments(o, base) {
 		return nil
 	}
-	err = crn.wallet.SendTransaction(tx)
+
+	// The payout is saved before it is sent, a run after a failed send or PUT only retries the
+	// PUT. A send may fail after the gateway accepted the transaction, so it is kept and
+	// Reconcile reports it if it never lands.
+	if o.PayoutId == "" {
+		tx := crn.wallet.CreateTransaction(nil, o.Address, base.String(), nil)
+		err = crn.signTransaction(tx)
+		if err != nil {
+			crn.logger.Error("fail: internal - sign transaction", "err", err)
+			return nil
+		}
+		err = crn.database.UpdateOrder(o.Id, &schema.Order{PayoutId: tx.ID})
+		if err != nil {
+			crn.logger.Error("fail: database - update order", "err", err)
+			return nil
+		}
+		o.PayoutId = tx.ID
+		err = crn.wallet.SendTransaction(tx)
+		if err != nil {
+			crn.logger.Error("fail: gateway - send winston to address", "order", o.Id, "transaction", tx.ID, "err", err)
+		}
+		err = crn.database.RecordEntries(ledger.PayoutEntries(o, tx.ID, base.String(), earned(o, q).String(), tx.Reward)...)
+		if err != nil {
+			crn.logger.Error("fail: database - record entries", "err", err)
+		}
+		crn.queueReport(schema.Report{OrderId: o.Id, Kind: schema.PayoutSent, Staker: o.Address, PaymentId: tx.ID})
+	}
+	_, err = b.DataItemPut(o.URL, o.Id, o.TransactionId)
 	if err != nil {
-		crn.logger.Error("fail: gateway - send winston to address", "err", err)
+		crn.logger.Error("fail: bundler - PUT "+o.URL+"/tx/"+o.Id+"/"+o.PayoutId, "err", err)
 		return nil
 	}
-	_, err = crn.bundler.DataItemPut(o.URL, o.Id, o.TransactionId)
-	if err != nil {
+	return &schema.Order{Status: schema.Sent}
+}
 
-		crn.logger.Error("fail: bundler - PUT "+o.URL+"/tx/"+o.Id+"/"+tx.ID, "err", err)
+// sendUpload settles an order held by an upload service, which is paid for out of band:
+// the network cost is recorded as spent on the service and nothing is sent from the wallet
+func (crn *Cron) sendUpload(o *schema.Order, b bundler.Bundler, q *pricing.Quote) *schema.Order {
+	_, err := b.DataItemPut(o.URL, o.Id, o.TransactionId)
+	if err != nil {
+		crn.logger.Error("fail: bundler - PUT "+o.Backend+"/tx/"+o.Id, "err", err)
 		return nil
 	}
+	err = crn.database.RecordEntries(ledger.UploadEntries(o, q.Base.String(), earned(o, q).String())...)
+	if err != nil {
+		crn.logger.Error("fail: database - record entries", "err", err)
+	}
 	return &schema.Order{Status: schema.Sent}
 }
 
-func (crn *Cron) SendPayments() {
-	orders, err := crn.database.GetOrders(&schema.Order{Status: schema.Queued, Payment: schema.Paid})
+// sendReplicaPayments pays the stakers holding a replica of the data item of an order and
+// tells them about the payment. It reports whether every replica is paid.
+func (crn *Cron) sendReplicaPayments(o *schema.Order, base *big.Int) bool {
+	replicas, err := crn.database.GetReplicas(o.Id)
 	if err != nil {
-		crn.logger.Error("fail: database - get orders", "error", err)
-		return
+		crn.logger.Error("fail: database - get replicas", "err", err)
+		return false
 	}
+	for i := range replicas {
+		r := &replicas[i]
+		if r.Status == schema.Sent {
+			continue
+		}
+		// Saved before it is sent like the payout of the order, see sendPayment
+		if r.TransactionId == "" {
+			tx := crn.wallet.CreateTransaction(nil, r.Staker, base.String(), nil)
+			err = crn.signTransaction(tx)
+			if err != nil {
+				crn.logger.Error("fail: internal - sign transaction", "err", err)
+				return false
+			}
+			err = crn.database.UpdateReplica(r.Id, &schema.Replica{TransactionId: tx.ID})
+			if err != nil {
+				crn.logger.Error("fail: database - update replica", "err", err)
+				return false
+			}
+			r.TransactionId = tx.ID
+			err = crn.wallet.SendTransaction(tx)
+			if err != nil {
+				crn.logger.Error("fail: gateway - send winston to address", "order", o.Id, "transaction", tx.ID, "err", err)
+			}
+			err = crn.database.RecordEntries(ledger.ReplicaPayoutEntries(r, base.String(), tx.Reward)...)
+			if err != nil {
+				crn.logger.Error("fail: database - record entries", "err", err)
+			}
+			crn.queueReport(schema.Report{OrderId: o.Id, Kind: schema.PayoutSent, Staker: r.Staker, PaymentId: tx.ID})
+		}
+		_, err = crn.bundler.DataItemPut(r.URL, o.Id, o.TransactionId)
+		if err != nil {
+			crn.logger.Error("fail: bundler - PUT "+r.URL+"/tx/"+o.Id+"/"+r.TransactionId, "err", err)
+			return false
+		}
+		err = crn.database.UpdateReplica(r.Id, &schema.Replica{Status: schema.Sent})
+		if err != nil {
+			crn.logger.Error("fail: database - update replica", "err", err)
+			return false
+		}
+	}
+	return true
+}
+
+// earned is the fee kept from what the payer paid for the order, which may differ from the
+// fee quoted now if the network price moved since the payment was checked
+func earned(o *schema.Order, q *pricing.Quote) *big.Int {
+	received, ok := new(big.Int).SetString(o.Received, 10)
+	if !ok {
+		return q.Fee
+	}
+	fee := new(big.Int).Sub(received, q.Base)
+	if fee.Sign() < 0 {
+		return big.NewInt(0)
+	}
+	return fee
+}
 
-	for _, order := range *orders {
-		u := crn.sendPayment(&order)
+func (crn *Cron) SendPayments() {
+	if crn.payoutsPaused() {
+		crn.logger.Warn("cron: " + JobSendPayments + " paused - insufficient wallet balance")
+		return
+	}
+	crn.drain(JobSendPayments, &schema.Order{Status: schema.Queued, Payment: schema.Paid}, func(order *schema.Order) {
+		u := crn.sendPayment(order)
 		if u != nil {
-			err = crn.database.UpdateOrder(order.Id, u)
+			err := crn.database.UpdateOrder(order.Id, u)
 			if err != nil {
 				crn.logger.Error("fail: database - update order", "err", err)
 			}
 		}
-	}
+	})
 }
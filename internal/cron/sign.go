@@ -0,0 +1,36 @@
+package cron
+
+import (
+	"encoding/base64"
+	"slices"
+
+	"github.com/liteseed/goar/transaction"
+	"github.com/liteseed/transit/internal/signer"
+)
+
+// signTransaction sets the anchor and reward of a transaction and signs it with the payout key.
+// The reward is read from the price cache, as quoted to the user.
+func (crn *Cron) signTransaction(tx *transaction.Transaction) error {
+	anchor, err := crn.wallet.Client.GetTransactionAnchor()
+	if err != nil {
+		return err
+	}
+	tx.LastTx = anchor
+
+	reward, err := crn.prices.Price(base64.RawURLEncoding.DecodedLen(len(tx.Data)))
+	if err != nil {
+		return err
+	}
+	tx.Reward = reward
+	return signer.SignTransaction(crn.signer, tx)
+}
+
+// addresses returns the addresses receiving payments, the payout key first
+func (crn *Cron) addresses() []string {
+	return append([]string{crn.signer.Address()}, crn.retired...)
+}
+
+// receives reports whether a transfer to address is a payment to transit
+func (crn *Cron) receives(address string) bool {
+	return slices.Contains(crn.addresses(), address)
+}
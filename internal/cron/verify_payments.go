@@ -0,0 +1,34 @@
+package cron
+
+import (
+	"github.com/liteseed/transit/internal/database/schema"
+)
+
+// VerifyPayments re-checks confirmed payments against the current chain. A payment whose
+// block was orphaned goes back to unpaid to be confirmed again, a payment deep enough in
+// the chain is final and marked paid, which releases its payout.
+func (crn *Cron) VerifyPayments() {
+	crn.drain(JobVerifyPayments, &schema.Order{Payment: schema.Confirmed}, func(order *schema.Order) {
+		status, err := crn.transactionStatus(order.TransactionId)
+		if err != nil {
+			crn.logger.Error("fail: gateway - get transaction status", "err", err)
+			return
+		}
+
+		if status == nil || status.BlockIndepHash != order.BlockIndepHash {
+			crn.logger.Warn("cron: payment block orphaned", "id", order.Id, "transaction", order.TransactionId, "block", order.BlockIndepHash, "height", order.BlockHeight)
+			err = crn.database.UpdateOrder(order.Id, &schema.Order{Payment: schema.Unpaid})
+			if err != nil {
+				crn.logger.Error("fail: database - update order", "err", err)
+			}
+			return
+		}
+
+		if status.NumberOfConfirmations >= crn.finality {
+			err = crn.database.UpdateOrder(order.Id, &schema.Order{Payment: schema.Paid})
+			if err != nil {
+				crn.logger.Error("fail: database - update order", "err", err)
+			}
+		}
+	})
+}
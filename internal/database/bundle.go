@@ -0,0 +1,113 @@
+package database
+
+import (
+	"github.com/liteseed/transit/internal/database/schema"
+	"gorm.io/gorm"
+)
+
+// CreateSelfBundledOrder creates an order whose data item transit bundles itself, along
+// with the stakers its upload was sent to, the reports on them and the data item
+func (c *Database) CreateSelfBundledOrder(o *schema.Order, attempts []schema.Attempt, d *schema.DataItem, reports []schema.Report) error {
+	return c.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&o).Error; err != nil {
+			return err
+		}
+		if len(attempts) > 0 {
+			if err := tx.Create(&attempts).Error; err != nil {
+				return err
+			}
+		}
+		if err := createReports(tx, reports); err != nil {
+			return err
+		}
+		return tx.Create(&d).Error
+	})
+}
+
+func (c *Database) GetDataItem(id string) (*schema.DataItem, error) {
+	d := &schema.DataItem{}
+	err := c.DB.First(&d, "id = ?", id).Error
+	return d, err
+}
+
+// DeleteDataItem deletes a data item transit will not bundle
+func (c *Database) DeleteDataItem(id string) error {
+	return c.DB.Where("id = ?", id).Delete(&schema.DataItem{}).Error
+}
+
+// GetDataItems returns the data items of the given orders
+func (c *Database) GetDataItems(ids []string) ([]schema.DataItem, error) {
+	items := []schema.DataItem{}
+	err := c.DB.Where("id IN ?", ids).Order("created_at, id").Find(&items).Error
+	return items, err
+}
+
+// Unbundled selects the orders whose data item transit holds and did not bundle yet
+func Unbundled(db *gorm.DB) *gorm.DB {
+	return db.Where("EXISTS (SELECT 1 FROM data_items WHERE data_items.id = orders.id AND data_items.bundle_id = '')")
+}
+
+// GetBundleDataItems returns the data items of a bundle in the order they were bundled
+func (c *Database) GetBundleDataItems(id string) ([]schema.DataItem, error) {
+	items := []schema.DataItem{}
+	err := c.DB.Where("bundle_id = ?", id).Order("created_at, id").Find(&items).Error
+	return items, err
+}
+
+// CreateBundle records a bundle posted with the given data items
+func (c *Database) CreateBundle(b *schema.Bundle, ids []string) error {
+	return c.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&b).Error; err != nil {
+			return err
+		}
+		return tx.Model(&schema.DataItem{}).Where("id IN ?", ids).Update("bundle_id", b.Id).Error
+	})
+}
+
+// GetBundles returns the bundles with the given status, oldest first
+func (c *Database) GetBundles(status schema.Status, limit int) ([]schema.Bundle, error) {
+	bundles := []schema.Bundle{}
+	err := c.DB.Where("status = ?", status).Order("created_at, id").Limit(limit).Find(&bundles).Error
+	return bundles, err
+}
+
+func (c *Database) UpdateBundle(id string, b *schema.Bundle) error {
+	return c.DB.Model(&schema.Bundle{}).Where("id = ?", id).Updates(b).Error
+}
+
+// GetBundleOrders returns the orders whose data item is in a bundle
+func (c *Database) GetBundleOrders(id string) ([]schema.Order, error) {
+	orders := []schema.Order{}
+	err := c.DB.Where("id IN (?)", c.DB.Model(&schema.DataItem{}).Select("id").Where("bundle_id = ?", id)).Find(&orders).Error
+	return orders, err
+}
+
+// ConfirmBundle marks a final bundle confirmed and the orders of its data items sent. The
+// data items are on Arweave from then on and transit no longer keeps them.
+func (c *Database) ConfirmBundle(id string, height uint) error {
+	return c.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&schema.Bundle{}).Where("id = ?", id).Updates(&schema.Bundle{Status: schema.Confirmed, BlockHeight: height}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Model(&schema.Order{}).
+			Where("id IN (?)", tx.Model(&schema.DataItem{}).Select("id").Where("bundle_id = ?", id)).
+			Updates(&schema.Order{Status: schema.Sent}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&schema.DataItem{}).Where("bundle_id = ?", id).Update("raw", nil).Error
+	})
+}
+
+// DropBundle marks a bundle that never made it on chain failed and takes its data items
+// out of it, so they are bundled again
+func (c *Database) DropBundle(id string) error {
+	return c.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&schema.Bundle{}).Where("id = ?", id).Updates(&schema.Bundle{Status: schema.Failed}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&schema.DataItem{}).Where("bundle_id = ?", id).Update("bundle_id", "").Error
+	})
+}
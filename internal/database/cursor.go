@@ -0,0 +1,27 @@
+package database
+
+import (
+	"errors"
+
+	"github.com/liteseed/transit/internal/database/schema"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GetCursor returns the height the job of the given name got to, 0 if it never ran
+func (c *Database) GetCursor(name string) (int64, error) {
+	cursor := &schema.Cursor{}
+	err := c.DB.First(&cursor, "name = ?", name).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	return cursor.Height, err
+}
+
+// SaveCursor records the height the job of the given name got to
+func (c *Database) SaveCursor(name string, height int64) error {
+	return c.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}},
+		DoUpdates: clause.AssignmentColumns([]string{"height", "updated_at"}),
+	}).Create(&schema.Cursor{Name: name, Height: height}).Error
+}
@@ -68,20 +68,56 @@ func FromDialector(d gorm.Dialector) (*Database, error) {
 }
 
 func (c *Database) Migrate() error {
-	err := c.DB.AutoMigrate(&schema.Order{})
+	err := c.DB.AutoMigrate(&schema.Order{}, &schema.Transfer{}, &schema.Allocation{}, &schema.Refund{}, &schema.Entry{}, &schema.Discrepancy{}, &schema.Attempt{}, &schema.Replica{}, &schema.DataItem{}, &schema.Bundle{}, &schema.Report{}, &schema.Cursor{})
 	return err
 }
 
-func (c *Database) CreateOrder(o *schema.Order) error {
-	return c.DB.Create(&o).Error
+// CreateOrder creates the order along with the stakers its upload was sent to, the
+// replicas of its data item and the reports on its upload
+func (c *Database) CreateOrder(o *schema.Order, attempts []schema.Attempt, replicas []schema.Replica, reports []schema.Report) error {
+	if len(attempts) == 0 && len(replicas) == 0 && len(reports) == 0 {
+		return c.DB.Create(&o).Error
+	}
+	return c.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&o).Error; err != nil {
+			return err
+		}
+		if len(attempts) > 0 {
+			if err := tx.Create(&attempts).Error; err != nil {
+				return err
+			}
+		}
+		if len(replicas) > 0 {
+			if err := tx.Create(&replicas).Error; err != nil {
+				return err
+			}
+		}
+		return createReports(tx, reports)
+	})
 }
 
-func (c *Database) GetOrders(o *schema.Order, scopes ...Scope) (*[]schema.Order, error) {
+func (c *Database) GetOrders(o *schema.Order, limit int, scopes ...Scope) (*[]schema.Order, error) {
 	orders := &[]schema.Order{}
-	err := c.DB.Scopes(scopes...).Where(o).Limit(25).Find(&orders).Error
+	err := c.DB.Scopes(scopes...).Where(o).Limit(limit).Find(&orders).Error
 	return orders, err
 }
 
+func (c *Database) CountOrders(o *schema.Order, scopes ...Scope) (int64, error) {
+	var count int64
+	err := c.DB.Model(&schema.Order{}).Scopes(scopes...).Where(o).Count(&count).Error
+	return count, err
+}
+
+// SumOrderSizes returns the number of orders matching o and their total size in bytes
+func (c *Database) SumOrderSizes(o *schema.Order) (int64, int64, error) {
+	var res struct {
+		Count int64
+		Size  int64
+	}
+	err := c.DB.Model(&schema.Order{}).Select("count(*) AS count, coalesce(sum(size), 0) AS size").Where(o).Scan(&res).Error
+	return res.Count, res.Size, err
+}
+
 func (c *Database) GetOrder(id string) (*schema.Order, error) {
 	order := &schema.Order{}
 	err := c.DB.First(&order, "id = ?", id).Error
@@ -105,3 +141,23 @@ func (c *Database) Shutdown() error {
 }
 
 type Scope = func(*gorm.DB) *gorm.DB
+
+// OldestFirst orders by creation time, using the id to break ties
+func OldestFirst(db *gorm.DB) *gorm.DB {
+	return db.Order("created_at, id")
+}
+
+// Expirable selects the orders still waiting for a payment, unpaid or partially paid
+func Expirable(db *gorm.DB) *gorm.DB {
+	return db.Where("(status = ? AND payment = ?) OR (status IN ? AND payment = ?)", schema.Created, schema.Unpaid, []string{schema.Created, schema.Queued}, schema.Partial)
+}
+
+// After selects the orders that come after o in OldestFirst order, so a backlog can be walked page by page
+func After(o *schema.Order) Scope {
+	return func(db *gorm.DB) *gorm.DB {
+		if o == nil {
+			return db
+		}
+		return db.Where("(created_at, id) > (?, ?)", o.CreatedAt, o.Id)
+	}
+}
@@ -0,0 +1,21 @@
+package database
+
+import (
+	"time"
+
+	"github.com/liteseed/transit/internal/database/schema"
+	"gorm.io/gorm/clause"
+)
+
+// RecordEntries writes entries to the ledger. An entry of the same kind for the same order
+// and transaction is only written once.
+func (c *Database) RecordEntries(entries ...*schema.Entry) error {
+	return c.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(entries).Error
+}
+
+// GetEntries returns the ledger entries written in [from, to), oldest first
+func (c *Database) GetEntries(from time.Time, to time.Time) (*[]schema.Entry, error) {
+	entries := &[]schema.Entry{}
+	err := c.DB.Where("created_at >= ? AND created_at < ?", from, to).Order("created_at, id").Find(entries).Error
+	return entries, err
+}
@@ -0,0 +1,59 @@
+package database
+
+import (
+	"time"
+
+	"github.com/liteseed/transit/internal/database/schema"
+	"github.com/liteseed/transit/internal/ledger"
+	"gorm.io/gorm"
+)
+
+// GetTransfers returns the transfers with the given ids
+func (c *Database) GetTransfers(ids []string) ([]schema.Transfer, error) {
+	transfers := []schema.Transfer{}
+	err := c.DB.Where("id IN ?", ids).Find(&transfers).Error
+	return transfers, err
+}
+
+// GetOrdersByTransaction returns the orders paid by the given transactions
+func (c *Database) GetOrdersByTransaction(ids []string) ([]schema.Order, error) {
+	orders := []schema.Order{}
+	err := c.DB.Where("transaction_id IN ?", ids).Find(&orders).Error
+	return orders, err
+}
+
+// GetEntriesByTransaction returns the ledger entries of the given transactions
+func (c *Database) GetEntriesByTransaction(ids []string) ([]schema.Entry, error) {
+	entries := []schema.Entry{}
+	err := c.DB.Where("transaction_id IN ?", ids).Find(&entries).Error
+	return entries, err
+}
+
+// GetSentOrdersWithoutPayout returns the orders created in [from, to) that are sent with
+// no payout, upload or bundle in the ledger
+func (c *Database) GetSentOrdersWithoutPayout(from time.Time, to time.Time) ([]schema.Order, error) {
+	orders := []schema.Order{}
+	err := c.DB.
+		Where("status = ? AND created_at >= ? AND created_at < ?", schema.Sent, from, to).
+		Where("NOT EXISTS (SELECT 1 FROM entries WHERE entries.order_id = orders.id AND entries.kind IN ?)", []schema.EntryKind{ledger.Payout, ledger.Upload, ledger.Bundled}).
+		Find(&orders).Error
+	return orders, err
+}
+
+// ReplaceDiscrepancies replaces the discrepancies found by the previous reconciliation
+func (c *Database) ReplaceDiscrepancies(d []schema.Discrepancy) error {
+	return c.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("1 = 1").Delete(&schema.Discrepancy{}).Error
+		if err != nil || len(d) == 0 {
+			return err
+		}
+		return tx.Create(&d).Error
+	})
+}
+
+// GetDiscrepancies returns the discrepancies matching d, in the order they were found
+func (c *Database) GetDiscrepancies(d *schema.Discrepancy, limit int) ([]schema.Discrepancy, error) {
+	discrepancies := []schema.Discrepancy{}
+	err := c.DB.Where(d).Order("id").Limit(limit).Find(&discrepancies).Error
+	return discrepancies, err
+}
@@ -0,0 +1,92 @@
+package database
+
+import (
+	"errors"
+	"time"
+
+	"github.com/liteseed/transit/internal/database/schema"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrRefundStatus = errors.New("refund can not change to this status")
+
+// RefundableAllocation is an allocation of a failed or expired order with the payer to refund
+type RefundableAllocation struct {
+	OrderId    string
+	TransferId string
+	Amount     string
+	Payer      string
+}
+
+// GetRefundableAllocations returns the allocations of failed or expired orders that are not refunded yet
+func (c *Database) GetRefundableAllocations(limit int) ([]RefundableAllocation, error) {
+	allocations := []RefundableAllocation{}
+	err := c.DB.Table("allocations").
+		Select("allocations.order_id, allocations.transfer_id, allocations.amount, transfers.payer").
+		Joins("JOIN orders ON orders.id = allocations.order_id").
+		Joins("JOIN transfers ON transfers.id = allocations.transfer_id").
+		Where("orders.status IN ?", []string{schema.Failed, schema.Expired}).
+		Where("NOT EXISTS (SELECT 1 FROM refunds WHERE refunds.order_id = allocations.order_id AND refunds.transfer_id = allocations.transfer_id)").
+		Limit(limit).
+		Scan(&allocations).Error
+	return allocations, err
+}
+
+// GetSettleableTransfers returns the transfers received before the given time that are not
+// settled and whose orders are all sent, failed or expired
+func (c *Database) GetSettleableTransfers(before time.Time, limit int) ([]schema.Transfer, error) {
+	transfers := []schema.Transfer{}
+	err := c.DB.
+		Where("settled = ? AND created_at < ?", false, before).
+		Where("NOT EXISTS (SELECT 1 FROM orders WHERE orders.transaction_id = transfers.id AND orders.status NOT IN ?)", []string{schema.Sent, schema.Failed, schema.Expired}).
+		Limit(limit).
+		Find(&transfers).Error
+	return transfers, err
+}
+
+// SettleTransfer marks a transfer settled so it pays for no more orders and queues the
+// refund of what is left of it, if any
+func (c *Database) SettleTransfer(id string, r *schema.Refund) error {
+	return c.DB.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&schema.Transfer{}).Where("id = ? AND settled = ?", id, false).Update("settled", true)
+		if res.Error != nil || res.RowsAffected == 0 || r == nil {
+			return res.Error
+		}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(r).Error
+	})
+}
+
+// CreateRefund queues a refund. A refund for the same order, transfer and reason is only queued once.
+func (c *Database) CreateRefund(r *schema.Refund) error {
+	return c.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(r).Error
+}
+
+func (c *Database) GetRefund(id uint) (*schema.Refund, error) {
+	r := &schema.Refund{}
+	err := c.DB.First(&r, "id = ?", id).Error
+	return r, err
+}
+
+func (c *Database) GetRefunds(r *schema.Refund, limit int) (*[]schema.Refund, error) {
+	refunds := &[]schema.Refund{}
+	err := c.DB.Where(r).Order("id").Limit(limit).Find(&refunds).Error
+	return refunds, err
+}
+
+func (c *Database) UpdateRefund(id uint, r *schema.Refund) error {
+	return c.DB.Model(&schema.Refund{}).Where("id = ?", id).Updates(&r).Error
+}
+
+// TransitionRefund updates a refund, usually its status, if it is in one of the from statuses.
+// It fails with ErrRefundStatus otherwise.
+func (c *Database) TransitionRefund(id uint, r *schema.Refund, from ...schema.RefundStatus) error {
+	res := c.DB.Model(&schema.Refund{}).Where("id = ? AND status IN ?", id, from).Updates(&r)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrRefundStatus
+	}
+	return nil
+}
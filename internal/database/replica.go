@@ -0,0 +1,14 @@
+package database
+
+import "github.com/liteseed/transit/internal/database/schema"
+
+// GetReplicas returns the replicas of the data item of an order
+func (c *Database) GetReplicas(orderId string) ([]schema.Replica, error) {
+	replicas := []schema.Replica{}
+	err := c.DB.Where("order_id = ?", orderId).Order("id").Find(&replicas).Error
+	return replicas, err
+}
+
+func (c *Database) UpdateReplica(id uint, r *schema.Replica) error {
+	return c.DB.Model(&schema.Replica{}).Where("id = ?", id).Updates(&r).Error
+}
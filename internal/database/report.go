@@ -0,0 +1,33 @@
+package database
+
+import (
+	"time"
+
+	"github.com/liteseed/transit/internal/database/schema"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// CreateReports queues reports to the AO process. A report of the same kind about the same
+// order and staker is only queued once.
+func (c *Database) CreateReports(reports []schema.Report) error {
+	return createReports(c.DB, reports)
+}
+
+func createReports(tx *gorm.DB, reports []schema.Report) error {
+	if len(reports) == 0 {
+		return nil
+	}
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&reports).Error
+}
+
+// GetDueReports returns the reports not sent yet whose next attempt is due at now, oldest first
+func (c *Database) GetDueReports(now time.Time, limit int) ([]schema.Report, error) {
+	reports := []schema.Report{}
+	err := c.DB.Where("status = ? AND next_attempt <= ?", schema.Created, now).Order("id").Limit(limit).Find(&reports).Error
+	return reports, err
+}
+
+func (c *Database) UpdateReport(id uint, r *schema.Report) error {
+	return c.DB.Model(&schema.Report{}).Where("id = ?", id).Updates(&r).Error
+}
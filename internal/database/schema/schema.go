@@ -1,9 +1,17 @@
 package schema
 
-import "database/sql/driver"
+import (
+	"database/sql/driver"
+	"time"
+)
 
 type Payment string
 type Status string
+type RefundStatus string
+type RefundReason string
+type EntryKind string
+type DiscrepancyKind string
+type ReportKind string
 
 const (
 	// Order
@@ -11,13 +19,52 @@ const (
 	Queued  = "queued"  // Order Transaction Id added
 	Sent    = "sent"    // Order Sent
 
-	Failed = "failed" // Order Failed
+	Failed  = "failed"  // Order Failed
+	Expired = "expired" // Order not paid before the payment deadline
 
 	// Payment
 	Unpaid    = "unpaid"
-	Confirmed = "confirmed" // Order Transaction has > 10 confirmation
+	Partial   = "partial"   // Order Transaction does not cover the price, waiting for a top-up
+	Confirmed = "confirmed" // Order Transaction has enough confirmations, waiting to be final
 	Paid      = "paid"      // Ready to Send
-	Invalid   = "invalid"   // Not enough AR
+	Invalid   = "invalid"   // Order Transaction can not pay for the order
+
+	// Bundle
+	Posting = "posting" // Bundle header accepted, data chunks still uploading
+)
+
+const (
+	// Refund
+	RefundPending   = "pending"   // Waiting for approval
+	RefundHeld      = "held"      // Held back by an admin
+	RefundApproved  = "approved"  // Ready to Send
+	RefundDenied    = "denied"    // Refused by an admin
+	RefundSent      = "sent"      // Refund Transaction sent
+	RefundConfirmed = "confirmed" // Refund Transaction has enough confirmations
+
+	// Reason
+	RefundFailed   = "failed"   // Order failed or expired after it was paid
+	RefundInvalid  = "invalid"  // Transfer could not pay for the order
+	RefundOverpaid = "overpaid" // Transfer left over once its orders are done
+)
+
+// SelfBundled is the backend of the orders whose data item transit bundles itself, as no
+// staker took it
+const SelfBundled = "self"
+
+const (
+	// Discrepancy
+	MissingPayout   = "missing-payout"   // Order sent or payout recorded with no transfer on chain
+	UnknownInbound  = "unknown-inbound"  // Transfer to the transit wallet matching no payment
+	UnknownOutbound = "unknown-outbound" // Transfer from the transit wallet matching no payout or refund
+	AmountMismatch  = "amount-mismatch"  // Transfer on chain for another amount than recorded
+)
+
+const (
+	// Report
+	Delivered   = "delivered"   // Staker accepted the data item
+	PayoutSent  = "payout-sent" // Staker was paid for the data item
+	Undelivered = "undelivered" // Staker failed or rejected the data item
 )
 
 func (s *Status) Scan(value any) error {
@@ -39,11 +86,179 @@ func (s Payment) Value() (driver.Value, error) {
 }
 
 type Order struct {
-	Id            string  `json:"id"`
-	TransactionId string  `json:"transaction_id"`
-	URL           string  `json:"url"`
-	Address       string  `json:"address"`
-	Status        Status  `gorm:"index:idx_status;default:created" sql:"type:status" json:"status"`
-	Payment       Payment `gorm:"index:idx_payment;default:unpaid" sql:"type:status" json:"payment"`
-	Size          int     `json:"size"`
+	Id             string    `json:"id"`
+	TransactionId  string    `json:"transaction_id"`
+	URL            string    `json:"url"`
+	Address        string    `json:"address"`
+	Status         Status    `gorm:"index:idx_status;default:created" sql:"type:status" json:"status"`
+	Payment        Payment   `gorm:"index:idx_payment;default:unpaid" sql:"type:status" json:"payment"`
+	Size           int       `json:"size"`
+	ApiKey         string    `json:"-"`
+	Owner          string    `json:"owner"`    // Address of the data item owner
+	Price          string    `json:"price"`    // Winston quoted when the first payment was checked
+	Received       string    `json:"received"` // Winston allocated from payments so far
+	DeadlineHeight uint      `json:"deadline_height"`
+	BlockHeight    uint      `json:"block_height"`     // Block of the confirmed payment
+	BlockIndepHash string    `json:"block_indep_hash"` // Block of the confirmed payment
+	Replicas       int       `json:"replicas"`         // Stakers holding the data item, the assigned one included
+	Backend        string    `json:"backend"`          // Upload service holding the data item, empty for the stakers of the contract, self when transit bundles it
+	Discovered     bool      `json:"discovered"`       // Payment found by DiscoverPayments rather than sent by the user
+	PayoutId       string    `json:"payout_id"`        // Transaction paying the staker, set once it is sent
+	CreatedAt      time.Time `gorm:"index:idx_created_at;default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+// Copies is the number of stakers paid to hold the data item. Orders created before
+// replication have no replicas recorded and are held by their staker alone.
+func (o *Order) Copies() int {
+	if o.Replicas < 1 {
+		return 1
+	}
+	return o.Replicas
+}
+
+// PastDeadline reports whether the payment deadline of the order has passed, either
+// the wall-clock deadline counted from its creation or the block height deadline set
+// by the bundler. A zero deadline or height disables the respective check.
+func (o *Order) PastDeadline(deadline time.Duration, height int64) bool {
+	if deadline > 0 && time.Since(o.CreatedAt) > deadline {
+		return true
+	}
+	return o.DeadlineHeight > 0 && height > int64(o.DeadlineHeight)
+}
+
+// Transfer is a payment transaction to the transit wallet. Its quantity in winston is
+// shared by the orders it pays for and can not be allocated twice. A settled transfer had what was left of it refunded and pays for no more orders.
+type Transfer struct {
+	Id        string    `json:"id"`
+	Payer     string    `json:"payer"`
+	Quantity  string    `json:"quantity"`
+	Allocated string    `json:"allocated"`
+	Settled   bool      `gorm:"index:idx_settled" json:"settled"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Allocation is the part of a transfer paying for an order. Several transfers can pay for an order.
+type Allocation struct {
+	OrderId    string    `gorm:"primaryKey" json:"order_id"`
+	TransferId string    `gorm:"primaryKey;index:idx_transfer_id" json:"transfer_id"`
+	Amount     string    `json:"amount"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Refund is a transfer from the transit wallet back to a payer. Its amount is what the
+// payer is owed less the network fee.
+type Refund struct {
+	Id            uint         `json:"id"`
+	OrderId       string       `gorm:"uniqueIndex:idx_refund" json:"order_id"`
+	TransferId    string       `gorm:"uniqueIndex:idx_refund" json:"transfer_id"`
+	Reason        RefundReason `gorm:"uniqueIndex:idx_refund" json:"reason"`
+	Address       string       `json:"address"`
+	Owed          string       `json:"owed"`
+	Fee           string       `json:"fee"`
+	Amount        string       `json:"amount"`
+	Status        RefundStatus `gorm:"index:idx_refund_status;default:pending" json:"status"`
+	TransactionId string       `json:"transaction_id"`
+	Confirmations int          `json:"confirmations"`
+	CreatedAt     time.Time    `json:"created_at"`
+	UpdatedAt     time.Time    `json:"updated_at"`
+}
+
+// Entry moves Amount winston from the Credit account to the Debit account of the ledger,
+// so every entry is balanced. It links to the order and the transaction it records.
+type Entry struct {
+	Id            uint      `json:"id"`
+	Kind          EntryKind `gorm:"uniqueIndex:idx_entry" json:"kind"`
+	Debit         string    `json:"debit"`
+	Credit        string    `json:"credit"`
+	Amount        string    `json:"amount"`
+	Counterparty  string    `gorm:"index:idx_counterparty" json:"counterparty"`
+	OrderId       string    `gorm:"uniqueIndex:idx_entry" json:"order_id"`
+	TransactionId string    `gorm:"uniqueIndex:idx_entry" json:"transaction_id"`
+	CreatedAt     time.Time `gorm:"index:idx_entry_created_at" json:"created_at"`
+}
+
+// Discrepancy is a difference between the transfers of the transit wallet on chain and what
+// transit recorded. Expected is the amount recorded, Actual the amount on chain, in winston.
+type Discrepancy struct {
+	Id            uint            `json:"id"`
+	Kind          DiscrepancyKind `gorm:"index:idx_discrepancy_kind" json:"kind"`
+	OrderId       string          `json:"order_id"`
+	TransactionId string          `json:"transaction_id"`
+	Expected      string          `json:"expected"`
+	Actual        string          `json:"actual"`
+	CreatedAt     time.Time       `json:"created_at"`
+}
+
+// Attempt is a staker an upload was sent to. Every staker tried for an order is recorded,
+// the one that accepted the data item with no error.
+type Attempt struct {
+	Id        uint      `json:"id"`
+	OrderId   string    `gorm:"index:idx_attempt_order_id" json:"order_id"`
+	Staker    string    `json:"staker"`
+	URL       string    `json:"url"`
+	Error     string    `json:"error"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Replica is a copy of the data item of an order held by another staker than the one
+// assigned to the order. Each replica is paid out on its own; TransactionId is its payout
+// and Status turns sent once the staker was told about the payment.
+type Replica struct {
+	Id            uint      `json:"id"`
+	OrderId       string    `gorm:"index:idx_replica_order_id" json:"order_id"`
+	Staker        string    `json:"staker"`
+	URL           string    `json:"url"`
+	TransactionId string    `json:"transaction_id"`
+	Status        Status    `gorm:"default:created" json:"status"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// DataItem is a data item transit bundles itself. It is kept to serve the data item, and
+// BundleId is the L1 transaction of its bundle, empty until it is bundled.
+type DataItem struct {
+	Id        string    `json:"id"`
+	Raw       []byte    `json:"-"`
+	BundleId  string    `gorm:"index:idx_data_item_bundle_id" json:"bundle_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Bundle is an ANS-104 bundle of data items posted by transit as an L1 transaction, whose
+// Id it is. Status is posting until all its chunks are uploaded, turns confirmed once the
+// transaction is final, failed if it is dropped.
+type Bundle struct {
+	Id           string    `json:"id"`
+	Status       Status    `gorm:"index:idx_bundle_status;default:sent" json:"status"`
+	Items        int       `json:"items"`
+	Size         int       `json:"size"`
+	Reward       string    `json:"reward"`
+	Chunks       int       `json:"chunks"`        // Chunks uploaded when the last upload stopped, while posting
+	AnchorHeight int64     `json:"anchor_height"` // Block height once the bundle was signed, its anchor is no newer
+	BlockHeight  uint      `json:"block_height"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Report tells the AO process how an upload to one of its stakers went: the staker took the
+// data item, was paid for it with the PaymentId transaction, or failed it for Reason. Reports
+// are queued and sent by the send-reports job. Status turns sent once the process got the
+// report, failed once the job gives up on it after Attempts.
+type Report struct {
+	Id          uint       `json:"id"`
+	OrderId     string     `gorm:"uniqueIndex:idx_report" json:"order_id"`
+	Kind        ReportKind `gorm:"uniqueIndex:idx_report" json:"kind"`
+	Staker      string     `gorm:"uniqueIndex:idx_report" json:"staker"`
+	PaymentId   string     `json:"payment_id"`
+	Reason      string     `json:"reason"`
+	Status      Status     `gorm:"index:idx_report_status;default:created" json:"status"`
+	Attempts    int        `json:"attempts"`
+	Error       string     `json:"error"` // of the last attempt
+	NextAttempt time.Time  `json:"next_attempt"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// Cursor is the block height a job scanning the chain got to, kept across restarts
+type Cursor struct {
+	Name      string    `gorm:"primaryKey" json:"name"`
+	Height    int64     `json:"height"`
+	UpdatedAt time.Time `json:"updated_at"`
 }
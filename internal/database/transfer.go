@@ -0,0 +1,128 @@
+package database
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/liteseed/transit/internal/database/schema"
+	"github.com/liteseed/transit/internal/ledger"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrPaymentExhausted = errors.New("transfer is fully allocated")
+	ErrPaymentConflict  = errors.New("transfer was allocated concurrently")
+)
+
+func (c *Database) GetTransfer(id string) (*schema.Transfer, error) {
+	t := &schema.Transfer{}
+	err := c.DB.First(&t, "id = ?", id).Error
+	return t, err
+}
+
+// RecordTransfer stores a transfer that paid for no order, so it can be refunded
+func (c *Database) RecordTransfer(t *schema.Transfer) error {
+	return c.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where(&schema.Transfer{Id: t.Id}).Attrs(&schema.Transfer{Payer: t.Payer, Quantity: t.Quantity, Allocated: "0"}).FirstOrCreate(&schema.Transfer{}).Error
+		if err != nil {
+			return err
+		}
+		return recordPayment(tx, t)
+	})
+}
+
+// DetachPayment takes a payment transaction back from an order it can not pay for and
+// leaves the order created with the given payment status, so another payment can be sent
+// for it. An order that was sent another payment since is left alone.
+func (c *Database) DetachPayment(orderId string, transactionId string, payment schema.Payment) error {
+	return c.DB.Model(&schema.Order{}).Where("id = ? AND transaction_id = ?", orderId, transactionId).Updates(map[string]any{"transaction_id": "", "status": schema.Created, "payment": payment, "discovered": false}).Error
+}
+
+// recordPayment writes the ledger entry of a transfer, once
+func recordPayment(tx *gorm.DB, t *schema.Transfer) error {
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(ledger.PaymentEntry(t.Payer, t.Id, t.Quantity)).Error
+}
+
+// Exhausted reports whether nothing is left of the transfer to pay for another order
+func Exhausted(t *schema.Transfer) bool {
+	quantity, _ := new(big.Int).SetString(t.Quantity, 10)
+	allocated, _ := new(big.Int).SetString(t.Allocated, 10)
+	return t.Settled || quantity == nil || allocated != nil && allocated.Cmp(quantity) >= 0
+}
+
+// AllocatePayment allocates what is left of a transfer to an order, up to what the order
+// still owes of price, and returns how much the order has received in total. The price and
+// the amount received are recorded on the order. Several transfers add up to pay for an
+// order, allocating the same transfer to an order twice is a no-op. The transfer is written
+// to the ledger the first time it is allocated. It fails with
+// ErrPaymentExhausted if nothing is left of the transfer.
+func (c *Database) AllocatePayment(transfer *schema.Transfer, orderId string, price *big.Int) (*big.Int, error) {
+	quantity, ok := new(big.Int).SetString(transfer.Quantity, 10)
+	if !ok {
+		return nil, ErrPaymentExhausted
+	}
+	received := big.NewInt(0)
+	err := c.DB.Transaction(func(tx *gorm.DB) error {
+		allocations := []schema.Allocation{}
+		err := tx.Where(&schema.Allocation{OrderId: orderId}).Find(&allocations).Error
+		if err != nil {
+			return err
+		}
+		for _, a := range allocations {
+			if amount, ok := new(big.Int).SetString(a.Amount, 10); ok {
+				received.Add(received, amount)
+			}
+			if a.TransferId == transfer.Id {
+				return nil
+			}
+		}
+		owed := new(big.Int).Sub(price, received)
+		if owed.Sign() <= 0 {
+			return nil
+		}
+
+		t := &schema.Transfer{}
+		err = tx.Where(&schema.Transfer{Id: transfer.Id}).Attrs(&schema.Transfer{Payer: transfer.Payer, Quantity: transfer.Quantity, Allocated: "0"}).FirstOrCreate(&t).Error
+		if err != nil {
+			return err
+		}
+		if t.Settled {
+			return ErrPaymentExhausted
+		}
+		allocated, ok := new(big.Int).SetString(t.Allocated, 10)
+		if !ok {
+			allocated = big.NewInt(0)
+		}
+		amount := new(big.Int).Sub(quantity, allocated)
+		if amount.Sign() <= 0 {
+			return ErrPaymentExhausted
+		}
+		if amount.Cmp(owed) > 0 {
+			amount = owed
+		}
+
+		err = recordPayment(tx, transfer)
+		if err != nil {
+			return err
+		}
+		err = tx.Create(&schema.Allocation{OrderId: orderId, TransferId: transfer.Id, Amount: amount.String()}).Error
+		if err != nil {
+			return err
+		}
+		// Compare and swap so concurrent allocations can not overspend the transfer
+		res := tx.Model(&schema.Transfer{}).Where("id = ? AND allocated = ? AND settled = ?", transfer.Id, t.Allocated, false).Update("allocated", new(big.Int).Add(allocated, amount).String())
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrPaymentConflict
+		}
+		received.Add(received, amount)
+		return tx.Model(&schema.Order{}).Where("id = ?", orderId).Updates(map[string]any{"price": price.String(), "received": received.String()}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return received, nil
+}
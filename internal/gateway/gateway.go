@@ -0,0 +1,313 @@
+// Package gateway spreads the requests of the Arweave client over several gateways
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"expvar"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/liteseed/goar/client"
+)
+
+const (
+	DefaultTimeout   = 5 * time.Second
+	DefaultBroadcast = 3
+)
+
+var metrics = expvar.NewMap("gateways")
+
+// Config is a gateway and its priority. Gateways with a lower priority are tried first.
+type Config struct {
+	URL      string
+	Priority int
+}
+
+// Status is the health of a gateway and the outcome of the requests sent to it
+type Status struct {
+	URL      string `json:"url"`
+	Priority int    `json:"priority"`
+	Healthy  bool   `json:"healthy"`
+	Latency  int64  `json:"latency"` // milliseconds, moving average
+	Requests int64  `json:"requests"`
+	Failures int64  `json:"failures"`
+}
+
+type gateway struct {
+	url      *url.URL
+	priority int
+	healthy  atomic.Bool
+	latency  atomic.Int64
+	requests atomic.Int64
+	failures atomic.Int64
+	metrics  *expvar.Map
+}
+
+// observe records the outcome of a request. A gateway is unhealthy from its last failure
+// until it answers again.
+func (g *gateway) observe(d time.Duration, ok bool) {
+	g.requests.Add(1)
+	if !ok {
+		g.failures.Add(1)
+	}
+	g.healthy.Store(ok)
+	ms := d.Milliseconds()
+	if previous := g.latency.Load(); previous > 0 {
+		ms = (4*previous + ms) / 5
+	}
+	g.latency.Store(ms)
+
+	g.metrics.Add("requests", 1)
+	if !ok {
+		g.metrics.Add("failures", 1)
+	}
+	latency := new(expvar.Int)
+	latency.Set(ms)
+	g.metrics.Set("latency", latency)
+}
+
+// Pool is an http.RoundTripper sending the requests addressed to the first gateway to the
+// healthiest gateway by priority. It fails over to the next gateway when one errors, times
+// out or answers with a server error. Transactions are posted to several gateways at once so
+// they propagate faster.
+type Pool struct {
+	broadcast int
+	gateways  []*gateway
+	timeout   time.Duration
+	transport http.RoundTripper
+}
+
+type Option = func(*Pool)
+
+// WithBroadcast sets the number of gateways a transaction is posted to
+func WithBroadcast(n int) Option {
+	return func(p *Pool) {
+		if n > 0 {
+			p.broadcast = n
+		}
+	}
+}
+
+// WithTimeout sets how long a gateway may take to answer before the next one is tried
+func WithTimeout(d time.Duration) Option {
+	return func(p *Pool) {
+		if d > 0 {
+			p.timeout = d
+		}
+	}
+}
+
+func New(configs []Config, options ...Option) (*Pool, error) {
+	if len(configs) == 0 {
+		return nil, errors.New("gateway: no gateway configured")
+	}
+	p := &Pool{broadcast: DefaultBroadcast, timeout: DefaultTimeout, transport: http.DefaultTransport}
+	for _, c := range configs {
+		u, err := url.Parse(c.URL)
+		if err != nil {
+			return nil, err
+		}
+		g := &gateway{url: u, priority: c.Priority, metrics: new(expvar.Map).Init()}
+		g.healthy.Store(true)
+		metrics.Set(c.URL, g.metrics)
+		p.gateways = append(p.gateways, g)
+	}
+	slices.SortStableFunc(p.gateways, func(a, b *gateway) int { return a.priority - b.priority })
+	for _, o := range options {
+		o(p)
+	}
+	return p, nil
+}
+
+// Client returns an Arweave client sending its requests through the pool
+func (p *Pool) Client() *client.Client {
+	return &client.Client{Client: &http.Client{Transport: p}, Gateway: p.gateways[0].url.String()}
+}
+
+// Status returns the health of the gateways, by priority
+func (p *Pool) Status() []Status {
+	status := []Status{}
+	for _, g := range p.gateways {
+		status = append(status, Status{
+			URL:      g.url.String(),
+			Priority: g.priority,
+			Healthy:  g.healthy.Load(),
+			Latency:  g.latency.Load(),
+			Requests: g.requests.Load(),
+			Failures: g.failures.Load(),
+		})
+	}
+	return status
+}
+
+// Probe asks every gateway for its network info and records whether and how fast it answers
+func (p *Pool) Probe() {
+	var wg sync.WaitGroup
+	for _, g := range p.gateways {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+			defer cancel()
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, g.url.JoinPath("/info").String(), nil)
+			if err != nil {
+				return
+			}
+			start := time.Now()
+			res, err := p.transport.RoundTrip(req)
+			if err == nil {
+				_, _ = io.Copy(io.Discard, res.Body)
+				res.Body.Close()
+			}
+			g.observe(time.Since(start), err == nil && res.StatusCode == http.StatusOK)
+		}()
+	}
+	wg.Wait()
+}
+
+// candidates returns the healthy gateways by priority then latency, followed by the
+// unhealthy ones as a last resort
+func (p *Pool) candidates() []*gateway {
+	candidates := slices.Clone(p.gateways)
+	slices.SortStableFunc(candidates, func(a, b *gateway) int {
+		switch ha, hb := a.healthy.Load(), b.healthy.Load(); {
+		case ha && !hb:
+			return -1
+		case !ha && hb:
+			return 1
+		case a.priority != b.priority:
+			return a.priority - b.priority
+		default:
+			return int(a.latency.Load() - b.latency.Load())
+		}
+	})
+	return candidates
+}
+
+func (p *Pool) RoundTrip(req *http.Request) (*http.Response, error) {
+	primary := p.gateways[0].url
+	if req.URL.Host != primary.Host {
+		return p.transport.RoundTrip(req)
+	}
+	rel := strings.TrimPrefix(req.URL.Path, primary.Path)
+
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	candidates := p.candidates()
+	if req.Method == http.MethodPost && path.Base(rel) == "tx" {
+		return p.broadcastTransaction(req, rel, body, candidates[:min(p.broadcast, len(candidates))])
+	}
+
+	var res *http.Response
+	var err error
+	for i, g := range candidates {
+		res, err = p.send(req, g, rel, body)
+		if err == nil && !failed(res) {
+			return res, nil
+		}
+		if res != nil && i < len(candidates)-1 {
+			discard(res)
+		}
+	}
+	return res, err
+}
+
+// broadcastTransaction posts a transaction to several gateways at once and answers with
+// the first gateway by priority that accepted it
+func (p *Pool) broadcastTransaction(req *http.Request, rel string, body []byte, gateways []*gateway) (*http.Response, error) {
+	responses := make([]*http.Response, len(gateways))
+	errs := make([]error, len(gateways))
+	var wg sync.WaitGroup
+	for i, g := range gateways {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			responses[i], errs[i] = p.send(req, g, rel, body)
+		}()
+	}
+	wg.Wait()
+
+	best := -1
+	for i, res := range responses {
+		if res == nil {
+			continue
+		}
+		if best == -1 || res.StatusCode < http.StatusBadRequest && responses[best].StatusCode >= http.StatusBadRequest {
+			best = i
+		}
+	}
+	for i, res := range responses {
+		if res != nil && i != best {
+			discard(res)
+		}
+	}
+	if best == -1 {
+		return nil, errors.Join(errs...)
+	}
+	return responses[best], nil
+}
+
+// send sends a request to a gateway, the body of the response must be closed to release it
+func (p *Pool) send(req *http.Request, g *gateway, rel string, body []byte) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), p.timeout)
+	r := req.Clone(ctx)
+	u := *g.url
+	u.Path = path.Join("/", g.url.Path, rel)
+	u.RawPath = ""
+	u.RawQuery = req.URL.RawQuery
+	r.URL = &u
+	r.Host = r.URL.Host
+	r.Body = http.NoBody
+	r.ContentLength = int64(len(body))
+	if len(body) > 0 {
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	start := time.Now()
+	res, err := p.transport.RoundTrip(r)
+	g.observe(time.Since(start), err == nil && !failed(res))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	res.Body = &cancelBody{ReadCloser: res.Body, cancel: cancel}
+	return res, nil
+}
+
+// failed reports whether another gateway may answer better
+func failed(res *http.Response) bool {
+	return res.StatusCode >= http.StatusInternalServerError || res.StatusCode == http.StatusTooManyRequests
+}
+
+func discard(res *http.Response) {
+	_, _ = io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+}
+
+// cancelBody releases the timeout of a request once its response is read
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
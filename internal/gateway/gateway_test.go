@@ -0,0 +1,135 @@
+package gateway
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFailover(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer down.Close()
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		_, _ = w.Write([]byte("slow"))
+	}))
+	defer slow.Close()
+	var hits atomic.Int64
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		assert.Equal(t, "/arweave/price/1000", r.URL.Path)
+		_, _ = w.Write([]byte("1000"))
+	}))
+	defer up.Close()
+
+	p, err := New([]Config{{URL: up.URL + "/arweave", Priority: 2}, {URL: down.URL, Priority: 0}, {URL: slow.URL, Priority: 1}}, WithTimeout(50*time.Millisecond))
+	assert.NoError(t, err)
+	c := p.Client()
+	assert.Equal(t, down.URL, c.Gateway)
+
+	price, err := c.GetTransactionPrice(1000, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "1000", price)
+
+	status := p.Status()
+	assert.False(t, status[0].Healthy)
+	assert.False(t, status[1].Healthy)
+	assert.True(t, status[2].Healthy)
+
+	// Unhealthy gateways are tried last
+	_, err = c.GetTransactionPrice(1000, "")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), hits.Load())
+	assert.Equal(t, int64(1), p.Status()[0].Requests)
+}
+
+func TestNotFound(t *testing.T) {
+	var hits atomic.Int64
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer gateway.Close()
+
+	p, err := New([]Config{{URL: gateway.URL}, {URL: gateway.URL}})
+	assert.NoError(t, err)
+	_, err = p.Client().GetTransactionByID("missing")
+	assert.Error(t, err)
+	assert.Equal(t, int64(1), hits.Load())
+	assert.True(t, p.Status()[0].Healthy)
+}
+
+func TestBroadcast(t *testing.T) {
+	var posted atomic.Int64
+	handler := func(status int) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/tx", r.URL.Path)
+			body, _ := io.ReadAll(r.Body)
+			assert.Equal(t, `{"id":"transaction"}`, string(body))
+			posted.Add(1)
+			w.WriteHeader(status)
+		}
+	}
+	rejecting := httptest.NewServer(handler(http.StatusBadRequest))
+	defer rejecting.Close()
+	accepting := httptest.NewServer(handler(http.StatusOK))
+	defer accepting.Close()
+	unused := httptest.NewServer(handler(http.StatusOK))
+	defer unused.Close()
+
+	p, err := New([]Config{{URL: rejecting.URL}, {URL: accepting.URL}, {URL: unused.URL}}, WithBroadcast(2))
+	assert.NoError(t, err)
+	res, err := p.Client().Client.Post(rejecting.URL+"/tx", "application/json", stringReader(`{"id":"transaction"}`))
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, int64(2), posted.Load())
+}
+
+func TestProbe(t *testing.T) {
+	healthy := true
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/info", r.URL.Path)
+		if !healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer gateway.Close()
+
+	p, err := New([]Config{{URL: gateway.URL}})
+	assert.NoError(t, err)
+
+	healthy = false
+	p.Probe()
+	assert.False(t, p.Status()[0].Healthy)
+
+	healthy = true
+	p.Probe()
+	assert.True(t, p.Status()[0].Healthy)
+	assert.Equal(t, int64(1), p.Status()[0].Failures)
+}
+
+func stringReader(s string) io.Reader {
+	return &stringBody{s: s}
+}
+
+type stringBody struct {
+	s string
+	i int
+}
+
+func (b *stringBody) Read(p []byte) (int, error) {
+	if b.i >= len(b.s) {
+		return 0, io.EOF
+	}
+	n := copy(p, b.s[b.i:])
+	b.i += n
+	return n, nil
+}
@@ -0,0 +1,191 @@
+package ledger
+
+import (
+	"encoding/csv"
+	"io"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/liteseed/transit/internal/database/schema"
+)
+
+// Accounts of the ledger. An entry adds its amount to the balance of its debit account and
+// takes it off the balance of its credit account, so the balances always add up to zero.
+// Payers holds what users paid and did not spend yet, income holds the fees earned, both as
+// negative balances.
+const (
+	Wallet  = "wallet"  // AR held by the transit wallet
+	Payers  = "payers"  // AR owed to users until it is spent on their orders or refunded
+	Network = "network" // AR paid to miners as transaction rewards
+	Income  = "income"  // AR earned as fees
+	Service = "service" // AR spent on upload services, paid for out of band
+)
+
+// Kinds of entries
+const (
+	Payment    = "payment"     // AR received from a payer
+	Payout     = "payout"      // AR paid out to a staker
+	NetworkFee = "network-fee" // reward of a transaction sent by the transit wallet
+	Refund     = "refund"      // AR sent back to a payer
+	FeeIncome  = "fee-income"  // fee earned on an order or kept from a refund
+	Upload     = "upload"      // network cost of an order uploaded to an upload service
+	Bundled    = "bundled"     // price of an order bundled by transit, which paid the network itself
+)
+
+func entry(kind schema.EntryKind, debit string, credit string, amount string, counterparty string, orderId string, transactionId string) *schema.Entry {
+	return &schema.Entry{Kind: kind, Debit: debit, Credit: credit, Amount: amount, Counterparty: counterparty, OrderId: orderId, TransactionId: transactionId}
+}
+
+// PaymentEntry records a transfer from a payer to the transit wallet
+func PaymentEntry(payer string, transactionId string, quantity string) *schema.Entry {
+	return entry(Payment, Wallet, Payers, quantity, payer, "", transactionId)
+}
+
+// PayoutEntries record the payout of an order: the network cost sent to its staker and the
+// fee earned, both spent from what the payer paid, and the reward of the payout transaction
+func PayoutEntries(o *schema.Order, transactionId string, base string, fee string, reward string) []*schema.Entry {
+	return []*schema.Entry{
+		entry(Payout, Payers, Wallet, base, o.Address, o.Id, transactionId),
+		entry(FeeIncome, Payers, Income, fee, o.Address, o.Id, transactionId),
+		entry(NetworkFee, Network, Wallet, reward, "", o.Id, transactionId),
+	}
+}
+
+// ReplicaPayoutEntries record the payout of a replica: the network cost sent to its staker
+// and the reward of the payout transaction. The fee is recorded with the payout of the order.
+func ReplicaPayoutEntries(r *schema.Replica, base string, reward string) []*schema.Entry {
+	return []*schema.Entry{
+		entry(Payout, Payers, Wallet, base, r.Staker, r.OrderId, r.TransactionId),
+		entry(NetworkFee, Network, Wallet, reward, "", r.OrderId, r.TransactionId),
+	}
+}
+
+// UploadEntries record an order uploaded to an upload service: the network cost spent on
+// the service and the fee earned. Nothing is sent from the wallet, so there is no transaction.
+func UploadEntries(o *schema.Order, base string, fee string) []*schema.Entry {
+	return []*schema.Entry{
+		entry(Upload, Payers, Service, base, o.Backend, o.Id, ""),
+		entry(FeeIncome, Payers, Income, fee, o.Address, o.Id, ""),
+	}
+}
+
+// BundleEntries record a bundle posted by transit: the reward of its transaction and the
+// price of each of its orders, earned in full as transit paid the network for them
+func BundleEntries(b *schema.Bundle, orders []schema.Order) []*schema.Entry {
+	entries := []*schema.Entry{entry(NetworkFee, Network, Wallet, b.Reward, "", "", b.Id)}
+	for _, o := range orders {
+		entries = append(entries, entry(Bundled, Payers, Income, o.Received, o.Address, o.Id, b.Id))
+	}
+	return entries
+}
+
+// RefundEntries record a refund: the amount sent back, the fee kept and the reward of the refund transaction
+func RefundEntries(r *schema.Refund, reward string) []*schema.Entry {
+	return []*schema.Entry{
+		entry(Refund, Payers, Wallet, r.Amount, r.Address, r.OrderId, r.TransactionId),
+		entry(FeeIncome, Payers, Income, r.Fee, r.Address, r.OrderId, r.TransactionId),
+		entry(NetworkFee, Network, Wallet, reward, "", r.OrderId, r.TransactionId),
+	}
+}
+
+// DayReport sums the entries of a day by kind
+type DayReport struct {
+	Day    string            `json:"day"`
+	Totals map[string]string `json:"totals"`
+}
+
+// Daily sums the entries by UTC day and kind, oldest day first
+func Daily(entries []schema.Entry) []DayReport {
+	days := map[string]map[string]*big.Int{}
+	for _, e := range entries {
+		amount, ok := new(big.Int).SetString(e.Amount, 10)
+		if !ok {
+			continue
+		}
+		day := e.CreatedAt.UTC().Format(time.DateOnly)
+		if days[day] == nil {
+			days[day] = map[string]*big.Int{}
+		}
+		if days[day][string(e.Kind)] == nil {
+			days[day][string(e.Kind)] = big.NewInt(0)
+		}
+		days[day][string(e.Kind)].Add(days[day][string(e.Kind)], amount)
+	}
+
+	reports := []DayReport{}
+	for day, totals := range days {
+		r := DayReport{Day: day, Totals: map[string]string{}}
+		for kind, total := range totals {
+			r.Totals[kind] = total.String()
+		}
+		reports = append(reports, r)
+	}
+	slices.SortFunc(reports, func(a, b DayReport) int { return strings.Compare(a.Day, b.Day) })
+	return reports
+}
+
+// StakerReport sums the payouts to a staker
+type StakerReport struct {
+	Staker  string `json:"staker"`
+	Payouts int    `json:"payouts"`
+	Amount  string `json:"amount"`
+}
+
+// ByStaker sums the payouts by staker, by staker address
+func ByStaker(entries []schema.Entry) []StakerReport {
+	stakers := map[string]*StakerReport{}
+	amounts := map[string]*big.Int{}
+	for _, e := range entries {
+		if e.Kind != Payout {
+			continue
+		}
+		amount, ok := new(big.Int).SetString(e.Amount, 10)
+		if !ok {
+			continue
+		}
+		if stakers[e.Counterparty] == nil {
+			stakers[e.Counterparty] = &StakerReport{Staker: e.Counterparty}
+			amounts[e.Counterparty] = big.NewInt(0)
+		}
+		stakers[e.Counterparty].Payouts++
+		amounts[e.Counterparty].Add(amounts[e.Counterparty], amount)
+	}
+
+	reports := []StakerReport{}
+	for staker, r := range stakers {
+		r.Amount = amounts[staker].String()
+		reports = append(reports, *r)
+	}
+	slices.SortFunc(reports, func(a, b StakerReport) int { return strings.Compare(a.Staker, b.Staker) })
+	return reports
+}
+
+// Export writes the entries as CSV with a header line
+func Export(w io.Writer, entries []schema.Entry) error {
+	c := csv.NewWriter(w)
+	err := c.Write([]string{"id", "created_at", "kind", "debit", "credit", "amount", "counterparty", "order_id", "transaction_id"})
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		err = c.Write([]string{
+			strconv.FormatUint(uint64(e.Id), 10),
+			e.CreatedAt.UTC().Format(time.RFC3339),
+			string(e.Kind),
+			e.Debit,
+			e.Credit,
+			e.Amount,
+			e.Counterparty,
+			e.OrderId,
+			e.TransactionId,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	c.Flush()
+	return c.Error()
+}
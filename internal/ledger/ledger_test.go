@@ -0,0 +1,66 @@
+package ledger
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/liteseed/transit/internal/database/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEntries(t *testing.T) {
+	// A payer overpays an order by 2000 and is refunded what is left less a fee of 500
+	o := &schema.Order{Id: "dataitem", Address: "staker"}
+	entries := []*schema.Entry{PaymentEntry("payer", "payment", "12010")}
+	entries = append(entries, PayoutEntries(o, "payout", "10000", "10", "500")...)
+	entries = append(entries, RefundEntries(&schema.Refund{OrderId: "dataitem", Address: "payer", Fee: "500", Amount: "1500", TransactionId: "refund"}, "500")...)
+
+	balances := map[string]*big.Int{Wallet: big.NewInt(0), Payers: big.NewInt(0), Network: big.NewInt(0), Income: big.NewInt(0)}
+	for _, e := range entries {
+		amount, _ := new(big.Int).SetString(e.Amount, 10)
+		balances[e.Debit].Add(balances[e.Debit], amount)
+		balances[e.Credit].Sub(balances[e.Credit], amount)
+	}
+	assert.Equal(t, "-490", balances[Wallet].String())
+	assert.Equal(t, "0", balances[Payers].String())
+	assert.Equal(t, "1000", balances[Network].String())
+	assert.Equal(t, "-510", balances[Income].String())
+
+	assert.Equal(t, schema.Entry{Kind: Payout, Debit: Payers, Credit: Wallet, Amount: "10000", Counterparty: "staker", OrderId: "dataitem", TransactionId: "payout"}, *entries[1])
+}
+
+func TestDaily(t *testing.T) {
+	day := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	entries := []schema.Entry{
+		{Kind: Payment, Amount: "100", CreatedAt: day.AddDate(0, 0, 1)},
+		{Kind: Payment, Amount: "100", CreatedAt: day},
+		{Kind: Payment, Amount: "50", CreatedAt: day},
+		{Kind: Payout, Amount: "90", CreatedAt: day},
+	}
+	assert.Equal(t, []DayReport{
+		{Day: "2024-01-01", Totals: map[string]string{Payment: "150", Payout: "90"}},
+		{Day: "2024-01-02", Totals: map[string]string{Payment: "100"}},
+	}, Daily(entries))
+}
+
+func TestByStaker(t *testing.T) {
+	entries := []schema.Entry{
+		{Kind: Payout, Amount: "100", Counterparty: "b"},
+		{Kind: Payout, Amount: "100", Counterparty: "a"},
+		{Kind: Payout, Amount: "50", Counterparty: "a"},
+		{Kind: FeeIncome, Amount: "10", Counterparty: "a"},
+	}
+	assert.Equal(t, []StakerReport{
+		{Staker: "a", Payouts: 2, Amount: "150"},
+		{Staker: "b", Payouts: 1, Amount: "100"},
+	}, ByStaker(entries))
+}
+
+func TestExport(t *testing.T) {
+	b := &bytes.Buffer{}
+	err := Export(b, []schema.Entry{{Id: 1, Kind: Payment, Debit: Wallet, Credit: Payers, Amount: "100", Counterparty: "payer", TransactionId: "payment", CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}})
+	assert.NoError(t, err)
+	assert.Equal(t, "id,created_at,kind,debit,credit,amount,counterparty,order_id,transaction_id\n1,2024-01-01T00:00:00Z,payment,wallet,payers,100,payer,,payment\n", b.String())
+}
@@ -0,0 +1,188 @@
+package pricing
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// ChunkSize is the unit the network prices data in. Uploads are rounded up to a whole
+// number of chunks, so every size within a chunk costs the same.
+const ChunkSize = 256 * 1024
+
+// MaxSize is the largest upload in bytes that is priced
+const MaxSize = math.MaxUint32
+
+// Source fetches the network cost of an upload from a gateway
+type Source interface {
+	GetTransactionPrice(size int, target string) (string, error)
+}
+
+type CacheConfig struct {
+	TTL        int // seconds a price is served before it is fetched again
+	MaxStale   int // seconds a price is still served while the gateway is unreachable
+	MaxBuckets int // buckets cached, the least recently used is dropped for a new one
+}
+
+var DefaultCacheConfig = CacheConfig{TTL: 120, MaxStale: 900, MaxBuckets: 1024}
+
+var (
+	ErrStale = errors.New("price is too old to be served")
+	ErrSize  = errors.New("size should be between 0 and 2^32-1")
+)
+
+// Cache serves the network cost of uploads by size bucket. Prices are fetched again once
+// they are older than the TTL and, if the gateway fails, the last known price is served
+// until it is older than the staleness bound.
+type Cache struct {
+	source     Source
+	ttl        time.Duration
+	maxStale   time.Duration
+	maxBuckets int
+	now        func() time.Time
+
+	mu      sync.Mutex
+	buckets map[int]*bucket
+}
+
+type bucket struct {
+	mu        sync.Mutex
+	size      int
+	price     string
+	fetchedAt time.Time
+	usedAt    time.Time
+}
+
+// NewCache caches the prices of source. Unset fields of the config keep their default.
+func NewCache(source Source, config CacheConfig) *Cache {
+	if config.TTL <= 0 {
+		config.TTL = DefaultCacheConfig.TTL
+	}
+	if config.MaxStale < config.TTL {
+		config.MaxStale = max(DefaultCacheConfig.MaxStale, config.TTL)
+	}
+	if config.MaxBuckets <= 0 {
+		config.MaxBuckets = DefaultCacheConfig.MaxBuckets
+	}
+	return &Cache{
+		source:     source,
+		ttl:        time.Duration(config.TTL) * time.Second,
+		maxStale:   time.Duration(config.MaxStale) * time.Second,
+		maxBuckets: config.MaxBuckets,
+		now:        time.Now,
+		buckets:    map[int]*bucket{},
+	}
+}
+
+// Bucket is the number of chunks an upload of size bytes is priced as
+func Bucket(size int) int {
+	return (size + ChunkSize - 1) / ChunkSize
+}
+
+// Price returns the network cost of an upload of size bytes in winston
+func (c *Cache) Price(size int) (string, error) {
+	if size < 0 || int64(size) > MaxSize {
+		return "", ErrSize
+	}
+
+	c.mu.Lock()
+	b, ok := c.buckets[Bucket(size)]
+	if !ok {
+		if len(c.buckets) >= c.maxBuckets {
+			c.evict()
+		}
+		b = &bucket{size: size}
+		c.buckets[Bucket(size)] = b
+	}
+	c.mu.Unlock()
+
+	// Requests for the same bucket wait for a single fetch
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := c.now()
+	b.usedAt = now
+	if b.price != "" && now.Sub(b.fetchedAt) < c.ttl {
+		return b.price, nil
+	}
+	err := c.fetch(b)
+	if err == nil {
+		return b.price, nil
+	}
+	if b.price != "" && now.Sub(b.fetchedAt) < c.maxStale {
+		return b.price, nil
+	}
+	if b.price != "" {
+		return "", fmt.Errorf("%w: %w", ErrStale, err)
+	}
+	return "", err
+}
+
+// Refresh fetches again the price of every bucket requested within the staleness bound,
+// so requests are served from the cache. Buckets left unused are dropped.
+// It returns the number of prices refreshed and the first error.
+func (c *Cache) Refresh() (int, error) {
+	now := c.now()
+	c.mu.Lock()
+	buckets := make([]*bucket, 0, len(c.buckets))
+	for key, b := range c.buckets {
+		b.mu.Lock()
+		idle := now.Sub(b.usedAt) > c.maxStale
+		b.mu.Unlock()
+		if idle {
+			delete(c.buckets, key)
+			continue
+		}
+		buckets = append(buckets, b)
+	}
+	c.mu.Unlock()
+
+	refreshed := 0
+	var first error
+	for _, b := range buckets {
+		b.mu.Lock()
+		err := c.fetch(b)
+		b.mu.Unlock()
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			continue
+		}
+		refreshed++
+	}
+	return refreshed, first
+}
+
+// Len is the number of buckets cached
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.buckets)
+}
+
+// evict drops the least recently used bucket. The caller holds c.mu.
+func (c *Cache) evict() {
+	oldest := -1
+	var usedAt time.Time
+	for key, b := range c.buckets {
+		b.mu.Lock()
+		u := b.usedAt
+		b.mu.Unlock()
+		if oldest == -1 || u.Before(usedAt) {
+			oldest, usedAt = key, u
+		}
+	}
+	delete(c.buckets, oldest)
+}
+
+func (c *Cache) fetch(b *bucket) error {
+	p, err := c.source.GetTransactionPrice(b.size, "")
+	if err != nil {
+		return err
+	}
+	b.price = p
+	b.fetchedAt = c.now()
+	return nil
+}
@@ -0,0 +1,127 @@
+package pricing
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+)
+
+// FeePolicy decides the fee transit charges on top of the network cost of an upload
+type FeePolicy interface {
+	Fee(base *big.Int, size int, apiKey string) *big.Int
+}
+
+// Quote splits the price of an upload into the network cost and the transit fee
+type Quote struct {
+	Base *big.Int
+	Fee  *big.Int
+}
+
+func (q *Quote) Total() *big.Int {
+	return new(big.Int).Add(q.Base, q.Fee)
+}
+
+// Replicate prices n copies of an upload, each held and paid for on its own
+func (q *Quote) Replicate(n int) *Quote {
+	if n <= 1 {
+		return q
+	}
+	return &Quote{
+		Base: new(big.Int).Mul(q.Base, big.NewInt(int64(n))),
+		Fee:  new(big.Int).Mul(q.Fee, big.NewInt(int64(n))),
+	}
+}
+
+// NewQuote applies the policy to a network cost in winston as returned by the gateway
+func NewQuote(policy FeePolicy, base string, size int, apiKey string) (*Quote, error) {
+	cost, ok := new(big.Int).SetString(base, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid price: %s", base)
+	}
+	return &Quote{Base: cost, Fee: policy.Fee(cost, size, apiKey)}, nil
+}
+
+// Tier charges Rate basis points of the network cost for uploads up to MaxSize bytes.
+// A MaxSize of 0 matches any size.
+type Tier struct {
+	MaxSize int
+	Rate    int
+}
+
+type Config struct {
+	Minimum   string         // flat minimum fee in winston
+	Tiers     []Tier         // sorted by MaxSize, the first matching tier applies
+	Discounts map[string]int // fee discount in percent by api key
+	Internal  []string       // api keys that are not charged a fee
+}
+
+// Policy is a FeePolicy built from the configuration file
+type Policy struct {
+	minimum   *big.Int
+	tiers     []Tier
+	discounts map[string]int
+	internal  []string
+}
+
+// Default charges 0.1% of the network cost
+var Default = &Policy{minimum: big.NewInt(0), tiers: []Tier{{Rate: 10}}}
+
+func New(config Config) (*Policy, error) {
+	p := &Policy{
+		minimum:   big.NewInt(0),
+		tiers:     config.Tiers,
+		discounts: config.Discounts,
+		internal:  config.Internal,
+	}
+	if config.Minimum != "" {
+		if _, ok := p.minimum.SetString(config.Minimum, 10); !ok || p.minimum.Sign() < 0 {
+			return nil, fmt.Errorf("invalid minimum fee: %s", config.Minimum)
+		}
+	}
+	if len(p.tiers) == 0 {
+		p.tiers = Default.tiers
+	}
+	for i, t := range p.tiers {
+		if t.Rate < 0 || t.MaxSize < 0 {
+			return nil, errors.New("fee tiers cannot be negative")
+		}
+		if t.MaxSize == 0 && i != len(p.tiers)-1 {
+			return nil, errors.New("only the last fee tier can be unbounded")
+		}
+	}
+	for key, d := range p.discounts {
+		if d < 0 || d > 100 {
+			return nil, fmt.Errorf("discount for %s should be between 0 and 100", key)
+		}
+	}
+	return p, nil
+}
+
+func (p *Policy) Fee(base *big.Int, size int, apiKey string) *big.Int {
+	fee := big.NewInt(0)
+	if apiKey != "" && slices.Contains(p.internal, apiKey) {
+		return fee
+	}
+
+	// Uploads larger than every tier are charged the rate of the last one
+	rate := p.tiers[len(p.tiers)-1].Rate
+	for _, t := range p.tiers {
+		if t.MaxSize == 0 || size <= t.MaxSize {
+			rate = t.Rate
+			break
+		}
+	}
+	fee.Mul(base, big.NewInt(int64(rate)))
+	fee.Quo(fee, big.NewInt(10000))
+	if fee.Cmp(p.minimum) < 0 {
+		fee.Set(p.minimum)
+	}
+
+	if d, ok := p.discounts[apiKey]; ok && apiKey != "" {
+		discount := new(big.Int).Mul(fee, big.NewInt(int64(d)))
+		discount.Quo(discount, big.NewInt(100))
+		fee.Sub(fee, discount)
+	}
+	return fee
+}
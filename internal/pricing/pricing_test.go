@@ -0,0 +1,160 @@
+package pricing
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefault(t *testing.T) {
+	q, err := NewQuote(Default, "1000", 1000, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "1000", q.Base.String())
+	assert.Equal(t, "1", q.Fee.String())
+	assert.Equal(t, "1001", q.Total().String())
+	assert.Equal(t, "3003", q.Replicate(3).Total().String())
+
+	_, err = NewQuote(Default, "invalid", 1000, "")
+	assert.Error(t, err)
+}
+
+func TestPolicy(t *testing.T) {
+	p, err := New(Config{
+		Minimum:   "50",
+		Tiers:     []Tier{{MaxSize: 1024, Rate: 100}, {MaxSize: 1048576, Rate: 50}, {Rate: 10}},
+		Discounts: map[string]int{"partner": 50},
+		Internal:  []string{"internal"},
+	})
+	assert.NoError(t, err)
+
+	base := big.NewInt(100000)
+	assert.Equal(t, "1000", p.Fee(base, 100, "").String())
+	assert.Equal(t, "500", p.Fee(base, 2048, "").String())
+	assert.Equal(t, "100", p.Fee(base, 2097152, "").String())
+	assert.Equal(t, "50", p.Fee(big.NewInt(100), 100, "").String())
+	assert.Equal(t, "250", p.Fee(base, 2048, "partner").String())
+	assert.Equal(t, "0", p.Fee(base, 100, "internal").String())
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(Config{Minimum: "-1"})
+	assert.Error(t, err)
+
+	_, err = New(Config{Tiers: []Tier{{Rate: 10}, {MaxSize: 10, Rate: 10}}})
+	assert.Error(t, err)
+
+	_, err = New(Config{Discounts: map[string]int{"key": 101}})
+	assert.Error(t, err)
+
+	p, err := New(Config{})
+	assert.NoError(t, err)
+	assert.Equal(t, "1", p.Fee(big.NewInt(1000), 1000, "").String())
+}
+
+type source struct {
+	price string
+	err   error
+	sizes []int
+}
+
+func (s *source) GetTransactionPrice(size int, target string) (string, error) {
+	s.sizes = append(s.sizes, size)
+	return s.price, s.err
+}
+
+func TestCache(t *testing.T) {
+	s := &source{price: "1000"}
+	c := NewCache(s, CacheConfig{TTL: 60, MaxStale: 600})
+	now := time.Unix(0, 0)
+	c.now = func() time.Time { return now }
+
+	p, err := c.Price(1000)
+	assert.NoError(t, err)
+	assert.Equal(t, "1000", p)
+
+	// Sizes within the same chunk share a price
+	s.price = "2000"
+	p, err = c.Price(2000)
+	assert.NoError(t, err)
+	assert.Equal(t, "1000", p)
+	assert.Equal(t, []int{1000}, s.sizes)
+
+	p, err = c.Price(ChunkSize + 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "2000", p)
+	assert.Equal(t, 2, c.Len())
+
+	t.Run("Expired", func(t *testing.T) {
+		now = now.Add(61 * time.Second)
+		p, err := c.Price(1000)
+		assert.NoError(t, err)
+		assert.Equal(t, "2000", p)
+	})
+
+	t.Run("Stale", func(t *testing.T) {
+		s.err = errors.New("gateway down")
+		now = now.Add(300 * time.Second)
+		p, err := c.Price(1000)
+		assert.NoError(t, err)
+		assert.Equal(t, "2000", p)
+
+		now = now.Add(301 * time.Second)
+		_, err = c.Price(1000)
+		assert.ErrorIs(t, err, ErrStale)
+
+		_, err = c.Price(0)
+		assert.Error(t, err)
+		s.err = nil
+	})
+
+	t.Run("Refresh", func(t *testing.T) {
+		s.price = "3000"
+		s.sizes = nil
+		n, err := c.Refresh()
+		assert.NoError(t, err)
+		// The bucket above one chunk was not requested within the staleness bound
+		assert.Equal(t, 2, n)
+		assert.ElementsMatch(t, []int{1000, 0}, s.sizes)
+		assert.Equal(t, 2, c.Len())
+
+		p, err := c.Price(1000)
+		assert.NoError(t, err)
+		assert.Equal(t, "3000", p)
+		assert.Equal(t, 2, len(s.sizes))
+	})
+}
+
+func TestCacheBounds(t *testing.T) {
+	s := &source{price: "1000"}
+	c := NewCache(s, CacheConfig{TTL: 60, MaxStale: 600, MaxBuckets: 2})
+	now := time.Unix(0, 0)
+	c.now = func() time.Time { return now }
+
+	_, err := c.Price(MaxSize + 1)
+	assert.ErrorIs(t, err, ErrSize)
+	_, err = c.Price(-1)
+	assert.ErrorIs(t, err, ErrSize)
+	assert.Empty(t, s.sizes)
+
+	_, err = c.Price(MaxSize)
+	assert.NoError(t, err)
+	now = now.Add(time.Second)
+	_, err = c.Price(1000)
+	assert.NoError(t, err)
+	now = now.Add(time.Second)
+	_, err = c.Price(ChunkSize + 1)
+	assert.NoError(t, err)
+	// The least recently used bucket is dropped for the new one
+	assert.Equal(t, 2, c.Len())
+
+	s.sizes = nil
+	_, err = c.Price(1000)
+	assert.NoError(t, err)
+	assert.Empty(t, s.sizes)
+	_, err = c.Price(MaxSize)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{MaxSize}, s.sizes)
+}
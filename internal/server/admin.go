@@ -0,0 +1,57 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/liteseed/transit/internal/database/schema"
+)
+
+// adminAuth only lets through requests carrying the configured admin key as a bearer token.
+// The admin routes are disabled when no key is configured.
+func (srv *Server) adminAuth(ctx *gin.Context) {
+	if srv.adminKey == "" {
+		NewError(ctx, http.StatusNotFound, errors.New("admin api is disabled"))
+		ctx.Abort()
+		return
+	}
+	token, found := strings.CutPrefix(ctx.GetHeader("authorization"), "Bearer ")
+	if !found || subtle.ConstantTimeCompare([]byte(token), []byte(srv.adminKey)) != 1 {
+		NewError(ctx, http.StatusUnauthorized, errors.New("invalid admin key"))
+		ctx.Abort()
+		return
+	}
+	ctx.Next()
+}
+
+// ledgerEntries returns the ledger entries of the days from ?from= up to ?to=, both formatted
+// as YYYY-MM-DD and inclusive. It defaults to the last 30 days.
+func (srv *Server) ledgerEntries(ctx *gin.Context) (*[]schema.Entry, bool) {
+	to := time.Now().UTC().Truncate(24 * time.Hour)
+	from := to.AddDate(0, 0, -29)
+	var err error
+	if q := ctx.Query("from"); q != "" {
+		from, err = time.Parse(time.DateOnly, q)
+		if err != nil {
+			NewError(ctx, http.StatusBadRequest, errors.New("invalid from date"))
+			return nil, false
+		}
+	}
+	if q := ctx.Query("to"); q != "" {
+		to, err = time.Parse(time.DateOnly, q)
+		if err != nil {
+			NewError(ctx, http.StatusBadRequest, errors.New("invalid to date"))
+			return nil, false
+		}
+	}
+	entries, err := srv.database.GetEntries(from, to.AddDate(0, 0, 1))
+	if err != nil {
+		NewError(ctx, http.StatusInternalServerError, err)
+		return nil, false
+	}
+	return entries, true
+}
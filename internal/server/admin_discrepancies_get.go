@@ -0,0 +1,21 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/liteseed/transit/internal/database/schema"
+)
+
+// AdminDiscrepanciesGet
+//
+// List the discrepancies found by the last reconciliation, optionally only those of the given
+// kind, e.g. ?kind=missing-payout.
+func (srv *Server) AdminDiscrepanciesGet(ctx *gin.Context) {
+	discrepancies, err := srv.database.GetDiscrepancies(&schema.Discrepancy{Kind: schema.DiscrepancyKind(ctx.Query("kind"))}, 1000)
+	if err != nil {
+		NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, discrepancies)
+}
@@ -0,0 +1,19 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminGatewaysGet
+//
+// Get the health, latency and request counts of the gateways.
+func (srv *Server) AdminGatewaysGet(ctx *gin.Context) {
+	if srv.cron == nil || srv.cron.Gateways() == nil {
+		NewError(ctx, http.StatusNotFound, errors.New("gateway pool is disabled"))
+		return
+	}
+	ctx.JSON(http.StatusOK, srv.cron.Gateways())
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/liteseed/transit/internal/cron"
+)
+
+type AdminJobPostResponse struct {
+	Job string `json:"job"`
+}
+
+// AdminJobPost
+//
+// Trigger a cron job immediately, e.g. to drain a backlog during an incident.
+// The job runs in the background; a job that is already running is not started twice.
+func (srv *Server) AdminJobPost(ctx *gin.Context) {
+	name := ctx.Param("name")
+	if srv.cron == nil {
+		NewError(ctx, http.StatusNotFound, cron.ErrJobNotFound)
+		return
+	}
+	err := srv.cron.Trigger(name)
+	if errors.Is(err, cron.ErrJobNotFound) {
+		NewError(ctx, http.StatusNotFound, err)
+		return
+	}
+	if errors.Is(err, cron.ErrJobRunning) {
+		NewError(ctx, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusAccepted, AdminJobPostResponse{Job: name})
+}
@@ -0,0 +1,19 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/liteseed/transit/internal/cron"
+)
+
+// AdminJobsGet
+//
+// List the cron jobs with the number of orders processed in their last run and the backlog left behind.
+func (srv *Server) AdminJobsGet(ctx *gin.Context) {
+	if srv.cron == nil {
+		ctx.JSON(http.StatusOK, []cron.JobStatus{})
+		return
+	}
+	ctx.JSON(http.StatusOK, srv.cron.Status())
+}
@@ -0,0 +1,19 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/liteseed/transit/internal/ledger"
+)
+
+// AdminLedgerDailyGet
+//
+// Sum the ledger entries by day and kind, e.g. ?from=2024-01-01&to=2024-01-31.
+func (srv *Server) AdminLedgerDailyGet(ctx *gin.Context) {
+	entries, ok := srv.ledgerEntries(ctx)
+	if !ok {
+		return
+	}
+	ctx.JSON(http.StatusOK, ledger.Daily(*entries))
+}
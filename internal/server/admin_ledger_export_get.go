@@ -0,0 +1,27 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/liteseed/transit/internal/ledger"
+)
+
+// AdminLedgerExportGet
+//
+// Download the ledger entries as CSV over the same range as AdminLedgerDailyGet.
+func (srv *Server) AdminLedgerExportGet(ctx *gin.Context) {
+	entries, ok := srv.ledgerEntries(ctx)
+	if !ok {
+		return
+	}
+	b := &bytes.Buffer{}
+	err := ledger.Export(b, *entries)
+	if err != nil {
+		NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	ctx.Header("Content-Disposition", `attachment; filename="ledger.csv"`)
+	ctx.Data(http.StatusOK, "text/csv", b.Bytes())
+}
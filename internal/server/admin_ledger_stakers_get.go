@@ -0,0 +1,19 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/liteseed/transit/internal/ledger"
+)
+
+// AdminLedgerStakersGet
+//
+// Sum the payouts by staker over the same range as AdminLedgerDailyGet.
+func (srv *Server) AdminLedgerStakersGet(ctx *gin.Context) {
+	entries, ok := srv.ledgerEntries(ctx)
+	if !ok {
+		return
+	}
+	ctx.JSON(http.StatusOK, ledger.ByStaker(*entries))
+}
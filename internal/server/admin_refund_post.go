@@ -0,0 +1,61 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/liteseed/transit/internal/database"
+	"github.com/liteseed/transit/internal/database/schema"
+)
+
+type refundAction struct {
+	to   schema.RefundStatus
+	from []schema.RefundStatus
+}
+
+// Refunds can be changed until they are sent
+var refundActions = map[string]refundAction{
+	"approve": {to: schema.RefundApproved, from: []schema.RefundStatus{schema.RefundPending, schema.RefundHeld}},
+	"hold":    {to: schema.RefundHeld, from: []schema.RefundStatus{schema.RefundPending, schema.RefundApproved}},
+	"deny":    {to: schema.RefundDenied, from: []schema.RefundStatus{schema.RefundPending, schema.RefundHeld, schema.RefundApproved}},
+}
+
+// AdminRefundPost
+//
+// Approve, hold or deny a refund. Approved refunds are sent by the send-refunds job.
+func (srv *Server) AdminRefundPost(ctx *gin.Context) {
+	action, ok := refundActions[ctx.Param("action")]
+	if !ok {
+		NewError(ctx, http.StatusBadRequest, errors.New("action must be approve, hold or deny"))
+		return
+	}
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	_, err = srv.database.GetRefund(uint(id))
+	if err != nil {
+		NewError(ctx, http.StatusNotFound, err)
+		return
+	}
+
+	err = srv.database.TransitionRefund(uint(id), &schema.Refund{Status: action.to}, action.from...)
+	if errors.Is(err, database.ErrRefundStatus) {
+		NewError(ctx, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+
+	r, err := srv.database.GetRefund(uint(id))
+	if err != nil {
+		NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, r)
+}
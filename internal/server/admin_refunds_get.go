@@ -0,0 +1,20 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/liteseed/transit/internal/database/schema"
+)
+
+// AdminRefundsGet
+//
+// List the refunds, optionally only those with the given status, e.g. ?status=pending to review them.
+func (srv *Server) AdminRefundsGet(ctx *gin.Context) {
+	refunds, err := srv.database.GetRefunds(&schema.Refund{Status: schema.RefundStatus(ctx.Query("status"))}, 1000)
+	if err != nil {
+		NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, refunds)
+}
@@ -0,0 +1,19 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminStakersGet
+//
+// Get the success rate, latency, score and quarantine of the stakers transit sent requests to.
+func (srv *Server) AdminStakersGet(ctx *gin.Context) {
+	if srv.bundler == nil {
+		NewError(ctx, http.StatusNotFound, errors.New("bundler is disabled"))
+		return
+	}
+	ctx.JSON(http.StatusOK, srv.bundler.Stakers())
+}
@@ -0,0 +1,19 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminWalletGet
+//
+// Get the balance of the transit wallet, the pending payout obligations and whether payouts are paused.
+func (srv *Server) AdminWalletGet(ctx *gin.Context) {
+	if srv.cron == nil {
+		NewError(ctx, http.StatusNotFound, errors.New("wallet monitor is disabled"))
+		return
+	}
+	ctx.JSON(http.StatusOK, srv.cron.WalletStatus())
+}
@@ -0,0 +1,32 @@
+package server
+
+import (
+	"github.com/liteseed/transit/internal/bundler"
+)
+
+// backend returns the backend holding the data items of an order, the stakers of the
+// contract unless the order names an upload service
+func (srv *Server) backend(name string) bundler.Bundler {
+	if b, ok := srv.backends[name]; ok && name != "" {
+		return b
+	}
+	return srv.bundler
+}
+
+// route returns the backend an upload is sent to, empty for the stakers of the contract
+func (srv *Server) route(size int, apiKey string) string {
+	name := bundler.Match(srv.routes, size, apiKey)
+	if _, ok := srv.backends[name]; !ok {
+		return ""
+	}
+	return name
+}
+
+// price returns the network cost of an upload to a backend. Backends pricing their own
+// uploads are asked, the stakers of the contract are paid the price of the gateway.
+func (srv *Server) price(backend string, size int) (string, error) {
+	if p, ok := srv.backend(backend).(bundler.Pricer); ok && backend != "" {
+		return p.Price(size)
+	}
+	return srv.prices.Price(size)
+}
@@ -8,9 +8,9 @@ import (
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/liteseed/goar/crypto"
 	"github.com/liteseed/goar/transaction/data_item"
 	"github.com/liteseed/transit/internal/bundler"
-	"github.com/liteseed/transit/internal/database/schema"
 )
 
 type PostResponse = bundler.DataItemPostResponse
@@ -60,8 +60,9 @@ func dataItemPostRequestBody(ctx *gin.Context, contentLength int) ([]byte, error
 // @Tags         Upload
 // @Accept       json
 // @Produce      json
+// @Param        X-Replicas   header    int     false  "Stakers to hold the data item until it is final" minimum(1)
 // @Success      200          {object}  PostResponse
-// @Failure      400,424,500  {object}  HTTPError
+// @Failure      400,409,413,422,424,500  {object}  HTTPError
 // @Router       /tx [post]
 func (srv *Server) DataItemPost(ctx *gin.Context) {
 	headers, err := dataItemPostRequestHeader(ctx)
@@ -75,6 +76,11 @@ func (srv *Server) DataItemPost(ctx *gin.Context) {
 		NewError(ctx, http.StatusBadRequest, err)
 		return
 	}
+	copies, err := srv.copies(ctx)
+	if err != nil {
+		NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
 	rawData, err := dataItemPostRequestBody(ctx, contentLength)
 	if err != nil {
 		NewError(ctx, http.StatusBadRequest, err)
@@ -95,31 +101,36 @@ func (srv *Server) DataItemPost(ctx *gin.Context) {
 		return
 	}
 
-	staker, err := srv.contract.Initiate(dataItem.ID, contentLength)
-	if err != nil {
+	backend := srv.route(len(dataItem.Raw), ctx.GetHeader(HeaderAPIKey))
+	u, err := srv.uploadTo(backend, dataItem.ID, dataItem.Raw, copies)
+	var initErr *initiateError
+	if errors.As(err, &initErr) {
 		NewError(ctx, http.StatusFailedDependency, err)
 		return
 	}
-	res, err := srv.bundler.DataItemPost(staker.URL, dataItem.Raw)
 	if err != nil {
-		NewError(ctx, http.StatusFailedDependency, err)
+		NewError(ctx, bundlerStatus(err), err)
 		return
 	}
 
-	o := &schema.Order{
-		Id:      dataItem.ID,
-		Address: staker.ID,
-		URL:     staker.URL,
-		Payment: schema.Unpaid,
-		Status:  schema.Created,
-		Size:    len(dataItem.Raw),
+	// Orders of data items without an Arweave owner can be paid by anyone
+	var owner string
+	if dataItem.SignatureType == data_item.Arweave {
+		owner, err = crypto.GetAddressFromOwner(dataItem.Owner)
+		if err != nil {
+			log.Println(err)
+		}
 	}
 
-	err = srv.database.CreateOrder(o)
+	o := u.order(dataItem.ID, len(dataItem.Raw), ctx.GetHeader(HeaderAPIKey))
+	o.Owner = owner
+
+	err = srv.createOrder(o, u)
 	if err != nil {
 		NewError(ctx, http.StatusInternalServerError, err)
 		return
 	}
 
-	ctx.JSON(http.StatusCreated, res)
+	ctx.Header(HeaderReplicas, strconv.Itoa(u.copies()))
+	ctx.JSON(http.StatusCreated, u.response)
 }
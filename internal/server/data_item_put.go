@@ -1,10 +1,13 @@
 package server
 
 import (
+	"errors"
+	"log"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 
+	"github.com/liteseed/transit/internal/database"
 	"github.com/liteseed/transit/internal/database/schema"
 )
 
@@ -18,21 +21,63 @@ type DataItemPutResponse struct {
 // Update payment id to data-item godoc
 // @Summary      Send a payment id for a data-item
 // @Description  Once a payment is made send a transaction id for a data-item
+// @Description  Payments sent after the payment deadline of the data-item are rejected
+// @Description  Payments tagged with Data-Item-Id are discovered without this call
+// @Description  A payment can pay for several data-items up to its quantity
+// @Description  A partially paid data-item is topped up by sending another payment id
 // @Tags         Payment
 // @Accept       json
 // @Produce      json
 // @Param        id               path      string              true  "data-item id"
 // @Param        paymentId        path      string              true  "payment id"
 // @Success      200              {object}  DataItemPutResponse
-// @Failure      400,404          {object}  HTTPError
+// @Failure      400,404,409,410  {object}  HTTPError
 // @Router       /tx/{id}/{payment_id} [put]
 func (srv *Server) DataItemPut(ctx *gin.Context) {
 	dataItemID := ctx.Param("id")
 	paymentID := ctx.Param("payment_id")
-	err := srv.database.UpdateOrder(dataItemID, &schema.Order{TransactionId: paymentID, Status: schema.Queued})
+
+	o, err := srv.database.GetOrder(dataItemID)
+	if err != nil {
+		NewError(ctx, http.StatusNotFound, err)
+		return
+	}
+	if o.Status == schema.Expired || (o.Status == schema.Created && srv.pastDeadline(o)) {
+		NewError(ctx, http.StatusGone, errors.New("payment deadline expired"))
+		return
+	}
+
+	u := &schema.Order{TransactionId: paymentID, Status: schema.Queued}
+	if o.Payment == schema.Partial {
+		// A top-up of a partially paid order
+		u.Payment = schema.Unpaid
+	} else if o.TransactionId != "" && o.TransactionId != paymentID {
+		NewError(ctx, http.StatusConflict, errors.New("payment already submitted"))
+		return
+	}
+	t, err := srv.database.GetTransfer(paymentID)
+	if err == nil && database.Exhausted(t) {
+		NewError(ctx, http.StatusConflict, errors.New("payment already used"))
+		return
+	}
+
+	err = srv.database.UpdateOrder(dataItemID, u)
 	if err != nil {
 		NewError(ctx, http.StatusNotFound, err)
 		return
 	}
 	ctx.JSON(http.StatusAccepted, DataItemPutResponse{Id: dataItemID, PaymentId: paymentID})
 }
+
+func (srv *Server) pastDeadline(o *schema.Order) bool {
+	var height int64
+	if o.DeadlineHeight > 0 {
+		info, err := srv.wallet.Client.GetNetworkInfo()
+		if err != nil {
+			log.Println(err)
+		} else {
+			height = info.Height
+		}
+	}
+	return o.PastDeadline(srv.paymentDeadline, height)
+}
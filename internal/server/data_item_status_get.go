@@ -1,11 +1,21 @@
 package server
 
 import (
+	"math/big"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/liteseed/transit/internal/database/schema"
 )
 
+type DataItemStatusGetResponse struct {
+	Status    string `json:"status"`
+	Payment   string `json:"payment"`
+	Price     string `json:"price,omitempty"`
+	Received  string `json:"received,omitempty"`
+	Shortfall string `json:"shortfall,omitempty"`
+}
+
 // DataItemStatusGet
 //
 // Get the status of the posted data-item godoc
@@ -17,22 +27,70 @@ import (
 // @Produce      json
 // @Param        id            path          string    true  "id of the data-item"
 // @Success      200           {string}      status
-// @Failure      404,424,500   {object}      HTTPError
+// @Failure      404,410,424,500   {object}  HTTPError
 // @Router       /tx/{id}/status [get]
 func (srv *Server) DataItemStatusGet(ctx *gin.Context) {
+	_, status, ok := srv.dataItemStatus(ctx)
+	if !ok {
+		return
+	}
+	ctx.JSON(http.StatusOK, status)
+}
+
+// DataItemStatusGetV2
+//
+// Get the status of the posted data-item and of its payment godoc
+// @Summary      Get the status of a data-item and of its payment
+// @Description  Get the current status of a posted data-item and of its payment.
+// @Description  Status "created", "queued", "sent", "confirmed", "failed", "invalid"
+// @Description  Payment "unpaid", "partial", "confirmed", "paid", "invalid"
+// @Description  A partially paid data-item reports the winston still missing in shortfall
+// @Tags         Fetch
+// @Accept       json
+// @Produce      json
+// @Param        id            path          string    true  "id of the data-item"
+// @Success      200           {object}      DataItemStatusGetResponse
+// @Failure      404,410,424,500   {object}  HTTPError
+// @Router       /v2/tx/{id}/status [get]
+func (srv *Server) DataItemStatusGetV2(ctx *gin.Context) {
+	o, status, ok := srv.dataItemStatus(ctx)
+	if !ok {
+		return
+	}
+
+	r := DataItemStatusGetResponse{Status: status, Payment: string(o.Payment), Price: o.Price, Received: o.Received}
+	price, ok := new(big.Int).SetString(o.Price, 10)
+	if ok {
+		received, ok := new(big.Int).SetString(o.Received, 10)
+		if !ok {
+			received = big.NewInt(0)
+		}
+		if shortfall := price.Sub(price, received); shortfall.Sign() > 0 {
+			r.Shortfall = shortfall.String()
+		}
+	}
+	ctx.JSON(http.StatusOK, r)
+}
+
+// dataItemStatus returns the order of the data item requested and its upload status.
+// It writes the error response when it fails.
+func (srv *Server) dataItemStatus(ctx *gin.Context) (*schema.Order, string, bool) {
 	id := ctx.Param("id")
 
 	o, err := srv.database.GetOrder(id)
 	if err != nil {
 		NewError(ctx, http.StatusNotFound, err)
-		return
+		return nil, "", false
 	}
 
-	res, err := srv.bundler.DataItemStatusGet(o.URL, id)
-	if err != nil {
-		NewError(ctx, http.StatusFailedDependency, err)
-		return
+	// Transit reports the status of the data items it bundles itself
+	res := []byte(o.Status)
+	if o.Backend != schema.SelfBundled {
+		res, err = srv.backend(o.Backend).DataItemStatusGet(o.URL, id)
+		if err != nil {
+			NewError(ctx, bundlerStatus(err), err)
+			return nil, "", false
+		}
 	}
-
-	ctx.JSON(http.StatusOK, string(res))
+	return o, string(res), true
 }
@@ -10,7 +10,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/liteseed/goar/tag"
 	"github.com/liteseed/goar/transaction/data_item"
-	"github.com/liteseed/transit/internal/database/schema"
+	"github.com/liteseed/transit/internal/signer"
 )
 
 // DataPost
@@ -21,8 +21,9 @@ import (
 // @Tags         Upload
 // @Accept       json
 // @Produce      json
+// @Param        X-Replicas   header    int     false  "Stakers to hold the data item until it is final" minimum(1)
 // @Success      200          {object}  PostResponse
-// @Failure      400,424,500  {object}  HTTPError
+// @Failure      400,409,413,422,424,500  {object}  HTTPError
 // @Router       /tx/ [post]
 
 func (srv *Server) DataPost(ctx *gin.Context) {
@@ -31,6 +32,11 @@ func (srv *Server) DataPost(ctx *gin.Context) {
 		NewError(ctx, http.StatusBadRequest, err)
 		return
 	}
+	copies, err := srv.copies(ctx)
+	if err != nil {
+		NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
 
 	var tags []tag.Tag
 
@@ -53,40 +59,34 @@ func (srv *Server) DataPost(ctx *gin.Context) {
 
 	d := data_item.New(raw, "", "", &tags)
 
-	err = d.Sign(srv.wallet.Signer)
+	err = signer.SignDataItem(srv.signer, d)
 	if err != nil {
 		log.Println(err)
 		NewError(ctx, http.StatusInternalServerError, errors.New("failed to sign data item"))
 		return
 	}
 
-	staker, err := srv.contract.Initiate(d.ID, len(d.Raw))
-	if err != nil {
+	backend := srv.route(len(d.Raw), ctx.GetHeader(HeaderAPIKey))
+	u, err := srv.uploadTo(backend, d.ID, d.Raw, copies)
+	var initErr *initiateError
+	if errors.As(err, &initErr) {
 		log.Println(err)
 		NewError(ctx, http.StatusFailedDependency, errors.New("failed to initiate upload"))
 		return
 	}
-	res, err := srv.bundler.DataItemPost(staker.URL, d.Raw)
 	if err != nil {
-		log.Println(err)
-		NewError(ctx, http.StatusFailedDependency, errors.New("failed to send to bundler"))
+		NewError(ctx, bundlerStatus(err), errors.New("failed to send to bundler"))
 		return
 	}
 
-	o := &schema.Order{
-		Id:      d.ID,
-		Address: staker.ID,
-		URL:     staker.URL,
-		Payment: schema.Unpaid,
-		Status:  schema.Created,
-		Size:    len(d.Raw),
-	}
+	o := u.order(d.ID, len(d.Raw), ctx.GetHeader(HeaderAPIKey))
 
-	err = srv.database.CreateOrder(o)
+	err = srv.createOrder(o, u)
 	if err != nil {
 		NewError(ctx, http.StatusInternalServerError, err)
 		return
 	}
 
-	ctx.JSON(http.StatusCreated, res)
+	ctx.Header(HeaderReplicas, strconv.Itoa(u.copies()))
+	ctx.JSON(http.StatusCreated, u.response)
 }
@@ -1,9 +1,15 @@
 package server
 
 import (
+	"errors"
+	"net/http"
+
 	"github.com/gin-gonic/gin"
+	"github.com/liteseed/transit/internal/bundler"
 )
 
+var errDataItemFinal = errors.New("data item is final on Arweave")
+
 // NewError example
 func NewError(ctx *gin.Context, status int, err error) {
 	ctx.JSON(status, HTTPError{
@@ -17,3 +23,20 @@ type HTTPError struct {
 	Code    int    `json:"code" format:"integer"`
 	Message string `json:"message" format:"string"`
 }
+
+// bundlerStatus is the status to answer a failed request to a staker with. Requests the
+// staker rejected because of what the user sent keep the status of the staker, anything
+// else is a failed dependency.
+func bundlerStatus(err error) int {
+	if errors.Is(err, errDataItemFinal) {
+		return http.StatusGone
+	}
+	var e *bundler.Error
+	if errors.As(err, &e) && errors.Is(err, bundler.ErrClient) {
+		switch e.Status {
+		case http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+			return e.Status
+		}
+	}
+	return http.StatusFailedDependency
+}
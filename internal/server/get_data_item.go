@@ -2,8 +2,9 @@ package server
 
 import (
 	"fmt"
-	"github.com/gin-gonic/gin"
 	"net/http"
+
+	"github.com/gin-gonic/gin"
 )
 
 func (srv *Server) GetDataItem(ctx *gin.Context) {
@@ -14,11 +15,12 @@ func (srv *Server) GetDataItem(ctx *gin.Context) {
 		return
 	}
 
-	res, err := srv.bundler.DataItemGet(o.URL, id)
+	res, err := srv.dataItemGet(o)
 	if err != nil {
-		NewError(ctx, http.StatusFailedDependency, err)
+		NewError(ctx, bundlerStatus(err), err)
 		return
 	}
+	defer res.Close()
 
-	ctx.Data(http.StatusOK, "application/octet-stream", res)
+	ctx.DataFromReader(http.StatusOK, -1, "application/octet-stream", res, nil)
 }
@@ -1,14 +1,15 @@
 package server
 
 import (
+	"bufio"
 	"encoding/json"
 	"errors"
+	"io"
 	"net/http"
 
 	"github.com/gabriel-vasile/mimetype"
 	"github.com/gin-gonic/gin"
-	"github.com/liteseed/goar/crypto"
-	"github.com/liteseed/goar/transaction/data_item"
+	"github.com/liteseed/transit/internal/bundler"
 )
 
 // GetDataItemField
@@ -37,14 +38,17 @@ func (srv *Server) GetDataItemField(ctx *gin.Context) {
 		return
 	}
 
-	res, err := srv.bundler.DataItemGet(o.URL, id)
+	res, err := srv.dataItemGet(o)
 	if err != nil {
-		NewError(ctx, http.StatusFailedDependency, err)
+		NewError(ctx, bundlerStatus(err), err)
 		return
 	}
-	d, err := data_item.Decode(res)
+	defer res.Close()
+
+	// Only the header is read for the fields other than data
+	d, err := bundler.ReadHeader(res)
 	if err != nil {
-		NewError(ctx, http.StatusInternalServerError, err)
+		NewError(ctx, readStatus(err), err)
 		return
 	}
 	switch field {
@@ -69,14 +73,14 @@ func (srv *Server) GetDataItemField(ctx *gin.Context) {
 		ctx.JSON(http.StatusOK, d.Target)
 		return
 	case "data":
-		contentType := ctx.Query("mime-type")
-		accept := ctx.Request.Header.Get("accept")
-		b, err := decodeData(d.Data, contentType, accept)
-		if err != nil {
-			NewError(ctx, http.StatusInternalServerError, err)
+		data := bufio.NewReaderSize(res, sniffLen)
+		head, err := data.Peek(sniffLen)
+		if err != nil && !errors.Is(err, io.EOF) {
+			NewError(ctx, readStatus(err), err)
 			return
 		}
-		ctx.Data(http.StatusOK, contentType, b)
+		contentType := dataContentType(head, ctx.Query("mime-type"), ctx.Request.Header.Get("accept"))
+		ctx.DataFromReader(http.StatusOK, -1, contentType, data, nil)
 		return
 	default:
 		NewError(ctx, http.StatusBadRequest, errors.New("field not found"))
@@ -85,27 +89,28 @@ func (srv *Server) GetDataItemField(ctx *gin.Context) {
 
 }
 
-func decodeData(data string, contentType string, accept string) ([]byte, error) {
-	shouldDetect := false
+// sniffLen is the length of the start of the data the mime-type is detected from
+const sniffLen = 3072
 
-	if contentType == "" && accept == "" {
-		contentType = "application/octet-stream"
-		shouldDetect = true
-	} else if contentType == "" {
+// dataContentType is the content type of data starting with head: the mime-type asked for,
+// or else the one accepted, if it is known, detected from head otherwise
+func dataContentType(head []byte, mimeType string, accept string) string {
+	contentType := mimeType
+	if contentType == "" {
 		contentType = accept
 	}
-	mtype := mimetype.Lookup(contentType)
-	if mtype == nil {
-		contentType = "application/octet-stream"
-	}
-	b, err := crypto.Base64URLDecode(data)
-	if err != nil {
-		return nil, err
+	if contentType != "" && mimetype.Lookup(contentType) != nil {
+		return contentType
 	}
+	return mimetype.Detect(head).String()
+}
 
-	detect := mimetype.Detect(b)
-	if shouldDetect && detect != nil {
-		contentType = detect.String()
+// readStatus is the status to answer a data item that could not be read with. Failing to
+// read from the backend is a failed dependency, a malformed data item an internal error.
+func readStatus(err error) int {
+	var e *bundler.Error
+	if errors.As(err, &e) {
+		return http.StatusFailedDependency
 	}
-	return b, nil
+	return http.StatusInternalServerError
 }
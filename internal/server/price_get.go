@@ -6,11 +6,13 @@ import (
 	"strconv"
 
 	"github.com/gin-gonic/gin"
-	"github.com/liteseed/transit/internal/utils"
+	"github.com/liteseed/transit/internal/pricing"
 )
 
 type PriceGetResponse struct {
-	Price   string `json:"price" example:"1000000000000" format:"string"`
+	Price   string `json:"price" example:"1001000000000" format:"string"`
+	Base    string `json:"base" example:"1000000000000" format:"string"`
+	Fee     string `json:"fee" example:"1000000000" format:"string"`
 	Address string `json:"address" example:"Cbj95zDZBBhmyht6iFlEf7xmSCSVZGw436V6HWmm9Ek" format:"string"`
 }
 
@@ -19,11 +21,16 @@ type PriceGetResponse struct {
 // Price godoc
 // @Summary      Get price of upload
 // @Description  Get the current price of data upload using the Liteseed Network.
-// @Description  It returns the price of upload in wei and the address to pay.
+// @Description  It returns the price of upload in winston, split into the network cost and the transit fee, and the address to pay.
+// @Description  The fee depends on the size of the upload and the api key sent in the X-API-Key header.
+// @Description  Every staker asked to hold a replica with the X-Replicas header is paid as an upload of its own.
+// @Description  Uploads routed to an upload service are priced by the service and are not replicated.
 // @Tags         Payment
 // @Accept       json
 // @Produce      json
-// @Param        bytes             path      int  true  "Size of Data" minimum(1) maximum(2147483647)
+// @Param        bytes             path      int     true   "Size of Data" minimum(1) maximum(4294967295)
+// @Param        X-API-Key         header    string  false  "api key"
+// @Param        X-Replicas        header    int     false  "Stakers to hold the data item" minimum(1)
 // @Success      200               {object}  PriceGetResponse
 // @Failure      400,424,500       {object}  HTTPError
 // @Router       /price/{bytes} [get]
@@ -35,16 +42,34 @@ func (srv *Server) PriceGet(ctx *gin.Context) {
 	}
 
 	size, err := strconv.Atoi(b)
-	if err != nil || size <= 0 {
+	if err != nil || size <= 0 || int64(size) > pricing.MaxSize {
 		NewError(ctx, http.StatusBadRequest, errors.New("byte size should be between 1 and 2^32-1"))
 		return
 	}
 
-	p, err := srv.wallet.Client.GetTransactionPrice(size, srv.wallet.Signer.Address)
+	copies, err := srv.copies(ctx)
+	if err != nil {
+		NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	// Upload services hold a data item alone
+	backend := srv.route(size, ctx.GetHeader(HeaderAPIKey))
+	if backend != "" {
+		copies = 1
+	}
+
+	p, err := srv.price(backend, size)
 	if err != nil {
 		NewError(ctx, http.StatusFailedDependency, errors.New("failed to fetch price"))
 		return
 	}
 
-	ctx.JSON(http.StatusOK, &PriceGetResponse{Address: srv.wallet.Signer.Address, Price: utils.CalculatePriceWithFee(p)})
+	q, err := pricing.NewQuote(srv.fee, p, size, ctx.GetHeader(HeaderAPIKey))
+	if err != nil {
+		NewError(ctx, http.StatusFailedDependency, errors.New("failed to fetch price"))
+		return
+	}
+	q = q.Replicate(copies)
+	ctx.JSON(http.StatusOK, &PriceGetResponse{Address: srv.paymentAddress, Price: q.Total().String(), Base: q.Base.String(), Fee: q.Fee.String()})
 }
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/liteseed/transit/internal/database/schema"
+)
+
+// ReplicationPolicy sets how many stakers hold a data item until it is final on Arweave
+type ReplicationPolicy struct {
+	Max  int            // most stakers a data item can be replicated to
+	Keys map[string]int // stakers holding the data items of an api key, unless the request asks otherwise
+}
+
+// DefaultReplicationPolicy holds every data item with a single staker
+var DefaultReplicationPolicy = ReplicationPolicy{Max: 1}
+
+// copies is the number of stakers a request asks to hold its data item, set with the
+// x-replicas header or by its api key
+func (srv *Server) copies(ctx *gin.Context) (int, error) {
+	n := 1
+	if f, ok := srv.replication.Keys[ctx.GetHeader(HeaderAPIKey)]; ok && f > 0 {
+		n = f
+	}
+	if h := ctx.GetHeader(HeaderReplicas); h != "" {
+		v, err := strconv.Atoi(h)
+		if err != nil || v < 1 || v > srv.replication.Max {
+			return 0, fmt.Errorf("replicas should be between 1 and %d", srv.replication.Max)
+		}
+		n = v
+	}
+	return min(n, srv.replication.Max), nil
+}
+
+// dataItemGet streams the data item of an order from its backend or, if that fails, from
+// the stakers holding a replica. Transit serves the data items it bundles itself until their
+// bundle is final. The caller closes the stream.
+func (srv *Server) dataItemGet(o *schema.Order) (io.ReadCloser, error) {
+	if o.Backend == schema.SelfBundled {
+		d, err := srv.database.GetDataItem(o.Id)
+		if err != nil {
+			return nil, err
+		}
+		if d.Raw == nil {
+			return nil, errDataItemFinal
+		}
+		return io.NopCloser(bytes.NewReader(d.Raw)), nil
+	}
+	res, err := srv.backend(o.Backend).DataItemGet(o.URL, o.Id)
+	if err == nil || o.Copies() == 1 {
+		return res, err
+	}
+	replicas, dbErr := srv.database.GetReplicas(o.Id)
+	if dbErr != nil {
+		log.Println(dbErr)
+		return nil, err
+	}
+	for _, r := range replicas {
+		res, replicaErr := srv.bundler.DataItemGet(r.URL, o.Id)
+		if replicaErr == nil {
+			return res, nil
+		}
+		log.Println(replicaErr)
+	}
+	return nil, err
+}
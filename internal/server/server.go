@@ -2,25 +2,48 @@ package server
 
 import (
 	"context"
+	"expvar"
 	"net/http"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/liteseed/goar/wallet"
 	"github.com/liteseed/sdk-go/contract"
 	"github.com/liteseed/transit/internal/bundler"
+	"github.com/liteseed/transit/internal/cron"
 	"github.com/liteseed/transit/internal/database"
+	"github.com/liteseed/transit/internal/pricing"
+	"github.com/liteseed/transit/internal/signer"
 )
 
-const ContentTypeOctetStream = "application/octet-stream"
+const (
+	ContentTypeOctetStream = "application/octet-stream"
+	HeaderAPIKey           = "x-api-key"
+	HeaderReplicas         = "x-replicas"
+
+	DefaultUploadAttempts = 3
+)
 
 type Server struct {
-	bundler  *bundler.Bundler
-	contract *contract.Contract
-	database *database.Database
-	server   *http.Server
-	wallet   *wallet.Wallet
-	version  string
+	adminKey        string
+	backends        map[string]bundler.Bundler
+	bundler         bundler.Bundler
+	contract        *contract.Contract
+	cron            *cron.Cron
+	database        *database.Database
+	fallback        bool
+	fee             pricing.FeePolicy
+	paymentAddress  string
+	paymentDeadline time.Duration
+	prices          *pricing.Cache
+	replication     ReplicationPolicy
+	routes          []bundler.Route
+	server          *http.Server
+	signer          signer.Signer
+	uploadAttempts  int
+	wallet          *wallet.Wallet
+	version         string
 }
 
 // @title          Liteseed API
@@ -31,10 +54,21 @@ type Server struct {
 // @contact.email  support@liteseed.xyz
 // @host           https://api.liteseed.xyz
 func New(port string, version string, options ...func(*Server)) (*Server, error) {
-	s := &Server{version: version}
+	s := &Server{version: version, fee: pricing.Default, uploadAttempts: DefaultUploadAttempts, replication: DefaultReplicationPolicy}
 	for _, o := range options {
 		o(s)
 	}
+	if s.wallet != nil && s.wallet.Signer != nil {
+		if s.signer == nil {
+			s.signer = signer.FromSigner(s.wallet.Signer)
+		}
+		if s.paymentAddress == "" {
+			s.paymentAddress = s.wallet.Signer.Address
+		}
+	}
+	if s.prices == nil && s.wallet != nil {
+		s.prices = pricing.NewCache(s.wallet.Client, pricing.DefaultCacheConfig)
+	}
 	engine := gin.New()
 	engine.Use(cors.Default())
 	engine.Use(gin.Recovery())
@@ -48,6 +82,23 @@ func New(port string, version string, options ...func(*Server)) (*Server, error)
 	engine.PUT("/tx/:id/:payment_id", s.DataItemPut)
 	engine.POST("/data", s.DataPost)
 
+	v2 := engine.Group("/v2")
+	v2.GET("/tx/:id/status", s.DataItemStatusGetV2)
+
+	admin := engine.Group("/admin", s.adminAuth)
+	admin.GET("/metrics", gin.WrapH(expvar.Handler()))
+	admin.GET("/jobs", s.AdminJobsGet)
+	admin.POST("/jobs/:name", s.AdminJobPost)
+	admin.GET("/wallet", s.AdminWalletGet)
+	admin.GET("/gateways", s.AdminGatewaysGet)
+	admin.GET("/stakers", s.AdminStakersGet)
+	admin.GET("/refunds", s.AdminRefundsGet)
+	admin.POST("/refunds/:id/:action", s.AdminRefundPost)
+	admin.GET("/ledger/daily", s.AdminLedgerDailyGet)
+	admin.GET("/ledger/stakers", s.AdminLedgerStakersGet)
+	admin.GET("/ledger/export", s.AdminLedgerExportGet)
+	admin.GET("/discrepancies", s.AdminDiscrepanciesGet)
+
 	s.server = &http.Server{
 		Addr:    port,
 		Handler: engine,
@@ -55,7 +106,24 @@ func New(port string, version string, options ...func(*Server)) (*Server, error)
 	return s, nil
 }
 
-func WithBundler(b *bundler.Bundler) func(*Server) {
+// WithAdminKey sets the bearer token required by the /admin routes
+func WithAdminKey(key string) func(*Server) {
+	return func(srv *Server) {
+		srv.adminKey = key
+	}
+}
+
+// WithBackends sets the upload services by name and the routes sending uploads to them.
+// Uploads no route matches go to the stakers of the contract.
+func WithBackends(backends map[string]bundler.Bundler, routes []bundler.Route) func(*Server) {
+	return func(srv *Server) {
+		srv.backends = backends
+		srv.routes = routes
+	}
+}
+
+// WithBundler sets the backend of the stakers of the contract
+func WithBundler(b bundler.Bundler) func(*Server) {
 	return func(srv *Server) {
 		srv.bundler = b
 	}
@@ -67,12 +135,79 @@ func WithContracts(contract *contract.Contract) func(*Server) {
 	}
 }
 
+func WithCron(c *cron.Cron) func(*Server) {
+	return func(srv *Server) {
+		srv.cron = c
+	}
+}
+
 func WithDatabase(db *database.Database) func(*Server) {
 	return func(srv *Server) {
 		srv.database = db
 	}
 }
 
+// WithFallback makes transit keep the data items no staker takes and bundle them itself
+func WithFallback(enabled bool) func(*Server) {
+	return func(srv *Server) {
+		srv.fallback = enabled
+	}
+}
+
+func WithFeePolicy(p pricing.FeePolicy) func(*Server) {
+	return func(srv *Server) {
+		srv.fee = p
+	}
+}
+
+// WithPaymentAddress sets the address users pay to, the one of the payout key.
+// It defaults to the address of the wallet.
+func WithPaymentAddress(address string) func(*Server) {
+	return func(srv *Server) {
+		srv.paymentAddress = address
+	}
+}
+
+// WithPaymentDeadline sets how long an order may stay unpaid before payments are rejected
+func WithPaymentDeadline(d time.Duration) func(*Server) {
+	return func(srv *Server) {
+		srv.paymentDeadline = d
+	}
+}
+
+// WithPriceCache sets the cache network prices are read from. It defaults to a cache of the gateway of the wallet.
+func WithPriceCache(p *pricing.Cache) func(*Server) {
+	return func(srv *Server) {
+		srv.prices = p
+	}
+}
+
+// WithReplicationPolicy sets how many stakers may hold a data item. A Max below 1 keeps the default.
+func WithReplicationPolicy(p ReplicationPolicy) func(*Server) {
+	return func(srv *Server) {
+		if p.Max < 1 {
+			p.Max = DefaultReplicationPolicy.Max
+		}
+		srv.replication = p
+	}
+}
+
+// WithSigner sets the data key, which signs the data posted unsigned. It defaults to the key of the wallet.
+func WithSigner(s signer.Signer) func(*Server) {
+	return func(srv *Server) {
+		srv.signer = s
+	}
+}
+
+// WithUploadAttempts sets how many stakers an upload is sent to before it fails
+func WithUploadAttempts(n int) func(*Server) {
+	return func(srv *Server) {
+		if n > 0 {
+			srv.uploadAttempts = n
+		}
+	}
+}
+
 func WithWallet(w *wallet.Wallet) func(*Server) {
 	return func(srv *Server) {
 		srv.wallet = w
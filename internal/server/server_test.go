@@ -4,22 +4,34 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"regexp"
 	"strconv"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/liteseed/aogo"
+	"github.com/liteseed/goar/crypto"
 	"github.com/liteseed/goar/wallet"
 	"github.com/liteseed/sdk-go/contract"
 	"github.com/liteseed/transit/internal/bundler"
+	"github.com/liteseed/transit/internal/cron"
 	"github.com/liteseed/transit/internal/database/schema"
+	"github.com/liteseed/transit/internal/pricing"
+	"github.com/liteseed/transit/internal/utils"
 	"github.com/liteseed/transit/test"
 	"github.com/stretchr/testify/assert"
 )
 
+// liteseed is a bundler client allowed to reach the stakers served by the tests
+func liteseed() *bundler.Liteseed {
+	return bundler.NewLiteseed(bundler.WithURLPolicy(utils.DevURLPolicy))
+}
+
 func TestNewServer(t *testing.T) {
 	srv, err := New(":8080", "test")
 	assert.NoError(t, err)
@@ -58,7 +70,47 @@ func TestPriceGet(t *testing.T) {
 		req, _ := http.NewRequest("GET", "/price/1000", nil)
 		srv.server.Handler.ServeHTTP(rcd, req)
 		assert.Equal(t, http.StatusOK, rcd.Code)
-		assert.Equal(t, `{"price":"1001","address":"3XTR7MsJUD9LoaiFRdWswzX1X5BR7AQdl1x2v2zIVck"}`, rcd.Body.String())
+		assert.Equal(t, `{"price":"1001","base":"1000","fee":"1","address":"3XTR7MsJUD9LoaiFRdWswzX1X5BR7AQdl1x2v2zIVck"}`, rcd.Body.String())
+	})
+
+	t.Run("Success:Internal:/price/1000", func(t *testing.T) {
+		fee, err := pricing.New(pricing.Config{Internal: []string{"internal"}})
+		assert.NoError(t, err)
+		srv, err := New(":8080", "test", WithWallet(w), WithFeePolicy(fee))
+		assert.NoError(t, err)
+
+		rcd := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/price/1000", nil)
+		req.Header.Set("x-api-key", "internal")
+		srv.server.Handler.ServeHTTP(rcd, req)
+
+		assert.Equal(t, http.StatusOK, rcd.Code)
+		assert.Equal(t, `{"price":"1000","base":"1000","fee":"0","address":"3XTR7MsJUD9LoaiFRdWswzX1X5BR7AQdl1x2v2zIVck"}`, rcd.Body.String())
+	})
+
+	t.Run("Success:PaymentAddress:/price/1000", func(t *testing.T) {
+		srv, err := New(":8080", "test", WithWallet(w), WithPaymentAddress("payout"))
+		assert.NoError(t, err)
+
+		rcd := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/price/1000", nil)
+		srv.server.Handler.ServeHTTP(rcd, req)
+
+		assert.Equal(t, http.StatusOK, rcd.Code)
+		assert.Equal(t, `{"price":"1001","base":"1000","fee":"1","address":"payout"}`, rcd.Body.String())
+	})
+
+	t.Run("Success:Replicas:/price/1000", func(t *testing.T) {
+		srv, err := New(":8080", "test", WithWallet(w), WithReplicationPolicy(ReplicationPolicy{Max: 3}))
+		assert.NoError(t, err)
+
+		rcd := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/price/1000", nil)
+		req.Header.Set("x-replicas", "3")
+		srv.server.Handler.ServeHTTP(rcd, req)
+
+		assert.Equal(t, http.StatusOK, rcd.Code)
+		assert.Equal(t, `{"price":"3003","base":"3000","fee":"3","address":"3XTR7MsJUD9LoaiFRdWswzX1X5BR7AQdl1x2v2zIVck"}`, rcd.Body.String())
 	})
 
 	t.Run("Fail:Invalid:/price/invalid", func(t *testing.T) {
@@ -83,6 +135,14 @@ func TestPriceGet(t *testing.T) {
 		assert.Equal(t, http.StatusBadRequest, rcd.Code)
 		assert.Equal(t, `{"code":400,"message":"byte size should be between 1 and 2^32-1"}`, rcd.Body.String())
 	})
+
+	t.Run("Fail:Invalid:/price/4294967296", func(t *testing.T) {
+		rcd := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/price/4294967296", nil)
+		srv.server.Handler.ServeHTTP(rcd, req)
+		assert.Equal(t, http.StatusBadRequest, rcd.Code)
+		assert.Equal(t, `{"code":400,"message":"byte size should be between 1 and 2^32-1"}`, rcd.Body.String())
+	})
 	t.Run("Fail:Gateway", func(t *testing.T) {
 		w, err := wallet.FromPath("../../test/signer.json", "")
 		assert.NoError(t, err)
@@ -103,7 +163,7 @@ func TestDataItemGet(t *testing.T) {
 
 	mock, db := test.Database()
 
-	srv, _ := New(":8080", "test", WithDatabase(db), WithBundler(bundler.New()))
+	srv, _ := New(":8080", "test", WithDatabase(db), WithBundler(liteseed()))
 
 	t.Run("Success", func(t *testing.T) {
 		mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"Id", "URL"}).AddRow("1", b.URL[7:]))
@@ -116,6 +176,50 @@ func TestDataItemGet(t *testing.T) {
 		assert.Equal(t, d.Raw, rcd.Body.Bytes())
 	})
 
+	t.Run("Success:Field", func(t *testing.T) {
+		data, err := crypto.Base64URLDecode(d.Data)
+		assert.NoError(t, err)
+		for field, expected := range map[string]string{
+			"owner":     `"` + d.Owner + `"`,
+			"signature": `"` + d.Signature + `"`,
+			"target":    `""`,
+			"data":      string(data),
+		} {
+			mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"Id", "URL"}).AddRow("1", b.URL[7:]))
+
+			rcd := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/tx/1/"+field, nil)
+			req.Header.Set("accept", "text/plain")
+			srv.server.Handler.ServeHTTP(rcd, req)
+
+			assert.Equal(t, http.StatusOK, rcd.Code, field)
+			assert.Equal(t, expected, rcd.Body.String(), field)
+		}
+	})
+
+	t.Run("Fail:Bundler", func(t *testing.T) {
+		staker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/tx/3" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer staker.Close()
+
+		mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"Id", "URL"}).AddRow("3", staker.URL[7:]))
+		rcd := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/tx/3", nil)
+		srv.server.Handler.ServeHTTP(rcd, req)
+		assert.Equal(t, http.StatusNotFound, rcd.Code)
+
+		mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"Id", "URL"}).AddRow("4", staker.URL[7:]))
+		rcd = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/tx/4", nil)
+		srv.server.Handler.ServeHTTP(rcd, req)
+		assert.Equal(t, http.StatusFailedDependency, rcd.Code)
+	})
+
 	t.Run("Fail:NotFound", func(t *testing.T) {
 		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "orders" WHERE "orders"."id" = $1 ORDER BY "orders"."id" LIMIT $2`)).WithArgs("2", 1).WillReturnError(errors.New("not found"))
 
@@ -128,6 +232,10 @@ func TestDataItemGet(t *testing.T) {
 	})
 }
 
+func expectReports(mock sqlmock.Sqlmock, order string, kind string, staker string) {
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "reports"`)).WithArgs(order, kind, staker, "", "", "created", 0, "", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+}
+
 func TestDataItemPost(t *testing.T) {
 	g := test.Gateway()
 	w := test.Wallet(g.URL)
@@ -154,18 +262,21 @@ func TestDataItemPost(t *testing.T) {
 
 	c := contract.Custom(ao, "process", w.Signer)
 
-	srv, err := New(":8000", "test", WithBundler(bundler.New()), WithDatabase(db), WithContracts(c), WithWallet(w))
+	srv, err := New(":8000", "test", WithBundler(liteseed()), WithDatabase(db), WithContracts(c), WithWallet(w))
 	assert.NoError(t, err)
 
 	t.Run("Success", func(t *testing.T) {
 		mock.ExpectBegin()
-		mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "orders" ("id","transaction_id","url","address","status","payment","size") VALUES ($1,$2,$3,$4,$5,$6,$7)`)).WithArgs(d.ID, "", b.URL[7:], "staker", "created", "unpaid", 1047).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "orders" ("id","transaction_id","url","address","status","payment","size","api_key","owner","price","received","deadline_height","block_height","block_indep_hash","replicas","backend","discovered","payout_id") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18) RETURNING "created_at"`)).WithArgs(d.ID, "", b.URL[7:], "staker", "created", "unpaid", 1047, "key", "3XTR7MsJUD9LoaiFRdWswzX1X5BR7AQdl1x2v2zIVck", "", "", 0, 0, "", 1, "", false, "").WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(time.Now()))
+		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "attempts" ("order_id","staker","url","error","created_at") VALUES ($1,$2,$3,$4,$5) RETURNING "id"`)).WithArgs(d.ID, "staker", b.URL[7:], "", sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		expectReports(mock, d.ID, "delivered", "staker")
 		mock.ExpectCommit()
 
 		rcd := httptest.NewRecorder()
 		req, _ := http.NewRequest("POST", "/tx", bytes.NewBuffer(d.Raw))
 		req.Header.Set("content-type", "application/octet-stream")
 		req.Header.Set("content-length", strconv.Itoa(len(d.Raw)))
+		req.Header.Set("x-api-key", "key")
 
 		srv.server.Handler.ServeHTTP(rcd, req)
 
@@ -173,6 +284,194 @@ func TestDataItemPost(t *testing.T) {
 		assert.Equal(t, fmt.Sprintf("{\"id\":\"%s\",\"owner\":\"3XTR7MsJUD9LoaiFRdWswzX1X5BR7AQdl1x2v2zIVck\",\"dataCaches\":[\"localhost\"],\"deadlineHeight\":0,\"fastFinalityIndexes\":[\"localhost\"],\"version\":\"1\"}", d.ID), rcd.Body.String())
 	})
 
+	t.Run("Success:Failover", func(t *testing.T) {
+		down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		defer down.Close()
+
+		// The contract assigns the staker that is down first
+		var calls atomic.Int32
+		cu := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, url := "staker", b.URL[7:]
+			if calls.Add(1) == 1 {
+				id, url = "down", down.URL[7:]
+			}
+			_, err := w.Write([]byte(fmt.Sprintf(`{"Messages":[{"Data":"{\"id\":\"%s\",\"reputation\":0,\"url\":\"%s\"}"}]}`, id, url)))
+			assert.NoError(t, err)
+		}))
+		defer cu.Close()
+
+		ao, err := aogo.New(aogo.WthCU(cu.URL), aogo.WthMU(mu.URL))
+		assert.NoError(t, err)
+		srv, err := New(":8000", "test", WithBundler(liteseed()), WithDatabase(db), WithContracts(contract.Custom(ao, "process", w.Signer)), WithWallet(w))
+		assert.NoError(t, err)
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "orders"`)).WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(time.Now()))
+		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "attempts" ("order_id","staker","url","error","created_at") VALUES ($1,$2,$3,$4,$5),($6,$7,$8,$9,$10) RETURNING "id"`)).
+			WithArgs(d.ID, "down", down.URL[7:], fmt.Sprintf("POST http://%s/tx: 502: ", down.URL[7:]), sqlmock.AnyArg(), d.ID, "staker", b.URL[7:], "", sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+		// The staker that failed is reported along with the one that took the data item
+		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "reports"`)).
+			WithArgs(d.ID, "undelivered", "down", "", fmt.Sprintf("POST http://%s/tx: 502: ", down.URL[7:]), "created", 0, "", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), d.ID, "delivered", "staker", "", "", "created", 0, "", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+		mock.ExpectCommit()
+
+		rcd := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/tx", bytes.NewBuffer(d.Raw))
+		req.Header.Set("content-type", "application/octet-stream")
+		req.Header.Set("content-length", strconv.Itoa(len(d.Raw)))
+		srv.server.Handler.ServeHTTP(rcd, req)
+
+		assert.Equal(t, http.StatusCreated, rcd.Code)
+		assert.Equal(t, int32(2), calls.Load())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Success:Replicas", func(t *testing.T) {
+		var calls atomic.Int32
+		cu := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, err := w.Write([]byte(fmt.Sprintf(`{"Messages":[{"Data":"{\"id\":\"staker-%d\",\"reputation\":0,\"url\":\"%s\"}"}]}`, calls.Add(1), b.URL[7:])))
+			assert.NoError(t, err)
+		}))
+		defer cu.Close()
+
+		ao, err := aogo.New(aogo.WthCU(cu.URL), aogo.WthMU(mu.URL))
+		assert.NoError(t, err)
+		srv, err := New(":8000", "test", WithBundler(liteseed()), WithDatabase(db), WithContracts(contract.Custom(ao, "process", w.Signer)), WithWallet(w), WithReplicationPolicy(ReplicationPolicy{Max: 3}))
+		assert.NoError(t, err)
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "orders"`)).WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(time.Now()))
+		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "attempts"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "replicas" ("order_id","staker","url","transaction_id","status","created_at") VALUES ($1,$2,$3,$4,$5,$6) RETURNING "id"`)).
+			WithArgs(d.ID, "staker-2", b.URL[7:], "", "created", sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		expectReports(mock, d.ID, "delivered", "staker-1")
+		mock.ExpectCommit()
+
+		rcd := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/tx", bytes.NewBuffer(d.Raw))
+		req.Header.Set("content-type", "application/octet-stream")
+		req.Header.Set("content-length", strconv.Itoa(len(d.Raw)))
+		req.Header.Set("x-replicas", "2")
+		srv.server.Handler.ServeHTTP(rcd, req)
+
+		assert.Equal(t, http.StatusCreated, rcd.Code)
+		assert.Equal(t, "2", rcd.Header().Get("x-replicas"))
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		rcd = httptest.NewRecorder()
+		req, _ = http.NewRequest("POST", "/tx", bytes.NewBuffer(d.Raw))
+		req.Header.Set("content-type", "application/octet-stream")
+		req.Header.Set("content-length", strconv.Itoa(len(d.Raw)))
+		req.Header.Set("x-replicas", "4")
+		srv.server.Handler.ServeHTTP(rcd, req)
+
+		assert.Equal(t, http.StatusBadRequest, rcd.Code)
+		assert.Equal(t, `{"code":400,"message":"replicas should be between 1 and 3"}`, rcd.Body.String())
+	})
+
+	t.Run("Success:Replicas:No Staker Left", func(t *testing.T) {
+		// The contract has no other staker than the one holding the data item
+		var calls atomic.Int32
+		cu := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			_, err := w.Write([]byte(fmt.Sprintf(`{"Messages":[{"Data":"{\"id\":\"staker\",\"reputation\":0,\"url\":\"%s\"}"}]}`, b.URL[7:])))
+			assert.NoError(t, err)
+		}))
+		defer cu.Close()
+
+		ao, err := aogo.New(aogo.WthCU(cu.URL), aogo.WthMU(mu.URL))
+		assert.NoError(t, err)
+		srv, err := New(":8000", "test", WithBundler(liteseed()), WithDatabase(db), WithContracts(contract.Custom(ao, "process", w.Signer)), WithWallet(w), WithReplicationPolicy(ReplicationPolicy{Max: 3}))
+		assert.NoError(t, err)
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "orders"`)).WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(time.Now()))
+		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "attempts"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		expectReports(mock, d.ID, "delivered", "staker")
+		mock.ExpectCommit()
+
+		rcd := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/tx", bytes.NewBuffer(d.Raw))
+		req.Header.Set("content-type", "application/octet-stream")
+		req.Header.Set("content-length", strconv.Itoa(len(d.Raw)))
+		req.Header.Set("x-replicas", "3")
+		srv.server.Handler.ServeHTTP(rcd, req)
+
+		// No more stakers are reserved once the contract gives the same one back
+		assert.Equal(t, http.StatusCreated, rcd.Code)
+		assert.Equal(t, "1", rcd.Header().Get("x-replicas"))
+		assert.Equal(t, int32(2), calls.Load())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Success:Routed", func(t *testing.T) {
+		fake := bundler.NewFake()
+		routes := []bundler.Route{{Backend: "fake", ApiKeys: []string{"routed"}}}
+		srv, err := New(":8000", "test", WithBundler(liteseed()), WithBackends(map[string]bundler.Bundler{"fake": fake}, routes), WithDatabase(db), WithContracts(c), WithWallet(w))
+		assert.NoError(t, err)
+
+		// The upload service holds the data item alone, no staker is reserved in the contract
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "orders"`)).
+			WithArgs(d.ID, "", "", "", "created", "unpaid", 1047, "routed", "3XTR7MsJUD9LoaiFRdWswzX1X5BR7AQdl1x2v2zIVck", "", "", 0, 0, "", 1, "fake", false, "").
+			WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(time.Now()))
+		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "attempts"`)).WithArgs(d.ID, "fake", "", "", sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+
+		rcd := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/tx", bytes.NewBuffer(d.Raw))
+		req.Header.Set("content-type", "application/octet-stream")
+		req.Header.Set("content-length", strconv.Itoa(len(d.Raw)))
+		req.Header.Set("x-api-key", "routed")
+		req.Header.Set("x-replicas", "1")
+		srv.server.Handler.ServeHTTP(rcd, req)
+
+		assert.Equal(t, http.StatusCreated, rcd.Code)
+		assert.Equal(t, "1", rcd.Header().Get("x-replicas"))
+		assert.NoError(t, mock.ExpectationsWereMet())
+		res, err := fake.DataItemGet("", d.ID)
+		assert.NoError(t, err)
+		raw, err := io.ReadAll(res)
+		assert.NoError(t, err)
+		assert.Equal(t, d.Raw, raw)
+	})
+
+	t.Run("Success:Fallback", func(t *testing.T) {
+		// The contract can not assign a staker
+		cu := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer cu.Close()
+
+		ao, err := aogo.New(aogo.WthCU(cu.URL), aogo.WthMU(mu.URL))
+		assert.NoError(t, err)
+		srv, err := New(":8000", "test", WithBundler(liteseed()), WithDatabase(db), WithContracts(contract.Custom(ao, "process", w.Signer)), WithWallet(w), WithFallback(true))
+		assert.NoError(t, err)
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "orders"`)).
+			WithArgs(d.ID, "", "", "", "created", "unpaid", 1047, "", "3XTR7MsJUD9LoaiFRdWswzX1X5BR7AQdl1x2v2zIVck", "", "", 0, 0, "", 1, "self", false, "").
+			WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(time.Now()))
+		mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "data_items" ("id","raw","bundle_id","created_at") VALUES ($1,$2,$3,$4)`)).
+			WithArgs(d.ID, d.Raw, "", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		rcd := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/tx", bytes.NewBuffer(d.Raw))
+		req.Header.Set("content-type", "application/octet-stream")
+		req.Header.Set("content-length", strconv.Itoa(len(d.Raw)))
+		srv.server.Handler.ServeHTTP(rcd, req)
+
+		assert.Equal(t, http.StatusCreated, rcd.Code)
+		assert.Contains(t, rcd.Body.String(), fmt.Sprintf(`"id":"%s","owner":"%s"`, d.ID, w.Signer.Address))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
 	t.Run("Missing", func(t *testing.T) {
 		rcd := httptest.NewRecorder()
 		req, _ := http.NewRequest("POST", "/tx", bytes.NewBuffer(d.Raw))
@@ -249,12 +548,50 @@ func TestDataItemPost(t *testing.T) {
 	})
 }
 
+func TestDataItemStatusGet(t *testing.T) {
+	b := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := w.Write([]byte("created"))
+		assert.NoError(t, err)
+	}))
+	defer b.Close()
+
+	mock, db := test.Database()
+	srv, err := New(":8000", "test", WithBundler(liteseed()), WithDatabase(db))
+	assert.NoError(t, err)
+
+	t.Run("Success", func(t *testing.T) {
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "orders" WHERE id = $1`)).WithArgs("dataitem", 1).WillReturnRows(sqlmock.NewRows([]string{"id", "url", "status", "payment", "price", "received"}).AddRow("dataitem", b.URL[7:], schema.Queued, schema.Partial, "10010", "6000"))
+
+		rcd := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/tx/dataitem/status", nil)
+
+		srv.server.Handler.ServeHTTP(rcd, req)
+
+		assert.Equal(t, http.StatusOK, rcd.Code)
+		assert.Equal(t, `"created"`, rcd.Body.String())
+	})
+
+	t.Run("Success:V2:Partial", func(t *testing.T) {
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "orders" WHERE id = $1`)).WithArgs("dataitem", 1).WillReturnRows(sqlmock.NewRows([]string{"id", "url", "status", "payment", "price", "received"}).AddRow("dataitem", b.URL[7:], schema.Queued, schema.Partial, "10010", "6000"))
+
+		rcd := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/v2/tx/dataitem/status", nil)
+
+		srv.server.Handler.ServeHTTP(rcd, req)
+
+		assert.Equal(t, http.StatusOK, rcd.Code)
+		assert.Equal(t, `{"status":"created","payment":"partial","price":"10010","received":"6000","shortfall":"4010"}`, rcd.Body.String())
+	})
+}
+
 func TestDataItemPut(t *testing.T) {
 	mock, db := test.Database()
-	srv, err := New(":8000", "test", WithDatabase(db))
+	srv, err := New(":8000", "test", WithDatabase(db), WithPaymentDeadline(time.Hour))
 	assert.NoError(t, err)
 
 	t.Run("Success", func(t *testing.T) {
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "orders" WHERE id = $1`)).WithArgs("dataitem", 1).WillReturnRows(sqlmock.NewRows([]string{"id", "status", "payment", "created_at"}).AddRow("dataitem", schema.Created, schema.Unpaid, time.Now()))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "transfers" WHERE id = $1`)).WithArgs("transaction", 1).WillReturnRows(sqlmock.NewRows([]string{"id"}))
 		mock.ExpectBegin()
 		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "orders" SET "transaction_id"=$1,"status"=$2 WHERE id = $3`)).WithArgs("transaction", schema.Queued, "dataitem").WillReturnResult(sqlmock.NewResult(1, 1))
 		mock.ExpectCommit()
@@ -267,4 +604,182 @@ func TestDataItemPut(t *testing.T) {
 		assert.Equal(t, http.StatusAccepted, rcd.Code)
 		assert.Equal(t, "{\"id\":\"dataitem\",\"paymentId\":\"transaction\"}", rcd.Body.String())
 	})
+
+	t.Run("Payment Used", func(t *testing.T) {
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "orders" WHERE id = $1`)).WithArgs("dataitem", 1).WillReturnRows(sqlmock.NewRows([]string{"id", "status", "payment", "created_at"}).AddRow("dataitem", schema.Created, schema.Unpaid, time.Now()))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "transfers" WHERE id = $1`)).WithArgs("transaction", 1).WillReturnRows(sqlmock.NewRows([]string{"id", "quantity", "allocated"}).AddRow("transaction", "1000", "1000"))
+
+		rcd := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", "/tx/dataitem/transaction", nil)
+
+		srv.server.Handler.ServeHTTP(rcd, req)
+
+		assert.Equal(t, http.StatusConflict, rcd.Code)
+		assert.Equal(t, "{\"code\":409,\"message\":\"payment already used\"}", rcd.Body.String())
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "orders" WHERE id = $1`)).WithArgs("dataitem", 1).WillReturnRows(sqlmock.NewRows([]string{"id", "status", "payment", "created_at"}).AddRow("dataitem", schema.Expired, schema.Unpaid, time.Now()))
+
+		rcd := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", "/tx/dataitem/transaction", nil)
+
+		srv.server.Handler.ServeHTTP(rcd, req)
+
+		assert.Equal(t, http.StatusGone, rcd.Code)
+		assert.Equal(t, "{\"code\":410,\"message\":\"payment deadline expired\"}", rcd.Body.String())
+	})
+
+	t.Run("Past Deadline", func(t *testing.T) {
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "orders" WHERE id = $1`)).WithArgs("dataitem", 1).WillReturnRows(sqlmock.NewRows([]string{"id", "status", "payment", "created_at"}).AddRow("dataitem", schema.Created, schema.Unpaid, time.Now().Add(-2*time.Hour)))
+
+		rcd := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", "/tx/dataitem/transaction", nil)
+
+		srv.server.Handler.ServeHTTP(rcd, req)
+
+		assert.Equal(t, http.StatusGone, rcd.Code)
+	})
+}
+
+func TestAdminJobPost(t *testing.T) {
+	crn, err := cron.New()
+	assert.NoError(t, err)
+
+	t.Run("Disabled", func(t *testing.T) {
+		srv, err := New(":8000", "test", WithCron(crn))
+		assert.NoError(t, err)
+
+		rcd := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/admin/jobs/"+cron.JobSendPayments, nil)
+		srv.server.Handler.ServeHTTP(rcd, req)
+
+		assert.Equal(t, http.StatusNotFound, rcd.Code)
+		assert.Equal(t, `{"code":404,"message":"admin api is disabled"}`, rcd.Body.String())
+	})
+
+	srv, err := New(":8000", "test", WithAdminKey("secret"), WithCron(crn))
+	assert.NoError(t, err)
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		rcd := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/admin/jobs/"+cron.JobSendPayments, nil)
+		req.Header.Set("authorization", "Bearer wrong")
+		srv.server.Handler.ServeHTTP(rcd, req)
+
+		assert.Equal(t, http.StatusUnauthorized, rcd.Code)
+		assert.Equal(t, `{"code":401,"message":"invalid admin key"}`, rcd.Body.String())
+	})
+
+	t.Run("Not Found", func(t *testing.T) {
+		rcd := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/admin/jobs/unknown", nil)
+		req.Header.Set("authorization", "Bearer secret")
+		srv.server.Handler.ServeHTTP(rcd, req)
+
+		assert.Equal(t, http.StatusNotFound, rcd.Code)
+		assert.Equal(t, `{"code":404,"message":"job not found"}`, rcd.Body.String())
+	})
+}
+
+func TestAdminMetricsGet(t *testing.T) {
+	srv, err := New(":8000", "test", WithAdminKey("secret"))
+	assert.NoError(t, err)
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		rcd := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/admin/metrics", nil)
+		srv.server.Handler.ServeHTTP(rcd, req)
+
+		assert.Equal(t, http.StatusUnauthorized, rcd.Code)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		rcd := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/admin/metrics", nil)
+		req.Header.Set("authorization", "Bearer secret")
+		srv.server.Handler.ServeHTTP(rcd, req)
+
+		assert.Equal(t, http.StatusOK, rcd.Code)
+		assert.Contains(t, rcd.Body.String(), `"memstats"`)
+	})
+}
+
+func TestAdminRefundPost(t *testing.T) {
+	mock, db := test.Database()
+	srv, err := New(":8000", "test", WithAdminKey("secret"), WithDatabase(db))
+	assert.NoError(t, err)
+
+	t.Run("Approve", func(t *testing.T) {
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "refunds" WHERE id = $1`)).WithArgs(1, 1).WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(1, "pending"))
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "refunds" SET "status"=$1,"updated_at"=$2 WHERE id = $3 AND status IN ($4,$5)`)).WithArgs("approved", sqlmock.AnyArg(), 1, "pending", "held").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "refunds" WHERE id = $1`)).WithArgs(1, 1).WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(1, "approved"))
+
+		rcd := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/admin/refunds/1/approve", nil)
+		req.Header.Set("authorization", "Bearer secret")
+		srv.server.Handler.ServeHTTP(rcd, req)
+
+		assert.Equal(t, http.StatusOK, rcd.Code)
+		assert.Contains(t, rcd.Body.String(), `"status":"approved"`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Already Sent", func(t *testing.T) {
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "refunds" WHERE id = $1`)).WithArgs(1, 1).WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(1, "sent"))
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "refunds" SET "status"=$1,"updated_at"=$2 WHERE id = $3 AND status IN ($4,$5,$6)`)).WithArgs("denied", sqlmock.AnyArg(), 1, "pending", "held", "approved").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+
+		rcd := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/admin/refunds/1/deny", nil)
+		req.Header.Set("authorization", "Bearer secret")
+		srv.server.Handler.ServeHTTP(rcd, req)
+
+		assert.Equal(t, http.StatusConflict, rcd.Code)
+		assert.Equal(t, `{"code":409,"message":"refund can not change to this status"}`, rcd.Body.String())
+	})
+
+	t.Run("Unknown Action", func(t *testing.T) {
+		rcd := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/admin/refunds/1/send", nil)
+		req.Header.Set("authorization", "Bearer secret")
+		srv.server.Handler.ServeHTTP(rcd, req)
+
+		assert.Equal(t, http.StatusBadRequest, rcd.Code)
+	})
+}
+
+func TestAdminLedgerExportGet(t *testing.T) {
+	mock, db := test.Database()
+	srv, err := New(":8000", "test", WithAdminKey("secret"), WithDatabase(db))
+	assert.NoError(t, err)
+
+	t.Run("Success", func(t *testing.T) {
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "entries" WHERE created_at >= $1 AND created_at < $2 ORDER BY created_at, id`)).
+			WithArgs(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "kind", "debit", "credit", "amount", "counterparty", "order_id", "transaction_id", "created_at"}).
+				AddRow(1, "payout", "payers", "wallet", "1000", "staker", "dataitem", "payout", time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)))
+
+		rcd := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/admin/ledger/export?from=2024-01-01&to=2024-01-31", nil)
+		req.Header.Set("authorization", "Bearer secret")
+		srv.server.Handler.ServeHTTP(rcd, req)
+
+		assert.Equal(t, http.StatusOK, rcd.Code)
+		assert.Equal(t, "text/csv", rcd.Header().Get("content-type"))
+		assert.Contains(t, rcd.Body.String(), "1,2024-01-02T00:00:00Z,payout,payers,wallet,1000,staker,dataitem,payout\n")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Invalid Date", func(t *testing.T) {
+		rcd := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/admin/ledger/export?from=yesterday", nil)
+		req.Header.Set("authorization", "Bearer secret")
+		srv.server.Handler.ServeHTTP(rcd, req)
+
+		assert.Equal(t, http.StatusBadRequest, rcd.Code)
+	})
 }
@@ -0,0 +1,254 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/liteseed/sdk-go/contract"
+	"github.com/liteseed/transit/internal/bundler"
+	"github.com/liteseed/transit/internal/database/schema"
+)
+
+var errNoStaker = errors.New("no staker accepted the upload")
+
+// upload is a data item held by one or more stakers, or by an upload service
+type upload struct {
+	backend  string             // upload service holding the data item, empty for the stakers
+	stakers  []*contract.Staker // the staker assigned to the order first, then those holding a replica
+	response *bundler.DataItemPostResponse
+	attempts []schema.Attempt // every staker the data item was sent to
+	skipped  []string         // stakers reserved once one held the data item and skipped for their poor health
+	staked   bool             // sent to the stakers of the contract, which are reported to the AO process
+	item     *schema.DataItem // the data item, when transit bundles it itself
+}
+
+// replicas of the data item, held by the stakers after the assigned one
+func (u *upload) replicas(id string) []schema.Replica {
+	replicas := []schema.Replica{}
+	for i, s := range u.stakers {
+		if i > 0 {
+			replicas = append(replicas, schema.Replica{OrderId: id, Staker: s.ID, URL: s.URL, Status: schema.Created})
+		}
+	}
+	return replicas
+}
+
+// copies is the number of stakers holding the data item, one for an upload service
+func (u *upload) copies() int {
+	return max(len(u.stakers), 1)
+}
+
+// reports tell the AO process how the upload went with the stakers it reserved: every
+// staker that failed or was skipped, and the assigned one taking it
+func (u *upload) reports(id string) []schema.Report {
+	reports := []schema.Report{}
+	for _, a := range u.attempts {
+		if a.Error != "" {
+			reports = append(reports, schema.Report{OrderId: id, Kind: schema.Undelivered, Staker: a.Staker, Reason: a.Error})
+		}
+	}
+	for _, s := range u.skipped {
+		reports = append(reports, schema.Report{OrderId: id, Kind: schema.Undelivered, Staker: s, Reason: "skipped: unhealthy"})
+	}
+	if len(u.stakers) > 0 {
+		reports = append(reports, schema.Report{OrderId: id, Kind: schema.Delivered, Staker: u.stakers[0].ID})
+	}
+	return reports
+}
+
+// order creates the order of the data item
+func (u *upload) order(id string, size int, apiKey string) *schema.Order {
+	o := &schema.Order{
+		Id:             id,
+		Payment:        schema.Unpaid,
+		Status:         schema.Created,
+		Size:           size,
+		ApiKey:         apiKey,
+		DeadlineHeight: u.response.DeadlineHeight,
+		Replicas:       u.copies(),
+		Backend:        u.backend,
+	}
+	if len(u.stakers) > 0 {
+		o.Address = u.stakers[0].ID
+		o.URL = u.stakers[0].URL
+	}
+	return o
+}
+
+// uploadTo sends a data item to the backend it is routed to. Upload services hold a data
+// item alone and are not reserved in the contract. With the fallback on, transit keeps the
+// data items no staker or service takes and bundles them itself. The reports on the stakers
+// are queued with the order, or right away if the upload failed.
+func (srv *Server) uploadTo(backend string, id string, raw []byte, copies int) (*upload, error) {
+	u, err := srv.uploadToBackend(backend, id, raw, copies)
+	if u != nil {
+		u.staked = backend == ""
+	}
+	if err != nil && srv.fallback && fallback(err) {
+		log.Println("fallback: bundling", id, err)
+		return srv.selfBundle(id, raw, u), nil
+	}
+	if err != nil && u != nil && u.staked {
+		srv.report(id, u)
+	}
+	return u, err
+}
+
+// selfBundle keeps a data item for transit to bundle it
+func (srv *Server) selfBundle(id string, raw []byte, u *upload) *upload {
+	s := &upload{
+		backend:  schema.SelfBundled,
+		response: &bundler.DataItemPostResponse{ID: id, Owner: srv.paymentAddress},
+		attempts: []schema.Attempt{},
+		item:     &schema.DataItem{Id: id, Raw: raw},
+	}
+	if u != nil {
+		s.attempts = u.attempts
+		s.skipped = u.skipped
+		s.staked = u.staked
+	}
+	return s
+}
+
+// fallback tells whether a data item a backend did not take can be bundled by transit:
+// the contract assigned no staker or the stakers failed without rejecting it
+func fallback(err error) bool {
+	var initErr *initiateError
+	return errors.As(err, &initErr) || failover(err)
+}
+
+// createOrder creates the order of an upload along with its attempts, replicas and reports,
+// or its data item if transit bundles it
+func (srv *Server) createOrder(o *schema.Order, u *upload) error {
+	var reports []schema.Report
+	if u.staked {
+		reports = u.reports(o.Id)
+	}
+	if u.item != nil {
+		return srv.database.CreateSelfBundledOrder(o, u.attempts, u.item, reports)
+	}
+	return srv.database.CreateOrder(o, u.attempts, u.replicas(o.Id), reports)
+}
+
+func (srv *Server) uploadToBackend(backend string, id string, raw []byte, copies int) (*upload, error) {
+	if backend == "" {
+		return srv.upload(id, raw, copies)
+	}
+	u := &upload{backend: backend, attempts: []schema.Attempt{}}
+	res, err := srv.backends[backend].DataItemPost("", raw)
+	a := schema.Attempt{OrderId: id, Staker: backend}
+	if err != nil {
+		a.Error = err.Error()
+	}
+	u.attempts = append(u.attempts, a)
+	if err != nil {
+		log.Println(err)
+		return u, err
+	}
+	u.response = res
+	return u, nil
+}
+
+// upload reserves stakers for the data item in the contract and posts it to them until
+// copies of them hold it. When a staker fails, the contract is asked for another one, up to
+// the configured number of failed attempts. The contract can not be told which stakers to
+// leave out, so a staker already tried, down or quarantined for its poor health is skipped
+// and asked again. A reservation is released only while no staker holds the data item, as
+// releasing it gives up the reservations of every staker. Once a staker holds the data item
+// no more stakers are asked for after one is skipped or fails, whose reservation is left to
+// the reports. The upload succeeds once one staker holds the data item, with fewer replicas
+// than asked for if no more stakers accept it.
+func (srv *Server) upload(id string, raw []byte, copies int) (*upload, error) {
+	u := &upload{attempts: []schema.Attempt{}}
+	tried := map[string]bool{}
+	err := errNoStaker
+	for failures := 0; failures < srv.uploadAttempts && len(u.stakers) < copies; {
+		staker, initErr := srv.contract.Initiate(id, len(raw))
+		if initErr != nil {
+			if len(u.stakers) > 0 {
+				log.Println(initErr)
+				break
+			}
+			return nil, &initiateError{initErr}
+		}
+		srv.bundler.Track(staker.ID, staker.URL)
+		if tried[staker.ID] || !srv.bundler.Healthy(staker.URL) {
+			if len(u.stakers) > 0 {
+				if !tried[staker.ID] {
+					u.skipped = append(u.skipped, staker.ID)
+				}
+				break
+			}
+			srv.release(id)
+			failures++
+			continue
+		}
+		tried[staker.ID] = true
+
+		res, postErr := srv.bundler.DataItemPost(staker.URL, raw)
+		a := schema.Attempt{OrderId: id, Staker: staker.ID, URL: staker.URL}
+		if postErr == nil {
+			if len(u.stakers) == 0 {
+				u.response = res
+			}
+			u.stakers = append(u.stakers, staker)
+			u.attempts = append(u.attempts, a)
+			continue
+		}
+		a.Error = postErr.Error()
+		u.attempts = append(u.attempts, a)
+		err = postErr
+		log.Println(postErr)
+		failures++
+
+		if len(u.stakers) > 0 {
+			break
+		}
+		srv.release(id)
+		// Another staker would reject the data item as well
+		if !failover(postErr) {
+			break
+		}
+	}
+	if len(u.stakers) == 0 {
+		return u, err
+	}
+	return u, nil
+}
+
+// failover tells whether an upload a staker failed may be sent to another one
+func failover(err error) bool {
+	var e *bundler.Error
+	if errors.As(err, &e) && e.Status == http.StatusTooManyRequests {
+		return true
+	}
+	return !errors.Is(err, bundler.ErrClient)
+}
+
+// report queues the reports on an upload to the stakers that created no order, sent by the
+// send-reports job
+func (srv *Server) report(id string, u *upload) {
+	if err := srv.database.CreateReports(u.reports(id)); err != nil {
+		log.Println("fail: database - create reports", id, err)
+	}
+}
+
+func (srv *Server) release(id string) {
+	if err := srv.contract.Release(id); err != nil {
+		log.Println("fail: contract - release", id, err)
+	}
+}
+
+// initiateError is a failure of the contract to reserve a staker
+type initiateError struct {
+	err error
+}
+
+func (e *initiateError) Error() string {
+	return e.err.Error()
+}
+
+func (e *initiateError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,182 @@
+package signer
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/liteseed/goar/crypto"
+)
+
+// Config selects where a key is held, in a JWK file at Path or by an external signer at URL
+type Config struct {
+	Path  string
+	URL   string
+	Token string // bearer token sent to the external signer
+}
+
+// New loads the key described by c
+func New(c Config) (Signer, error) {
+	switch {
+	case c.URL != "":
+		return NewRemote(c.URL, c.Token)
+	case c.Path != "":
+		return FromPath(c.Path)
+	default:
+		return nil, errors.New("signer: no path or url")
+	}
+}
+
+type ownerResponse struct {
+	Owner string `json:"owner"`
+}
+
+type signRequest struct {
+	Payload string `json:"payload"`
+}
+
+type signResponse struct {
+	Signature string `json:"signature"`
+}
+
+// Remote is a key held by an external signer that transit reaches over HTTP:
+//
+//	GET  /owner  -> {"owner": "<base64url modulus>"}
+//	POST /sign   {"payload": "<base64url>"} -> {"signature": "<base64url>"}
+//
+// Signatures are verified against the owner before they are used.
+type Remote struct {
+	client    *http.Client
+	url       string
+	token     string
+	address   string
+	owner     string
+	publicKey *rsa.PublicKey
+}
+
+// NewRemote asks the external signer at u for its public key
+func NewRemote(u string, token string) (*Remote, error) {
+	r := &Remote{client: &http.Client{Timeout: 30 * time.Second}, url: u, token: token}
+	var res ownerResponse
+	err := r.do(http.MethodGet, "owner", nil, &res)
+	if err != nil {
+		return nil, err
+	}
+	r.publicKey, err = crypto.GetPublicKeyFromOwner(res.Owner)
+	if err != nil {
+		return nil, err
+	}
+	r.owner = res.Owner
+	r.address = crypto.GetAddressFromPublicKey(r.publicKey)
+	return r, nil
+}
+
+func (r *Remote) Address() string {
+	return r.address
+}
+
+func (r *Remote) Owner() string {
+	return r.owner
+}
+
+func (r *Remote) Sign(payload []byte) ([]byte, error) {
+	var res signResponse
+	err := r.do(http.MethodPost, "sign", &signRequest{Payload: crypto.Base64URLEncode(payload)}, &res)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := crypto.Base64URLDecode(res.Signature)
+	if err != nil {
+		return nil, err
+	}
+	err = crypto.Verify(payload, signature, r.publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("signer: invalid signature: %w", err)
+	}
+	return signature, nil
+}
+
+func (r *Remote) do(method string, path string, body any, v any) error {
+	u, err := url.JoinPath(r.url, path)
+	if err != nil {
+		return err
+	}
+	var payload io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		payload = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, u, payload)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("content-type", "application/json")
+	if r.token != "" {
+		req.Header.Set("authorization", "Bearer "+r.token)
+	}
+	res, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("signer: %d: %s", res.StatusCode, string(b))
+	}
+	return json.Unmarshal(b, v)
+}
+
+// Handler serves s with the protocol of Remote, e.g. to stand in for an external signer.
+// Requests must carry token as a bearer token if one is set.
+func Handler(s Signer, token string) http.Handler {
+	mux := http.NewServeMux()
+	auth := func(w http.ResponseWriter, r *http.Request) bool {
+		if token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("authorization")), []byte("Bearer "+token)) != 1 {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return false
+		}
+		return true
+	}
+	mux.HandleFunc("GET /owner", func(w http.ResponseWriter, r *http.Request) {
+		if !auth(w, r) {
+			return
+		}
+		_ = json.NewEncoder(w).Encode(&ownerResponse{Owner: s.Owner()})
+	})
+	mux.HandleFunc("POST /sign", func(w http.ResponseWriter, r *http.Request) {
+		if !auth(w, r) {
+			return
+		}
+		var req signRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		payload, err := crypto.Base64URLDecode(req.Payload)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		signature, err := s.Sign(payload)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(&signResponse{Signature: crypto.Base64URLEncode(signature)})
+	})
+	return mux
+}
@@ -0,0 +1,175 @@
+// Package signer signs transactions and data items for a wallet whose key may be held
+// outside of transit.
+package signer
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/liteseed/goar/crypto"
+	goar "github.com/liteseed/goar/signer"
+	"github.com/liteseed/goar/tag"
+	"github.com/liteseed/goar/transaction"
+	"github.com/liteseed/goar/transaction/data_item"
+)
+
+// Signer holds an Arweave key. Sign returns the RSA-PSS signature of the SHA-256 digest of
+// payload, as Arweave expects it.
+type Signer interface {
+	Address() string
+	Owner() string
+	Sign(payload []byte) ([]byte, error)
+}
+
+// File is a key loaded from a JWK file
+type File struct {
+	s *goar.Signer
+}
+
+func FromPath(path string) (*File, error) {
+	s, err := goar.FromPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return &File{s: s}, nil
+}
+
+// FromSigner wraps a key already loaded by goar
+func FromSigner(s *goar.Signer) *File {
+	return &File{s: s}
+}
+
+func (f *File) Address() string {
+	return f.s.Address
+}
+
+func (f *File) Owner() string {
+	return f.s.Owner()
+}
+
+func (f *File) Sign(payload []byte) ([]byte, error) {
+	return crypto.Sign(payload, f.s.PrivateKey)
+}
+
+// Signer returns the goar key, for the libraries that sign with it directly
+func (f *File) Signer() *goar.Signer {
+	return f.s
+}
+
+// SignTransaction signs a transaction whose anchor and reward are set
+func SignTransaction(s Signer, tx *transaction.Transaction) error {
+	if tx.Format != 2 {
+		return errors.New("only type 2 transaction supported")
+	}
+	tx.Owner = s.Owner()
+	rawOwner, err := crypto.Base64URLDecode(tx.Owner)
+	if err != nil {
+		return err
+	}
+	rawTarget, err := crypto.Base64URLDecode(tx.Target)
+	if err != nil {
+		return err
+	}
+	rawTags, err := tag.Decode(tx.Tags)
+	if err != nil {
+		return err
+	}
+	rawLastTx, err := crypto.Base64URLDecode(tx.LastTx)
+	if err != nil {
+		return err
+	}
+	data, err := crypto.Base64URLDecode(tx.Data)
+	if err != nil {
+		return err
+	}
+	err = tx.PrepareChunks(data)
+	if err != nil {
+		return err
+	}
+	rawDataRoot, err := crypto.Base64URLDecode(tx.DataRoot)
+	if err != nil {
+		return err
+	}
+
+	payload := crypto.DeepHash([]any{
+		[]byte("2"),
+		rawOwner,
+		rawTarget,
+		[]byte(tx.Quantity),
+		[]byte(tx.Reward),
+		rawLastTx,
+		rawTags,
+		[]byte(tx.DataSize),
+		rawDataRoot,
+	})
+	signature, err := s.Sign(payload[:])
+	if err != nil {
+		return err
+	}
+	tx.ID = crypto.Base64URLEncode(crypto.SHA256(signature))
+	tx.Signature = crypto.Base64URLEncode(signature)
+	return nil
+}
+
+// SignDataItem signs a data item and encodes it, see ANS-104
+func SignDataItem(s Signer, d *data_item.DataItem) error {
+	d.Owner = s.Owner()
+	rawOwner, err := crypto.Base64URLDecode(d.Owner)
+	if err != nil {
+		return err
+	}
+	rawTarget, err := crypto.Base64URLDecode(d.Target)
+	if err != nil {
+		return err
+	}
+	rawAnchor := []byte(d.Anchor)
+	rawTags, err := tag.Serialize(d.Tags)
+	if err != nil {
+		return err
+	}
+	rawData, err := crypto.Base64URLDecode(d.Data)
+	if err != nil {
+		return err
+	}
+
+	payload := crypto.DeepHash([][]byte{
+		[]byte("dataitem"),
+		[]byte("1"),
+		[]byte("1"),
+		rawOwner,
+		rawTarget,
+		rawAnchor,
+		rawTags,
+		rawData,
+	})
+	signature, err := s.Sign(payload[:])
+	if err != nil {
+		return err
+	}
+
+	raw := binary.LittleEndian.AppendUint16(nil, uint16(data_item.Arweave))
+	raw = append(raw, signature...)
+	raw = append(raw, rawOwner...)
+	if d.Target == "" {
+		raw = append(raw, 0)
+	} else {
+		raw = append(raw, 1)
+	}
+	raw = append(raw, rawTarget...)
+	if d.Anchor == "" {
+		raw = append(raw, 0)
+	} else {
+		raw = append(raw, 1)
+	}
+	raw = append(raw, rawAnchor...)
+	raw = binary.LittleEndian.AppendUint64(raw, uint64(len(*d.Tags)))
+	raw = binary.LittleEndian.AppendUint64(raw, uint64(len(rawTags)))
+	raw = append(raw, rawTags...)
+	raw = append(raw, rawData...)
+
+	d.SignatureType = data_item.Arweave
+	d.Signature = crypto.Base64URLEncode(signature)
+	d.ID = crypto.Base64URLEncode(crypto.SHA256(signature))
+	d.Raw = raw
+	return nil
+}
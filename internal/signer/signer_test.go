@@ -0,0 +1,78 @@
+package signer
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/liteseed/goar/tag"
+	"github.com/liteseed/goar/transaction"
+	"github.com/liteseed/goar/transaction/data_item"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFile(t *testing.T) {
+	s, err := FromPath("../../test/signer.json")
+	assert.NoError(t, err)
+	assert.Equal(t, "3XTR7MsJUD9LoaiFRdWswzX1X5BR7AQdl1x2v2zIVck", s.Address())
+
+	tx := transaction.New([]byte("data"), "3XTR7MsJUD9LoaiFRdWswzX1X5BR7AQdl1x2v2zIVck", "1000", &[]tag.Tag{{Name: "App-Name", Value: "transit"}})
+	tx.LastTx = "anchor"
+	tx.Reward = "100"
+	assert.NoError(t, SignTransaction(s, tx))
+	assert.NoError(t, tx.Verify())
+
+	d := data_item.New([]byte("data"), "", "", &[]tag.Tag{{Name: "App-Name", Value: "transit"}})
+	assert.NoError(t, SignDataItem(s, d))
+	assert.NoError(t, d.Verify())
+
+	// The encoding matches the one of goar
+	decoded, err := data_item.Decode(d.Raw)
+	assert.NoError(t, err)
+	assert.Equal(t, d.ID, decoded.ID)
+	assert.Equal(t, d.Owner, decoded.Owner)
+	assert.Equal(t, d.Data, decoded.Data)
+	assert.Equal(t, *d.Tags, *decoded.Tags)
+}
+
+func TestRemote(t *testing.T) {
+	file, err := FromPath("../../test/signer.json")
+	assert.NoError(t, err)
+	external := httptest.NewServer(Handler(file, "secret"))
+	defer external.Close()
+
+	_, err = NewRemote(external.URL, "wrong")
+	assert.Error(t, err)
+
+	s, err := New(Config{URL: external.URL, Token: "secret"})
+	assert.NoError(t, err)
+	assert.Equal(t, file.Address(), s.Address())
+
+	tx := transaction.New(nil, "3XTR7MsJUD9LoaiFRdWswzX1X5BR7AQdl1x2v2zIVck", "1000", nil)
+	tx.Reward = "100"
+	assert.NoError(t, SignTransaction(s, tx))
+	assert.NoError(t, tx.Verify())
+
+	d := data_item.New([]byte("data"), "", "", nil)
+	assert.NoError(t, SignDataItem(s, d))
+	assert.NoError(t, d.Verify())
+}
+
+type forged struct {
+	*File
+}
+
+func (f *forged) Sign(payload []byte) ([]byte, error) {
+	return []byte("forged"), nil
+}
+
+func TestRemoteInvalidSignature(t *testing.T) {
+	file, err := FromPath("../../test/signer.json")
+	assert.NoError(t, err)
+	external := httptest.NewServer(Handler(&forged{file}, ""))
+	defer external.Close()
+
+	s, err := NewRemote(external.URL, "")
+	assert.NoError(t, err)
+	_, err = s.Sign([]byte("payload"))
+	assert.Error(t, err)
+}
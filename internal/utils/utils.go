@@ -1,33 +1,121 @@
 package utils
 
 import (
-	"math/big"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
 	"net/url"
-
-	"github.com/gin-gonic/gin"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
-func CalculatePriceWithFee(p string) string {
-	cost := big.NewInt(0)
-	cost.SetString(p, 10)
+var ErrURLNotAllowed = errors.New("url not allowed")
+
+// URLPolicy decides which staker URLs transit sends requests to. Staker URLs come from the
+// contract, so a staker could point transit at an internal address. Private, loopback and
+// link-local addresses are refused unless AllowPrivate is set, both in the URL and when
+// dialing, so a host name resolving to such an address is refused as well.
+type URLPolicy struct {
+	Schemes      []string // schemes allowed, https only if empty
+	Scheme       string   // scheme of the URLs given without one, https if empty
+	Ports        []int    // ports allowed, any if empty
+	AllowPrivate bool     // allow private and loopback addresses, for development
+}
+
+var (
+	DefaultURLPolicy = URLPolicy{Schemes: []string{"https"}, Scheme: "https"}
+	// DevURLPolicy allows stakers running on the local machine or network over http
+	DevURLPolicy = URLPolicy{Schemes: []string{"http", "https"}, Scheme: "http", AllowPrivate: true}
+)
 
-	fee := big.NewInt(1000) // ~0.001
-	fee.Quo(cost, fee)
+// Parse checks a staker URL against the policy and returns it with its scheme
+func (p URLPolicy) Parse(raw string) (string, error) {
+	if !strings.Contains(raw, "://") {
+		raw = p.scheme() + "://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrURLNotAllowed, err)
+	}
+	if !slices.Contains(p.schemes(), u.Scheme) {
+		return "", fmt.Errorf("%w: scheme %q", ErrURLNotAllowed, u.Scheme)
+	}
+	if u.Hostname() == "" || u.User != nil {
+		return "", fmt.Errorf("%w: %s", ErrURLNotAllowed, u.Redacted())
+	}
+	if len(p.Ports) > 0 {
+		port, err := strconv.Atoi(u.Port())
+		if u.Port() == "" {
+			port, err = defaultPort(u.Scheme), nil
+		}
+		if err != nil || !slices.Contains(p.Ports, port) {
+			return "", fmt.Errorf("%w: port of %s", ErrURLNotAllowed, u.Host)
+		}
+	}
+	if !p.AllowPrivate {
+		host := strings.ToLower(u.Hostname())
+		if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+			return "", fmt.Errorf("%w: host %s", ErrURLNotAllowed, host)
+		}
+		if ip := net.ParseIP(host); ip != nil && private(ip) {
+			return "", fmt.Errorf("%w: address %s", ErrURLNotAllowed, host)
+		}
+	}
+	return u.String(), nil
+}
 
-	cost.Add(cost, fee) // cost = cost + cost * fee
-	return cost.String()
+// Transport returns a transport that only dials the addresses the policy allows. It does
+// not go through a proxy, as the policy checks the address of the staker itself.
+func (p URLPolicy) Transport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = nil
+	d := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: p.control}
+	t.DialContext = func(ctx context.Context, network string, address string) (net.Conn, error) {
+		return d.DialContext(ctx, network, address)
+	}
+	return t
 }
 
-func ParseUrl(u string) (string, error) {
-	if gin.Mode() == gin.DebugMode {
-		return "http://" + u, nil
+func (p URLPolicy) control(network string, address string, _ syscall.RawConn) error {
+	if p.AllowPrivate {
+		return nil
 	}
-	p, err := url.Parse(u)
+	host, _, err := net.SplitHostPort(address)
 	if err != nil {
-		return "", err
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || private(ip) {
+		return fmt.Errorf("%w: address %s", ErrURLNotAllowed, host)
+	}
+	return nil
+}
+
+func (p URLPolicy) schemes() []string {
+	if len(p.Schemes) == 0 {
+		return DefaultURLPolicy.Schemes
 	}
+	return p.Schemes
+}
+
+func (p URLPolicy) scheme() string {
+	if p.Scheme == "" {
+		return DefaultURLPolicy.Scheme
+	}
+	return p.Scheme
+}
 
-	p.Scheme = "https"
+func defaultPort(scheme string) int {
+	if scheme == "http" {
+		return 80
+	}
+	return 443
+}
 
-	return p.String(), nil
+func private(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified()
 }
@@ -2,11 +2,14 @@ package test
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/liteseed/goar/crypto"
@@ -100,3 +103,45 @@ func MU() *httptest.Server {
 		_, _ = w.Write([]byte(`{"id":"id", "message": ""}`))
 	}))
 }
+
+// Process stands in for the AO process of the contract: it takes the messages sent to it
+// through the MU at its URL and records them. While Down is set it refuses them.
+type Process struct {
+	*httptest.Server
+	Down atomic.Bool
+
+	mu       sync.Mutex
+	messages []data_item.DataItem
+}
+
+func NewProcess() *Process {
+	p := &Process{}
+	p.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p.Down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		raw, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		d, err := data_item.Decode(raw)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		p.mu.Lock()
+		p.messages = append(p.messages, *d)
+		p.mu.Unlock()
+		_, _ = w.Write([]byte(fmt.Sprintf(`{"id":"%s", "message": ""}`, d.ID)))
+	}))
+	return p
+}
+
+// Messages returns the messages the process took, in order
+func (p *Process) Messages() []data_item.DataItem {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]data_item.DataItem{}, p.messages...)
+}